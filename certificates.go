@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// selfSignedValidity is how long a generated certificate is valid for
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// certificates are the certificate for the listener and the CA bundle used
+// to verify clients. The files are read again when they change so that
+// certificates can be rotated without restarting the service.
+type certificates struct {
+	certLocation string
+	keyLocation  string
+	caLocation   string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	// selfSignedCA is the PEM encoded CA which signed a generated
+	// certificate
+	selfSignedCA []byte
+}
+
+// loadCertificates reads the certificate, key and CA bundle, caLocation is
+// optional
+func loadCertificates(certLocation, keyLocation, caLocation string) (*certificates, error) {
+	c := &certificates{
+		certLocation: certLocation,
+		keyLocation:  keyLocation,
+		caLocation:   caLocation,
+	}
+
+	return c, c.load()
+}
+
+// newSelfSignedCertificates generates a CA and a certificate for the hosts
+// signed by the CA, the certificates are only held in memory. caLocation is
+// the optional CA bundle used to verify clients.
+func newSelfSignedCertificates(hosts []string, caLocation string) (*certificates, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	ca := &x509.Certificate{
+		SerialNumber:          serialNumber(),
+		Subject:               pkix.Name{CommonName: "Fake Service CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create CA: %s", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := &x509.Certificate{
+		SerialNumber: serialNumber(),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			leaf.IPAddresses = append(leaf.IPAddresses, ip)
+		} else {
+			leaf.DNSNames = append(leaf.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create certificate: %s", err)
+	}
+
+	c := &certificates{
+		caLocation:   caLocation,
+		certificate:  &tls.Certificate{Certificate: [][]byte{der, caDER}, PrivateKey: key},
+		selfSignedCA: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+	}
+
+	return c, c.load()
+}
+
+// selfSignedHosts returns the names and addresses added to a generated
+// certificate, the service name, the hostname, localhost and the IP
+// addresses of the network interfaces
+func selfSignedHosts(name string, extra []string) []string {
+	hosts := []string{name}
+	if h, err := os.Hostname(); err == nil {
+		hosts = append(hosts, h)
+	}
+
+	hosts = append(hosts, "localhost", "127.0.0.1", "::1")
+	hosts = append(hosts, extra...)
+
+	addrs, _ := net.InterfaceAddrs()
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && !ipn.IP.IsLoopback() {
+			hosts = append(hosts, ipn.IP.String())
+		}
+	}
+
+	return hosts
+}
+
+func serialNumber() *big.Int {
+	n, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return n
+}
+
+// load reads the certificate and CA bundle files, the current certificates
+// are kept when the files can not be read
+func (c *certificates) load() error {
+	var cert *tls.Certificate
+	if c.certLocation != "" {
+		kp, err := tls.LoadX509KeyPair(c.certLocation, c.keyLocation)
+		if err != nil {
+			return err
+		}
+
+		cert = &kp
+	}
+
+	var pool *x509.CertPool
+	if c.caLocation != "" {
+		pem, err := ioutil.ReadFile(c.caLocation)
+		if err != nil {
+			return fmt.Errorf("unable to read CA bundle: %s", err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA bundle %s", c.caLocation)
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if cert != nil {
+		c.certificate = cert
+	}
+
+	c.clientCAs = pool
+
+	return nil
+}
+
+// current returns the certificate and CA bundle
+func (c *certificates) current() (*tls.Certificate, *x509.CertPool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.certificate, c.clientCAs
+}
+
+// watch the certificate files and reload them when they change, the returned
+// function stops watching
+func (c *certificates) watch(interval time.Duration, logger *logging.Logger) func() {
+	files := func() []string {
+		return []string{c.certLocation, c.keyLocation, c.caLocation}
+	}
+
+	return config.Watch(interval, files, func() {
+		if err := c.load(); err != nil {
+			logger.Log().Error("Unable to reload certificates", "error", err)
+			return
+		}
+
+		logger.Log().Info("Reloaded certificates", "cert", c.certLocation, "ca", c.caLocation)
+	})
+}
+
+// HandleCA returns the PEM encoded CA which signed the generated certificate
+func (c *certificates) HandleCA(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/x-pem-file")
+	rw.Write(c.selfSignedCA)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/nicholasjackson/fake-service/grpc/api"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -15,12 +16,22 @@ type GRPC interface {
 	Handle(context.Context, *api.Request) (*api.Response, map[string]string, error)
 }
 
-// NewGRPC creates a new GRPC client
-func NewGRPC(uri string, timeout time.Duration) (GRPC, error) {
+// NewGRPC creates a new GRPC client, when tlsOptions is nil the connection
+// to the upstream is not encrypted
+func NewGRPC(uri string, timeout time.Duration, tlsOptions *TLSOptions) (GRPC, error) {
+	creds := grpc.WithInsecure()
+	if tlsOptions != nil {
+		c, err := NewTLSConfig(*tlsOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(c))
+	}
 
 	conn, err := grpc.Dial(
 		uri,
-		grpc.WithInsecure(),
+		creds,
 		grpc.WithTimeout(timeout),
 	)
 
@@ -28,12 +39,19 @@ func NewGRPC(uri string, timeout time.Duration) (GRPC, error) {
 		return nil, err
 	}
 
-	return &GRPCImpl{api.NewFakeServiceClient(conn)}, nil
+	return &GRPCImpl{client: api.NewFakeServiceClient(conn), tlsOptions: tlsOptions}, nil
 }
 
 // GRPCImpl is the concrete implementation of the GRPC client
 type GRPCImpl struct {
-	client api.FakeServiceClient
+	client     api.FakeServiceClient
+	tlsOptions *TLSOptions
+}
+
+// TLSOptions returns the options used to secure the connection, nil is
+// returned when the connection is not encrypted
+func (c *GRPCImpl) TLSOptions() *TLSOptions {
+	return c.tlsOptions
 }
 
 // Handle calls the upstream client
@@ -1,43 +1,88 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"golang.org/x/net/http2"
+)
+
+// Protocols used to call HTTP upstreams
+const (
+	// HTTP1 calls upstreams using HTTP/1.1
+	HTTP1 = "http1"
+	// HTTP2 calls upstreams using HTTP/2, it is negotiated using ALPN for
+	// https:// upstreams and h2c with prior knowledge is used for http://
+	// upstreams
+	HTTP2 = "http2"
+	// HTTP3 calls h3:// upstreams using HTTP/3 over QUIC
+	HTTP3 = "http3"
 )
 
 // HTTP defines an interface for upstream HTTP client requests
 type HTTP interface {
-	Do(r *http.Request, pr *http.Request) (int, []byte, map[string]string, map[string]string, error)
+	Do(r *http.Request, pr *http.Request) (int, []byte, map[string]string, map[string]string, string, error)
 }
 
 // HTTPImpl is the concrete implementation of the HTTP interface
 type HTTPImpl struct {
 	defaultClient *http.Client
-	appendRequest bool // should we append the headers path and query from the original request
+	appendRequest bool  // should we append the headers path and query from the original request
+	expectedCodes []int // status codes which are treated as a successful response
 }
 
-// NewHTTP creates a new HTTP client
-func NewHTTP(upstreamClientKeepAlives bool, appendRequest bool, timeOut time.Duration, allowInsecure bool) HTTP {
+// NewHTTP creates a new HTTP client, when expectedCodes is empty only a 200
+// response is treated as successful. protocol is HTTP1, HTTP2 or HTTP3.
+func NewHTTP(upstreamClientKeepAlives bool, appendRequest bool, timeOut time.Duration, allowInsecure bool, expectedCodes []int, protocol string) HTTP {
+	if len(expectedCodes) == 0 {
+		expectedCodes = []int{http.StatusOK}
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		DisableKeepAlives: !upstreamClientKeepAlives,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: allowInsecure},
+	}
+
+	if protocol == HTTP2 {
+		t := newHTTP2Transport(&tls.Config{InsecureSkipVerify: allowInsecure})
+		t.disableKeepAlives = !upstreamClientKeepAlives
+
+		transport = t
+	}
+
+	if protocol == HTTP3 {
+		transport = &http3Transport{
+			RoundTripper: &http3.RoundTripper{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: allowInsecure},
+			},
+			disableKeepAlives: !upstreamClientKeepAlives,
+		}
+	}
+
 	client := &http.Client{
-		Transport: &http.Transport{
-			DisableKeepAlives: !upstreamClientKeepAlives,
-			TLSClientConfig:   &tls.Config{InsecureSkipVerify: allowInsecure},
-		},
-		Timeout: timeOut,
+		Transport: transport,
+		Timeout:   timeOut,
 	}
 
 	return &HTTPImpl{
 		defaultClient: client,
 		appendRequest: appendRequest,
+		expectedCodes: expectedCodes,
 	}
 }
 
-// Do makes the upstream request and returns a response
-func (h *HTTPImpl) Do(r *http.Request, pr *http.Request) (int, []byte, map[string]string, map[string]string, error) {
+// Do makes the upstream request and returns a response, the protocol
+// returned is the one used for the request, e.g. HTTP/2.0
+func (h *HTTPImpl) Do(r *http.Request, pr *http.Request) (int, []byte, map[string]string, map[string]string, string, error) {
 	var data []byte
 
 	// do we need to append the headers, path and querystring from the original request?
@@ -49,20 +94,20 @@ func (h *HTTPImpl) Do(r *http.Request, pr *http.Request) (int, []byte, map[strin
 	// call the upstream service
 	resp, err := h.defaultClient.Do(r)
 	if err != nil {
-		return -1, nil, nil, nil, fmt.Errorf("Error communicating with upstream service: %s", err)
+		return -1, nil, nil, nil, "", fmt.Errorf("Error communicating with upstream service: %s", err)
 	}
 
 	defer resp.Body.Close()
 
 	data, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return resp.StatusCode, nil, nil, nil, fmt.Errorf("Error reading response body: %d", err)
+		return resp.StatusCode, nil, nil, nil, resp.Proto, fmt.Errorf("Error reading response body: %d", err)
 	}
 
 	var statusError error
-	if resp.StatusCode != http.StatusOK {
+	if !h.expected(resp.StatusCode) {
 		// if a request err
-		statusError = fmt.Errorf("Error processing upstream request: %s, expected code 200, got %d", r.URL.String(), resp.StatusCode)
+		statusError = fmt.Errorf("Error processing upstream request: %s, expected code %s, got %d", r.URL.String(), formatCodes(h.expectedCodes), resp.StatusCode)
 	}
 
 	headers := map[string]string{}
@@ -75,7 +120,135 @@ func (h *HTTPImpl) Do(r *http.Request, pr *http.Request) (int, []byte, map[strin
 		cookies[c.Name] = c.Value
 	}
 
-	return resp.StatusCode, data, headers, cookies, statusError
+	return resp.StatusCode, data, headers, cookies, resp.Proto, statusError
+}
+
+// http2Transport sends requests using HTTP/2, TLS connections must negotiate
+// HTTP/2 and connections which are not encrypted use h2c
+type http2Transport struct {
+	tls *http2.Transport
+	h2c *http2.Transport
+	// disableKeepAlives sends each request on a new connection which is
+	// closed once the response has been read
+	disableKeepAlives bool
+}
+
+// newHTTP2Transport creates a HTTP/2 transport which uses the TLS config for
+// https:// upstreams
+func newHTTP2Transport(tlsConfig *tls.Config) *http2Transport {
+	return &http2Transport{
+		tls: &http2.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			// the connection is not encrypted, HTTP/2 is used without
+			// negotiating it
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *http2Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// the connections of a transport are shared by all requests, a transport
+	// is created for the request so that only its connection is closed
+	if t.disableKeepAlives {
+		rt := newHTTP2Transport(t.tls.TLSClientConfig)
+		resp, err := rt.RoundTrip(r)
+
+		return closeWithBody(resp, err, rt.CloseIdleConnections)
+	}
+
+	if r.URL.Scheme == "http" {
+		return t.h2c.RoundTrip(r)
+	}
+
+	return t.tls.RoundTrip(r)
+}
+
+// CloseIdleConnections closes the idle connections for both transports
+func (t *http2Transport) CloseIdleConnections() {
+	t.tls.CloseIdleConnections()
+	t.h2c.CloseIdleConnections()
+}
+
+// http3Transport sends requests for h3:// upstreams using HTTP/3
+type http3Transport struct {
+	*http3.RoundTripper
+	// disableKeepAlives sends each request on a new QUIC connection which is
+	// closed with the response body
+	disableKeepAlives bool
+}
+
+// RoundTrip implements the http.RoundTripper interface, h3:// URIs are called
+// using https
+func (t *http3Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Scheme == "h3" {
+		r = r.Clone(r.Context())
+		r.URL.Scheme = "https"
+	}
+
+	if !t.disableKeepAlives {
+		return t.RoundTripper.RoundTrip(r)
+	}
+
+	// the connections of a round tripper are shared by all requests, a round
+	// tripper is created for the request so that only its connection is closed
+	rt := &http3.RoundTripper{TLSClientConfig: t.TLSClientConfig}
+	resp, err := rt.RoundTrip(r)
+
+	return closeWithBody(resp, err, func() { rt.Close() })
+}
+
+// closeWithBody calls close when the body of the response is closed, or
+// immediately when the request failed
+func closeWithBody(resp *http.Response, err error, close func()) (*http.Response, error) {
+	if err != nil {
+		close()
+		return nil, err
+	}
+
+	resp.Body = &closingBody{ReadCloser: resp.Body, close: close}
+
+	return resp, nil
+}
+
+// closingBody is a response body which calls close when it is closed
+type closingBody struct {
+	io.ReadCloser
+	close func()
+}
+
+// Close closes the body and calls close
+func (b *closingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.close()
+
+	return err
+}
+
+// expected returns true when the status code is a successful response
+func (h *HTTPImpl) expected(code int) bool {
+	for _, c := range h.expectedCodes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+func formatCodes(codes []int) string {
+	s := []string{}
+	for _, c := range codes {
+		s = append(s, strconv.Itoa(c))
+	}
+
+	return strings.Join(s, ",")
 }
 
 // appendHeaders from the original request
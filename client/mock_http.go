@@ -12,12 +12,12 @@ type MockHTTP struct {
 }
 
 // Do implements the HTTP interface method
-func (m *MockHTTP) Do(r, pr *http.Request) (int, []byte, map[string]string, map[string]string, error) {
+func (m *MockHTTP) Do(r, pr *http.Request) (int, []byte, map[string]string, map[string]string, string, error) {
 	args := m.Called(r, pr)
 
 	if d := args.Get(1); d != nil {
-		return args.Int(0), d.([]byte), nil, nil, args.Error(2)
+		return args.Int(0), d.([]byte), nil, nil, "HTTP/1.1", args.Error(2)
 	}
 
-	return args.Int(0), nil, nil, nil, args.Error(2)
+	return args.Int(0), nil, nil, nil, "", args.Error(2)
 }
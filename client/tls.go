@@ -0,0 +1,56 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSOptions define how the connection to an upstream is secured
+type TLSOptions struct {
+	// CALocation is the PEM encoded CA bundle used to verify the upstream,
+	// when not set the system roots are used
+	CALocation string
+	// CertLocation and KeyLocation are the PEM encoded certificate and key
+	// presented to the upstream for mutual TLS
+	CertLocation string
+	KeyLocation  string
+	// ServerName overrides the name used for SNI and to verify the upstream
+	// certificate, when not set the host from the URI is used
+	ServerName string
+	// AllowInsecure skips the verification of the upstream certificate
+	AllowInsecure bool
+}
+
+// NewTLSConfig creates the tls.Config for the options, the certificates are
+// read when the config is created
+func NewTLSConfig(o TLSOptions) (*tls.Config, error) {
+	c := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.AllowInsecure,
+	}
+
+	if o.CALocation != "" {
+		pem, err := ioutil.ReadFile(o.CALocation)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %s", err)
+		}
+
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", o.CALocation)
+		}
+	}
+
+	if o.CertLocation != "" || o.KeyLocation != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertLocation, o.KeyLocation)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %s", err)
+		}
+
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
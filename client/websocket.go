@@ -0,0 +1,22 @@
+package client
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// NewWebSocketDialer creates the dialer used to connect to upstream WebSocket
+// services, the TLS options are used for wss:// upstreams
+func NewWebSocketDialer(timeout time.Duration, tlsOptions TLSOptions) (*websocket.Dialer, error) {
+	c, err := NewTLSConfig(tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &websocket.Dialer{
+		Proxy:            websocket.DefaultDialer.Proxy,
+		HandshakeTimeout: timeout,
+		TLSClientConfig:  c,
+	}, nil
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CircuitBreakerPolicy defines when calls to an upstream are stopped because
+// the upstream is failing, at least one of ConsecutiveFailures or ErrorRate
+// must be set
+type CircuitBreakerPolicy struct {
+	// ConsecutiveFailures is the number of failed calls in a row which open
+	// the circuit breaker
+	ConsecutiveFailures int `yaml:"consecutive_failures,omitempty" json:"consecutive_failures,omitempty"`
+	// ErrorRate is the ratio of failed calls between 0 and 1 which opens the
+	// circuit breaker
+	ErrorRate float64 `yaml:"error_rate,omitempty" json:"error_rate,omitempty"`
+	// MinRequests is the number of calls which must be made in the window
+	// before the error rate is checked, default 10
+	MinRequests int `yaml:"min_requests,omitempty" json:"min_requests,omitempty"`
+	// Window is the interval the error rate is calculated over, default 10s
+	Window Duration `yaml:"window,omitempty" json:"window,omitempty"`
+	// OpenDuration is the time the circuit breaker stays open before calls
+	// are allowed to probe the upstream, default 5s
+	OpenDuration Duration `yaml:"open_duration,omitempty" json:"open_duration,omitempty"`
+	// HalfOpenRequests is the number of probe calls which must succeed
+	// before the circuit breaker closes, default 1
+	HalfOpenRequests int `yaml:"half_open_requests,omitempty" json:"half_open_requests,omitempty"`
+}
+
+// circuitBreakerPolicy has the same fields as CircuitBreakerPolicy without the
+// custom encoding
+type circuitBreakerPolicy CircuitBreakerPolicy
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (c *CircuitBreakerPolicy) UnmarshalYAML(n *yaml.Node) error {
+	if err := checkFields(n, reflect.TypeOf(circuitBreakerPolicy{}), "circuit_breaker"); err != nil {
+		return err
+	}
+
+	cb := circuitBreakerPolicy{}
+	if err := n.Decode(&cb); err != nil {
+		return err
+	}
+
+	*c = CircuitBreakerPolicy(cb)
+
+	return nil
+}
+
+// validate the circuit breaker policy
+func (c CircuitBreakerPolicy) validate() error {
+	if c.ConsecutiveFailures <= 0 && c.ErrorRate <= 0 {
+		return fmt.Errorf("circuit_breaker must set consecutive_failures or error_rate")
+	}
+
+	if c.ConsecutiveFailures < 0 || c.MinRequests < 0 || c.HalfOpenRequests < 0 {
+		return fmt.Errorf("circuit_breaker counts must be greater than or equal to 0")
+	}
+
+	if c.ErrorRate < 0 || c.ErrorRate > 1 {
+		return fmt.Errorf("circuit_breaker error_rate must be between 0 and 1, got %v", c.ErrorRate)
+	}
+
+	if c.Window < 0 || c.OpenDuration < 0 {
+		return fmt.Errorf("circuit_breaker intervals must be greater than or equal to 0")
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package config
+
+// Config defines the structure of the configuration file, every value maps
+// to one of the environment variables which can be used to configure the
+// service. Values set in the environment take precedence over values set in
+// the file. Values tagged with restart can only be changed by restarting the
+// service.
+type Config struct {
+	Name       string `yaml:"name" json:"name" env:"NAME" restart:"true"`
+	Message    string `yaml:"message" json:"message" env:"MESSAGE"`
+	ListenAddr string `yaml:"listen_addr" json:"listen_addr" env:"LISTEN_ADDR" restart:"true"`
+	RoutesFile string `yaml:"routes_file" json:"routes_file" env:"ROUTES_FILE"`
+	UIPath     string `yaml:"ui_path" json:"ui_path" env:"UI_PATH" restart:"true"`
+	Seed       int    `yaml:"seed" json:"seed" env:"RAND_SEED"`
+
+	Upstream   Upstream       `yaml:"upstream" json:"upstream"`
+	HTTPClient HTTPClient     `yaml:"http_client" json:"http_client"`
+	HTTPServer HTTPServer     `yaml:"http_server" json:"http_server"`
+	CORS       CORS           `yaml:"cors" json:"cors"`
+	Timing     Timing         `yaml:"timing" json:"timing"`
+	Errors     Errors         `yaml:"errors" json:"errors"`
+	RateLimit  RateLimit      `yaml:"rate_limit" json:"rate_limit"`
+	GRPCStream GRPCStream     `yaml:"grpc_stream" json:"grpc_stream"`
+	WebSocket  WebSocket      `yaml:"websocket" json:"websocket"`
+	SSE        SSE            `yaml:"sse" json:"sse"`
+	Raw        Raw            `yaml:"raw" json:"raw"`
+	Load       LoadGeneration `yaml:"load" json:"load"`
+	Tracing    Tracing        `yaml:"tracing" json:"tracing"`
+	Metrics    Metrics        `yaml:"metrics" json:"metrics"`
+	Logging    Logging        `yaml:"logging" json:"logging"`
+	TLS        TLS            `yaml:"tls" json:"tls"`
+	Health     Health         `yaml:"health" json:"health"`
+	Ready      Ready          `yaml:"ready" json:"ready"`
+}
+
+// Upstream defines the upstream services which are called by the service,
+// the upstreams are either a list of URIs or an execution plan
+type Upstream struct {
+	URIs            []UpstreamCall `yaml:"uris" json:"uris" env:"UPSTREAM_URIS" validate:"upstreams"`
+	Plan            []Stage        `yaml:"plan" json:"plan" env:"UPSTREAM_PLAN" validate:"plan"`
+	Workers         int            `yaml:"workers" json:"workers" env:"UPSTREAM_WORKERS" validate:"min=1"`
+	FailurePolicy   string         `yaml:"failure_policy" json:"failure_policy" env:"UPSTREAM_FAILURE_POLICY" validate:"oneof=any|required|quorum"`
+	Quorum          int            `yaml:"quorum" json:"quorum" env:"UPSTREAM_QUORUM" validate:"min=0"`
+	DegradedCode    int            `yaml:"degraded_code" json:"degraded_code" env:"UPSTREAM_DEGRADED_CODE" validate:"min=100,max=599"`
+	AllowInsecure   bool           `yaml:"allow_insecure" json:"allow_insecure" env:"UPSTREAM_ALLOW_INSECURE"`
+	RequestBody     string         `yaml:"request_body" json:"request_body" env:"UPSTREAM_REQUEST_BODY"`
+	RequestSize     int            `yaml:"request_size" json:"request_size" env:"UPSTREAM_REQUEST_SIZE" validate:"min=0"`
+	RequestVariance int            `yaml:"request_variance" json:"request_variance" env:"UPSTREAM_REQUEST_VARIANCE" validate:"min=0,max=100"`
+	// TLS settings used to call grpcs:// upstreams, an upstream can override
+	// them with its own tls block
+	TLSCALocation   string `yaml:"tls_ca_location" json:"tls_ca_location" env:"UPSTREAM_TLS_CA_LOCATION"`
+	TLSCertLocation string `yaml:"tls_cert_location" json:"tls_cert_location" env:"UPSTREAM_TLS_CERT_LOCATION"`
+	TLSKeyLocation  string `yaml:"tls_key_location" json:"tls_key_location" env:"UPSTREAM_TLS_KEY_LOCATION"`
+}
+
+// HTTPClient defines the client used to call upstream HTTP services
+type HTTPClient struct {
+	KeepAlives     bool     `yaml:"keep_alives" json:"keep_alives" env:"HTTP_CLIENT_KEEP_ALIVES"`
+	AppendRequest  bool     `yaml:"append_request" json:"append_request" env:"HTTP_CLIENT_APPEND_REQUEST"`
+	RequestTimeout Duration `yaml:"request_timeout" json:"request_timeout" env:"HTTP_CLIENT_REQUEST_TIMEOUT" validate:"min=0"`
+	Protocol       string   `yaml:"protocol" json:"protocol" env:"HTTP_CLIENT_PROTOCOL" validate:"oneof=http1|http2"`
+}
+
+// HTTPServer defines the HTTP server settings
+type HTTPServer struct {
+	KeepAlives        bool     `yaml:"keep_alives" json:"keep_alives" env:"HTTP_SERVER_KEEP_ALIVES" restart:"true"`
+	ReadTimeout       Duration `yaml:"read_timeout" json:"read_timeout" env:"HTTP_SERVER_READ_TIMEOUT" validate:"min=0" restart:"true"`
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" json:"read_header_timeout" env:"HTTP_SERVER_READHEADER_TIMEOUT" validate:"min=0" restart:"true"`
+	WriteTimeout      Duration `yaml:"write_timeout" json:"write_timeout" env:"HTTP_SERVER_WRITE_TIMEOUT" validate:"min=0" restart:"true"`
+	IdleTimeout       Duration `yaml:"idle_timeout" json:"idle_timeout" env:"HTTP_SERVER_IDLE_TIMEOUT" validate:"min=0" restart:"true"`
+	// HTTP3ListenAddr is the UDP address HTTP/3 is served on, HTTP/3 is
+	// only served when it is set and TLS is configured
+	HTTP3ListenAddr string `yaml:"http3_listen_addr" json:"http3_listen_addr" env:"HTTP_SERVER_HTTP3_LISTEN_ADDR" restart:"true"`
+}
+
+// CORS defines the CORS settings for the HTTP server
+type CORS struct {
+	AllowedOrigins   []string `yaml:"allowed_origins" json:"allowed_origins" env:"ALLOWED_ORIGINS" restart:"true"`
+	AllowedHeaders   []string `yaml:"allowed_headers" json:"allowed_headers" env:"ALLOWED_HEADERS" restart:"true"`
+	AllowCredentials bool     `yaml:"allow_credentials" json:"allow_credentials" env:"ALLOW_CREDENTIALS" restart:"true"`
+}
+
+// Timing defines the randomised duration of a request
+type Timing struct {
+	Percentile50 Duration `yaml:"p50" json:"p50" env:"TIMING_50_PERCENTILE" validate:"min=0"`
+	Percentile90 Duration `yaml:"p90" json:"p90" env:"TIMING_90_PERCENTILE" validate:"min=0"`
+	Percentile99 Duration `yaml:"p99" json:"p99" env:"TIMING_99_PERCENTILE" validate:"min=0"`
+	Variance     int      `yaml:"variance" json:"variance" env:"TIMING_VARIANCE" validate:"min=0,max=100"`
+}
+
+// Errors defines the errors which are injected into requests
+type Errors struct {
+	Rate  float64  `yaml:"rate" json:"rate" env:"ERROR_RATE" validate:"min=0,max=1"`
+	Type  string   `yaml:"type" json:"type" env:"ERROR_TYPE" validate:"oneof=http_error|delay"`
+	Code  int      `yaml:"code" json:"code" env:"ERROR_CODE" validate:"min=0"`
+	Delay Duration `yaml:"delay" json:"delay" env:"ERROR_DELAY" validate:"min=0"`
+}
+
+// RateLimit defines the rate limiting applied to the service
+type RateLimit struct {
+	RPS  float64 `yaml:"rps" json:"rps" env:"RATE_LIMIT" validate:"min=0"`
+	Code int     `yaml:"code" json:"code" env:"RATE_LIMIT_CODE" validate:"min=0"`
+}
+
+// GRPCStream defines the responses sent by the gRPC streaming methods
+type GRPCStream struct {
+	Messages    int      `yaml:"messages" json:"messages" env:"GRPC_STREAM_MESSAGES" validate:"min=1"`
+	Interval    Duration `yaml:"interval" json:"interval" env:"GRPC_STREAM_INTERVAL" validate:"min=0"`
+	MessageSize int      `yaml:"message_size" json:"message_size" env:"GRPC_STREAM_MESSAGE_SIZE" validate:"min=0"`
+	// ErrorAfter ends streams with ErrorCode once the number of messages have
+	// been sent or received, 0 disables the error
+	ErrorAfter int    `yaml:"error_after" json:"error_after" env:"GRPC_STREAM_ERROR_AFTER" validate:"min=0"`
+	ErrorCode  string `yaml:"error_code" json:"error_code" env:"GRPC_STREAM_ERROR_CODE" validate:"grpc_error_code"`
+}
+
+// WebSocket defines the behaviour of the WebSocket handler at /ws
+type WebSocket struct {
+	// Mode is echo to return messages to the client, push to send messages
+	// on an interval or upstream to send messages to the WebSocket upstreams
+	// and return their responses
+	Mode         string   `yaml:"mode" json:"mode" env:"WEBSOCKET_MODE" validate:"oneof=echo|push|upstream"`
+	PushInterval Duration `yaml:"push_interval" json:"push_interval" env:"WEBSOCKET_PUSH_INTERVAL" validate:"min=0"`
+	MessageSize  int      `yaml:"message_size" json:"message_size" env:"WEBSOCKET_MESSAGE_SIZE" validate:"min=0"`
+	Lifetime     Duration `yaml:"lifetime" json:"lifetime" env:"WEBSOCKET_LIFETIME" validate:"min=0"`
+	// DisconnectAfter closes the connection with DisconnectCode once the
+	// number of messages have been sent, a code of 0 drops the connection
+	// without sending a close message
+	DisconnectAfter int      `yaml:"disconnect_after" json:"disconnect_after" env:"WEBSOCKET_DISCONNECT_AFTER" validate:"min=0"`
+	DisconnectCode  int      `yaml:"disconnect_code" json:"disconnect_code" env:"WEBSOCKET_DISCONNECT_CODE" validate:"min=0,max=4999"`
+	UpstreamURIs    []string `yaml:"upstream_uris" json:"upstream_uris" env:"WEBSOCKET_UPSTREAM_URIS" validate:"websocket_uris"`
+}
+
+// SSE defines when requests are answered with a stream of server-sent events
+// and the events which are sent
+type SSE struct {
+	Mode           string   `yaml:"mode" json:"mode" env:"SSE_MODE" validate:"oneof=disabled|accept|always"`
+	Events         int      `yaml:"events" json:"events" env:"SSE_EVENTS" validate:"min=0"`
+	Interval       Duration `yaml:"interval" json:"interval" env:"SSE_INTERVAL" validate:"min=0"`
+	PayloadSize    int      `yaml:"payload_size" json:"payload_size" env:"SSE_PAYLOAD_SIZE" validate:"min=0"`
+	UpstreamEvents bool     `yaml:"upstream_events" json:"upstream_events" env:"SSE_UPSTREAM_EVENTS"`
+}
+
+// Raw defines the TCP and UDP listeners which handle connections and datagrams
+// which do not use a protocol, the listeners are only started when their
+// address is set
+type Raw struct {
+	TCPListenAddr string `yaml:"tcp_listen_addr" json:"tcp_listen_addr" env:"RAW_TCP_LISTEN_ADDR" restart:"true"`
+	UDPListenAddr string `yaml:"udp_listen_addr" json:"udp_listen_addr" env:"RAW_UDP_LISTEN_ADDR" restart:"true"`
+	// Mode is echo to return the data to the client, payload to respond with
+	// Payload or upstream to forward the data to the upstreams and return
+	// their responses
+	Mode    string `yaml:"mode" json:"mode" env:"RAW_MODE" validate:"oneof=echo|payload|upstream"`
+	Payload string `yaml:"payload" json:"payload" env:"RAW_PAYLOAD"`
+	// UpstreamURIs are tcp:// and udp:// URIs, connections to the TCP listener
+	// are forwarded to the tcp:// upstreams and datagrams received by the UDP
+	// listener to the udp:// upstreams
+	UpstreamURIs []string `yaml:"upstream_uris" json:"upstream_uris" env:"RAW_UPSTREAM_URIS" validate:"raw_uris"`
+}
+
+// LoadGeneration defines the CPU and memory load generated for each request
+type LoadGeneration struct {
+	CPUAllocated     int     `yaml:"cpu_allocated" json:"cpu_allocated" env:"LOAD_CPU_ALLOCATED" validate:"min=0"`
+	CPUClockSpeed    int     `yaml:"cpu_clock_speed" json:"cpu_clock_speed" env:"LOAD_CPU_CLOCK_SPEED" validate:"min=1"`
+	CPUCores         int     `yaml:"cpu_cores" json:"cpu_cores" env:"LOAD_CPU_CORES" validate:"min=-1"`
+	CPUPercentage    float64 `yaml:"cpu_percentage" json:"cpu_percentage" env:"LOAD_CPU_PERCENTAGE" validate:"min=0,max=100"`
+	MemoryPerRequest int     `yaml:"memory_per_request" json:"memory_per_request" env:"LOAD_MEMORY_PER_REQUEST" validate:"min=0"`
+	MemoryVariance   int     `yaml:"memory_variance" json:"memory_variance" env:"LOAD_MEMORY_VARIANCE" validate:"min=0,max=100"`
+}
+
+// Tracing defines the collectors where traces are sent
+type Tracing struct {
+	Zipkin       string `yaml:"zipkin" json:"zipkin" env:"TRACING_ZIPKIN" restart:"true"`
+	DatadogHost  string `yaml:"datadog_host" json:"datadog_host" env:"TRACING_DATADOG_HOST" restart:"true"`
+	DatadogPort  string `yaml:"datadog_port" json:"datadog_port" env:"TRACING_DATADOG_PORT" restart:"true"`
+	OTLPEndpoint string `yaml:"otlp_endpoint" json:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT" restart:"true"`
+	OTLPProtocol string `yaml:"otlp_protocol" json:"otlp_protocol" env:"TRACING_OTLP_PROTOCOL" validate:"oneof=grpc|http" restart:"true"`
+	// Comma separated propagation formats, when empty the format of the
+	// tracer is used
+	PropagationExtract string `yaml:"propagation_extract" json:"propagation_extract" env:"TRACING_PROPAGATION_EXTRACT" restart:"true"`
+	PropagationInject  string `yaml:"propagation_inject" json:"propagation_inject" env:"TRACING_PROPAGATION_INJECT" restart:"true"`
+	// Collector stores spans in memory so they can be viewed in the UI
+	Collector          bool `yaml:"collector" json:"collector" env:"TRACING_COLLECTOR" restart:"true"`
+	CollectorMaxTraces int  `yaml:"collector_max_traces" json:"collector_max_traces" env:"TRACING_COLLECTOR_MAX_TRACES" validate:"min=1" restart:"true"`
+}
+
+// Metrics defines the collectors where metrics are sent
+type Metrics struct {
+	DatadogHost        string `yaml:"datadog_host" json:"datadog_host" env:"METRICS_DATADOG_HOST" restart:"true"`
+	DatadogPort        string `yaml:"datadog_port" json:"datadog_port" env:"METRICS_DATADOG_PORT" restart:"true"`
+	DatadogEnvironment string `yaml:"datadog_environment" json:"datadog_environment" env:"METRICS_DATADOG_ENVIRONMENT" restart:"true"`
+	Prometheus         bool   `yaml:"prometheus" json:"prometheus" env:"METRICS_PROMETHEUS" restart:"true"`
+	OTLPEndpoint       string `yaml:"otlp_endpoint" json:"otlp_endpoint" env:"METRICS_OTLP_ENDPOINT" restart:"true"`
+	OTLPProtocol       string `yaml:"otlp_protocol" json:"otlp_protocol" env:"METRICS_OTLP_PROTOCOL" validate:"oneof=grpc|http" restart:"true"`
+}
+
+// Logging defines the log output
+type Logging struct {
+	Format string `yaml:"format" json:"format" env:"LOG_FORMAT" validate:"oneof=text|json" restart:"true"`
+	Level  string `yaml:"level" json:"level" env:"LOG_LEVEL" validate:"oneof=trace|debug|info|warn|error" restart:"true"`
+	Output string `yaml:"output" json:"output" env:"LOG_OUTPUT" restart:"true"`
+}
+
+// TLS defines the certificates used to secure the server, the HTTP and gRPC
+// servers share the listener so both use the same settings
+type TLS struct {
+	CertLocation string `yaml:"cert_location" json:"cert_location" env:"TLS_CERT_LOCATION" restart:"true"`
+	KeyLocation  string `yaml:"key_location" json:"key_location" env:"TLS_KEY_LOCATION" restart:"true"`
+	CALocation   string `yaml:"ca_location" json:"ca_location" env:"TLS_CA_LOCATION" restart:"true"`
+	ClientAuth   string `yaml:"client_auth" json:"client_auth" env:"TLS_CLIENT_AUTH" validate:"oneof=none|request|require|verify" restart:"true"`
+	// SelfSigned generates a certificate when the service starts, the names
+	// and addresses in SelfSignedHosts are added to the certificate
+	SelfSigned      bool   `yaml:"self_signed" json:"self_signed" env:"TLS_SELF_SIGNED" restart:"true"`
+	SelfSignedHosts string `yaml:"self_signed_hosts" json:"self_signed_hosts" env:"TLS_SELF_SIGNED_HOSTS" restart:"true"`
+}
+
+// Health defines the behaviour of the health check
+type Health struct {
+	ResponseCode int `yaml:"response_code" json:"response_code" env:"HEALTH_CHECK_RESPONSE_CODE" validate:"min=100,max=599"`
+	// GRPCServices sets the status returned by the gRPC health service for
+	// each service, e.g. FakeService=NOT_SERVING
+	GRPCServices []string `yaml:"grpc_services" json:"grpc_services" env:"HEALTH_CHECK_GRPC_SERVICES" validate:"grpc_health"`
+}
+
+// Ready defines the behaviour of the readiness check
+type Ready struct {
+	SuccessCode           int      `yaml:"success_code" json:"success_code" env:"READY_CHECK_RESPONSE_SUCCESS_CODE" validate:"min=100,max=599" restart:"true"`
+	FailureCode           int      `yaml:"failure_code" json:"failure_code" env:"READY_CHECK_RESPONSE_FAILURE_CODE" validate:"min=100,max=599" restart:"true"`
+	RootPathWaitTillReady bool     `yaml:"root_path_wait_till_ready" json:"root_path_wait_till_ready" env:"READY_CHECK_ROOT_PATH_WAIT_TILL_READY" restart:"true"`
+	Delay                 Duration `yaml:"delay" json:"delay" env:"READY_CHECK_RESPONSE_DELAY" validate:"min=0" restart:"true"`
+}
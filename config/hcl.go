@@ -0,0 +1,165 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+)
+
+// isHCL returns true when the file should be parsed as HCL rather than YAML
+func isHCL(file string) bool {
+	return strings.HasSuffix(strings.ToLower(file), ".hcl")
+}
+
+// parseHCL parses the HCL file into the same structure as a YAML document so
+// that it can be validated in the same way. Blocks and objects become
+// mappings and tuples become sequences, only literal values can be used.
+func parseHCL(file string, data []byte) (*yaml.Node, error) {
+	f, diags := hclsyntax.ParseConfig(data, file, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, hclError(diags)
+	}
+
+	return hclBody(f.Body.(*hclsyntax.Body))
+}
+
+// hclBody converts the attributes and blocks of the body into a mapping
+func hclBody(b *hclsyntax.Body) (*yaml.Node, error) {
+	n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: b.SrcRange.Start.Line}
+
+	type item struct {
+		name  string
+		line  int
+		value *yaml.Node
+	}
+
+	items := []item{}
+
+	for name, a := range b.Attributes {
+		v, err := hclExpression(a.Expr)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item{name, a.NameRange.Start.Line, v})
+	}
+
+	for _, bl := range b.Blocks {
+		if len(bl.Labels) > 0 {
+			return nil, fmt.Errorf("%s: block %q can not have labels", bl.TypeRange, bl.Type)
+		}
+
+		v, err := hclBody(bl.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		v.Line = bl.TypeRange.Start.Line
+		items = append(items, item{bl.Type, bl.TypeRange.Start.Line, v})
+	}
+
+	// attributes are not ordered so the values are sorted by their position
+	// in the file
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].line < items[j].line
+	})
+
+	for _, i := range items {
+		n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: i.name, Line: i.line}, i.value)
+	}
+
+	return n, nil
+}
+
+// hclExpression converts the expression into a node, tuples and objects are
+// converted element by element so that the nodes have the correct line
+func hclExpression(e hclsyntax.Expression) (*yaml.Node, error) {
+	line := e.Range().Start.Line
+
+	switch ex := e.(type) {
+	case *hclsyntax.TupleConsExpr:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line}
+		for _, ie := range ex.Exprs {
+			v, err := hclExpression(ie)
+			if err != nil {
+				return nil, err
+			}
+
+			n.Content = append(n.Content, v)
+		}
+
+		return n, nil
+	case *hclsyntax.ObjectConsExpr:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line}
+		for _, i := range ex.Items {
+			k, diags := i.KeyExpr.Value(nil)
+			if diags.HasErrors() {
+				return nil, hclError(diags)
+			}
+
+			if k.Type() != cty.String {
+				return nil, fmt.Errorf("%s: object keys must be strings", i.KeyExpr.Range())
+			}
+
+			v, err := hclExpression(i.ValueExpr)
+			if err != nil {
+				return nil, err
+			}
+
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k.AsString(), Line: i.KeyExpr.Range().Start.Line}, v)
+		}
+
+		return n, nil
+	}
+
+	v, diags := e.Value(nil)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("%s: only literal values can be used", e.Range())
+	}
+
+	return hclValue(v, e.Range())
+}
+
+// hclValue converts a literal value into a scalar node
+func hclValue(v cty.Value, r hcl.Range) (*yaml.Node, error) {
+	n := &yaml.Node{Kind: yaml.ScalarNode, Line: r.Start.Line}
+
+	switch {
+	case v.IsNull():
+		n.Tag = "!!null"
+	case v.Type() == cty.String:
+		n.Tag = "!!str"
+		n.Value = v.AsString()
+	case v.Type() == cty.Bool:
+		n.Tag = "!!bool"
+		n.Value = fmt.Sprint(v.True())
+	case v.Type() == cty.Number:
+		n.Tag = "!!int"
+		if !v.AsBigFloat().IsInt() {
+			n.Tag = "!!float"
+		}
+
+		n.Value = v.AsBigFloat().Text('f', -1)
+	default:
+		return nil, fmt.Errorf("%s: unsupported value of type %s", r, v.Type().FriendlyName())
+	}
+
+	return n, nil
+}
+
+// hclError returns the first error from the diagnostics, it contains the
+// position of the problem
+func hclError(diags hcl.Diagnostics) error {
+	for _, d := range diags {
+		if d.Severity == hcl.DiagError {
+			return d
+		}
+	}
+
+	return diags
+}
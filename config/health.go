@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// ParseGRPCHealth returns the status for each service from a list of
+// service=STATUS values, e.g. FakeService=NOT_SERVING. The status of the
+// server as a whole is set using an empty service name.
+func ParseGRPCHealth(services []string) (map[string]grpc_health_v1.HealthCheckResponse_ServingStatus, error) {
+	statuses := map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{}
+
+	for _, s := range services {
+		name, st, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("must be a list of service=STATUS, got %q", s)
+		}
+
+		st = strings.ToUpper(strings.TrimSpace(st))
+		if st != "SERVING" && st != "NOT_SERVING" {
+			return nil, fmt.Errorf("status for %q must be one of [SERVING, NOT_SERVING], got %q", name, st)
+		}
+
+		statuses[strings.TrimSpace(name)] = grpc_health_v1.HealthCheckResponse_ServingStatus(grpc_health_v1.HealthCheckResponse_ServingStatus_value[st])
+	}
+
+	return statuses, nil
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HedgePolicy defines when a duplicate call is sent to a slow upstream, the
+// response from whichever call completes first is used and the other call
+// is cancelled
+type HedgePolicy struct {
+	// Delay is the time to wait for a response before the duplicate call is
+	// sent
+	Delay Duration `yaml:"delay" json:"delay"`
+	// Percentile of the observed call duration to use as the delay, e.g. 95,
+	// Delay is used until enough calls have been observed
+	Percentile float64 `yaml:"percentile,omitempty" json:"percentile,omitempty"`
+}
+
+// hedgePolicy has the same fields as HedgePolicy without the custom encoding
+type hedgePolicy HedgePolicy
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (h *HedgePolicy) UnmarshalYAML(n *yaml.Node) error {
+	if err := checkFields(n, reflect.TypeOf(hedgePolicy{}), "hedge"); err != nil {
+		return err
+	}
+
+	hp := hedgePolicy{}
+	if err := n.Decode(&hp); err != nil {
+		return err
+	}
+
+	*h = HedgePolicy(hp)
+
+	return nil
+}
+
+// validate the hedge policy
+func (h HedgePolicy) validate() error {
+	if h.Delay <= 0 {
+		return fmt.Errorf("hedge delay must be greater than 0")
+	}
+
+	if h.Percentile < 0 || h.Percentile >= 100 {
+		return fmt.Errorf("hedge percentile must be between 0 and 100, got %v", h.Percentile)
+	}
+
+	return nil
+}
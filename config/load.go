@@ -0,0 +1,402 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v3"
+)
+
+// Setting is a value which has been defined in the config file
+type Setting struct {
+	// Path of the value in the file, e.g. errors.rate
+	Path string
+	// Env is the environment variable the value maps to
+	Env string
+	// Value formatted so that it can be parsed from the environment
+	Value string
+	// Line in the file where the value is defined
+	Line int
+}
+
+// ValidationError is returned when a value in the config is invalid
+type ValidationError struct {
+	// File and Line are set when the value was read from a file
+	File    string `json:"-"`
+	Line    int    `json:"-"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (v ValidationError) Error() string {
+	msg := strings.TrimSpace(fmt.Sprintf("%s %s", v.Field, v.Message))
+
+	if v.File == "" {
+		return msg
+	}
+
+	return fmt.Sprintf("%s:%d: %s", v.File, v.Line, msg)
+}
+
+// ValidationErrors is a list of all the problems found in the config file
+type ValidationErrors []ValidationError
+
+func (v ValidationErrors) Error() string {
+	msgs := []string{}
+	for _, e := range v {
+		msgs = append(msgs, e.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// File is a config file which has been loaded and validated
+type File struct {
+	Config   *Config
+	Settings []Setting
+}
+
+// Load reads and validates the config file at the given location, if the
+// file contains any invalid values a ValidationErrors is returned containing
+// the line of each problem
+func Load(file string) (*File, error) {
+	d, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %s", err)
+	}
+
+	return Parse(file, d)
+}
+
+// Parse the config from the given data, file is used when reporting errors.
+// Files with the extension .hcl are parsed as HCL, all other files as YAML.
+func Parse(file string, data []byte) (*File, error) {
+	doc, err := parseDocument(file, data)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{Config: &Config{}}
+	p := &parser{file: file}
+
+	if doc != nil {
+		p.walk(doc, reflect.ValueOf(f.Config).Elem(), "")
+	}
+
+	p.checkPairs("tls.cert_location", "tls.key_location")
+	p.checkExclusive("upstream.uris", "upstream.plan")
+
+	if len(p.errors) > 0 {
+		return nil, p.errors
+	}
+
+	f.Settings = p.settings
+
+	return f, nil
+}
+
+// parseDocument returns the root of the document in the file, nil is
+// returned when the file is empty
+func parseDocument(file string, data []byte) (*yaml.Node, error) {
+	if isHCL(file) {
+		return parseHCL(file, data)
+	}
+
+	root := &yaml.Node{}
+
+	err := yaml.Unmarshal(data, root)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	// an empty file does not contain a document
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	return root.Content[0], nil
+}
+
+// Environment returns the environment variables and their values for the
+// settings defined in the file
+func (f *File) Environment() map[string]string {
+	env := map[string]string{}
+	for _, s := range f.Settings {
+		env[s.Env] = s.Value
+	}
+
+	return env
+}
+
+type parser struct {
+	file     string
+	settings []Setting
+	errors   ValidationErrors
+}
+
+func (p *parser) addError(line int, field, format string, a ...interface{}) {
+	p.errors = append(p.errors, ValidationError{File: p.file, Line: line, Field: field, Message: fmt.Sprintf(format, a...)})
+}
+
+// walk the yaml mapping setting the values of the struct fields which have
+// a matching yaml tag
+func (p *parser) walk(n *yaml.Node, v reflect.Value, prefix string) {
+	if n.Kind != yaml.MappingNode {
+		p.addError(n.Line, strings.TrimSuffix(prefix, "."), "expected a block of values")
+		return
+	}
+
+	for i := 0; i < len(n.Content)-1; i += 2 {
+		key := n.Content[i]
+		value := n.Content[i+1]
+		path := prefix + key.Value
+
+		f, ok := fieldForTag(v.Type(), key.Value)
+		if !ok {
+			p.addError(key.Line, "", "unknown field %q", path)
+			continue
+		}
+
+		fv := v.FieldByIndex(f.Index)
+
+		if isSection(f.Type) {
+			p.walk(value, fv, path+".")
+			continue
+		}
+
+		nv, err := decodeValue(value, f.Type)
+		if err != nil {
+			p.addError(value.Line, path, "%s", err)
+			continue
+		}
+
+		if err := validateValue(nv, f.Tag.Get("validate")); err != nil {
+			p.addError(value.Line, path, "%s", err)
+			continue
+		}
+
+		fv.Set(nv)
+
+		if env := f.Tag.Get("env"); env != "" {
+			p.settings = append(p.settings, Setting{Path: path, Env: env, Value: formatValue(nv), Line: value.Line})
+		}
+	}
+}
+
+// checkPairs ensures that when one of the values is set the other is also set
+func (p *parser) checkPairs(a, b string) {
+	var sa, sb *Setting
+	for i, s := range p.settings {
+		if s.Path == a {
+			sa = &p.settings[i]
+		}
+
+		if s.Path == b {
+			sb = &p.settings[i]
+		}
+	}
+
+	if sa != nil && sb == nil {
+		p.addError(sa.Line, a, "requires %s to be set", b)
+	}
+
+	if sb != nil && sa == nil {
+		p.addError(sb.Line, b, "requires %s to be set", a)
+	}
+}
+
+// checkExclusive ensures that only one of the values is set
+func (p *parser) checkExclusive(a, b string) {
+	var sa, sb *Setting
+	for i, s := range p.settings {
+		if s.Path == a {
+			sa = &p.settings[i]
+		}
+
+		if s.Path == b {
+			sb = &p.settings[i]
+		}
+	}
+
+	if sa != nil && sb != nil {
+		p.addError(sb.Line, b, "can not be used with %s", a)
+	}
+}
+
+// isSection returns true when the type is a block of values rather than a
+// single value
+func isSection(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct
+}
+
+func fieldForTag(t reflect.Type, tag string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if strings.Split(f.Tag.Get("yaml"), ",")[0] == tag {
+			return f, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// decodeValue decodes the node into a new value of the given type
+func decodeValue(n *yaml.Node, t reflect.Type) (reflect.Value, error) {
+	v := reflect.New(t)
+
+	// allow lists to be defined as a comma separated string like the
+	// environment variables
+	if t.Kind() == reflect.Slice && n.Kind == yaml.ScalarNode {
+		parts := reflect.MakeSlice(t, 0, 0)
+		for _, s := range strings.Split(n.Value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				pv, err := decodeValue(&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s, Line: n.Line}, t.Elem())
+				if err != nil {
+					return v, err
+				}
+
+				parts = reflect.Append(parts, pv)
+			}
+		}
+
+		v.Elem().Set(parts)
+		return v.Elem(), nil
+	}
+
+	if err := n.Decode(v.Interface()); err != nil {
+		if n.Kind != yaml.ScalarNode {
+			return v, fmt.Errorf("expected %s: %s", typeName(t), strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+
+		return v, fmt.Errorf("expected %s, got %q", typeName(t), n.Value)
+	}
+
+	return v.Elem(), nil
+}
+
+// validateValue checks the value against the rules defined in the validate tag
+func validateValue(v reflect.Value, rules string) error {
+	if rules == "" {
+		return nil
+	}
+
+	for _, r := range strings.Split(rules, ",") {
+		parts := strings.SplitN(r, "=", 2)
+
+		switch parts[0] {
+		case "min", "max":
+			limit, _ := strconv.ParseFloat(parts[1], 64)
+			n := numericValue(v)
+
+			if parts[0] == "min" && n < limit {
+				return fmt.Errorf("must be greater than or equal to %s, got %s", parts[1], formatValue(v))
+			}
+
+			if parts[0] == "max" && n > limit {
+				return fmt.Errorf("must be less than or equal to %s, got %s", parts[1], formatValue(v))
+			}
+		case "oneof":
+			options := strings.Split(parts[1], "|")
+			found := false
+			for _, o := range options {
+				if o == v.String() {
+					found = true
+				}
+			}
+
+			if !found {
+				return fmt.Errorf("must be one of [%s], got %q", strings.Join(options, ", "), v.String())
+			}
+		case "plan":
+			for _, s := range v.Interface().([]Stage) {
+				if err := s.validate(); err != nil {
+					return err
+				}
+			}
+		case "upstreams":
+			for _, u := range v.Interface().([]UpstreamCall) {
+				if err := u.validate(); err != nil {
+					return err
+				}
+			}
+		case "grpc_health":
+			if _, err := ParseGRPCHealth(v.Interface().([]string)); err != nil {
+				return err
+			}
+		case "grpc_error_code":
+			c, err := ParseGRPCCode(v.String())
+			if err != nil {
+				return err
+			}
+
+			if c == codes.OK {
+				return fmt.Errorf("must be an error code, got %q", v.String())
+			}
+		case "websocket_uris":
+			for _, u := range v.Interface().([]string) {
+				if !strings.HasPrefix(u, "ws://") && !strings.HasPrefix(u, "wss://") {
+					return fmt.Errorf("must be a list of ws:// or wss:// URIs, got %q", u)
+				}
+			}
+		case "raw_uris":
+			for _, u := range v.Interface().([]string) {
+				if !strings.HasPrefix(u, "tcp://") && !strings.HasPrefix(u, "udp://") {
+					return fmt.Errorf("must be a list of tcp:// or udp:// URIs, got %q", u)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func numericValue(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Float64:
+		return v.Float()
+	}
+
+	return 0
+}
+
+// formatValue returns the value in the same format as the environment variable
+func formatValue(v reflect.Value) string {
+	switch i := v.Interface().(type) {
+	case Duration:
+		return i.String()
+	case []string:
+		return strings.Join(i, ",")
+	case []UpstreamCall:
+		return FormatUpstreamCalls(i)
+	case []Stage:
+		return FormatPlan(i)
+	default:
+		return fmt.Sprint(i)
+	}
+}
+
+func typeName(t reflect.Type) string {
+	switch t {
+	case reflect.TypeOf(Duration(0)):
+		return "a duration e.g. 100ms"
+	}
+
+	switch t.Kind() {
+	case reflect.Int:
+		return "an integer"
+	case reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice:
+		return "a list"
+	default:
+		return "a string"
+	}
+}
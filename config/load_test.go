@@ -0,0 +1,220 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var validConfig = `
+name: web
+message: Hello from web
+upstream:
+  uris:
+    - http://api:9090
+    - grpc://payments:9090
+  workers: 2
+timing:
+  p50: 20ms
+  variance: 10
+errors:
+  rate: 0.1
+  type: delay
+cors:
+  allowed_origins: http://a.com, http://b.com
+`
+
+func TestParsesValidConfig(t *testing.T) {
+	f, err := Parse("config.yaml", []byte(validConfig))
+	require.NoError(t, err)
+
+	assert.Equal(t, "web", f.Config.Name)
+	assert.Equal(t, []string{"http://api:9090", "grpc://payments:9090"}, URIs(f.Config.Upstream.URIs))
+	assert.Equal(t, Duration(20*time.Millisecond), f.Config.Timing.Percentile50)
+	assert.Equal(t, 0.1, f.Config.Errors.Rate)
+	assert.Equal(t, []string{"http://a.com", "http://b.com"}, f.Config.CORS.AllowedOrigins)
+}
+
+func TestReturnsEnvironmentForDefinedSettings(t *testing.T) {
+	f, err := Parse("config.yaml", []byte(validConfig))
+	require.NoError(t, err)
+
+	env := f.Environment()
+
+	assert.Len(t, env, 9)
+	assert.Equal(t, "web", env["NAME"])
+	assert.Equal(t, "http://api:9090,grpc://payments:9090", env["UPSTREAM_URIS"])
+	assert.Equal(t, "2", env["UPSTREAM_WORKERS"])
+	assert.Equal(t, "20ms", env["TIMING_50_PERCENTILE"])
+	assert.Equal(t, "0.1", env["ERROR_RATE"])
+	assert.Equal(t, "http://a.com,http://b.com", env["ALLOWED_ORIGINS"])
+}
+
+func TestParsesEmptyConfig(t *testing.T) {
+	f, err := Parse("config.yaml", []byte(""))
+	require.NoError(t, err)
+
+	assert.Len(t, f.Environment(), 0)
+}
+
+func TestReturnsErrorWithLineForUnknownField(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("name: web\ntiming:\n  p95: 10ms\n"))
+	require.Error(t, err)
+
+	assert.Equal(t, `config.yaml:3: unknown field "timing.p95"`, err.Error())
+}
+
+func TestReturnsErrorWithLineForInvalidType(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("timing:\n  p50: fast\n"))
+	require.Error(t, err)
+
+	assert.Equal(t, `config.yaml:2: timing.p50 expected a duration e.g. 100ms, got "fast"`, err.Error())
+}
+
+func TestReturnsAllValidationErrors(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("errors:\n  rate: 2\n  type: timeout\nupstream:\n  uris: [ftp://a]\n"))
+	require.Error(t, err)
+
+	verrs, ok := err.(ValidationErrors)
+	require.True(t, ok)
+	require.Len(t, verrs, 3)
+
+	assert.Equal(t, 2, verrs[0].Line)
+	assert.Equal(t, "errors.rate", verrs[0].Field)
+	assert.Equal(t, "must be less than or equal to 1, got 2", verrs[0].Message)
+	assert.Equal(t, 3, verrs[1].Line)
+	assert.Equal(t, "errors.type", verrs[1].Field)
+	assert.Contains(t, verrs[1].Message, "must be one of [http_error, delay]")
+	assert.Equal(t, 5, verrs[2].Line)
+	assert.Equal(t, "upstream.uris", verrs[2].Field)
+}
+
+func TestReturnsErrorWhenTLSKeyMissing(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("tls:\n  cert_location: /certs/cert.pem\n"))
+	require.Error(t, err)
+
+	assert.Equal(t, "config.yaml:2: tls.cert_location requires tls.key_location to be set", err.Error())
+}
+
+func TestLoadsConfigFromFile(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(fn, []byte(validConfig), 0644)
+
+	f, err := Load(fn)
+	require.NoError(t, err)
+
+	assert.Equal(t, "web", f.Config.Name)
+}
+
+func TestParsesGRPCHealthServices(t *testing.T) {
+	f, err := Parse("config.yaml", []byte("health:\n  grpc_services: FakeService=NOT_SERVING, =serving\n"))
+	require.NoError(t, err)
+
+	s, err := ParseGRPCHealth(f.Config.Health.GRPCServices)
+	require.NoError(t, err)
+
+	assert.Equal(t, "NOT_SERVING", s["FakeService"].String())
+	assert.Equal(t, "SERVING", s[""].String())
+}
+
+func TestReturnsErrorForInvalidGRPCHealthStatus(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("health:\n  grpc_services: FakeService=DOWN\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `status for "FakeService" must be one of [SERVING, NOT_SERVING], got "DOWN"`)
+}
+
+func TestParsesGRPCStreamErrorCode(t *testing.T) {
+	f, err := Parse("config.yaml", []byte("grpc_stream:\n  error_code: deadline_exceeded\n"))
+	require.NoError(t, err)
+
+	c, err := ParseGRPCCode(f.Config.GRPCStream.ErrorCode)
+	require.NoError(t, err)
+
+	assert.Equal(t, "DeadlineExceeded", c.String())
+}
+
+func TestReturnsErrorForInvalidGRPCStreamErrorCode(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("grpc_stream:\n  error_code: OK\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `must be an error code, got "OK"`)
+}
+
+func TestReturnsErrorForInvalidWebSocketUpstream(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("websocket:\n  upstream_uris: [http://api:9090/ws]\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `must be a list of ws:// or wss:// URIs, got "http://api:9090/ws"`)
+}
+
+func TestReturnsErrorForInvalidRawUpstream(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("raw:\n  upstream_uris: [http://api:9090]\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `must be a list of tcp:// or udp:// URIs, got "http://api:9090"`)
+}
+
+var validHCLConfig = `
+name    = "web"
+message = "Hello from web"
+
+upstream {
+  uris = [
+    "http://api:9090",
+    { uri = "grpc://payments:9090", timeout = "1s" },
+  ]
+  workers = 2
+}
+
+timing {
+  p50      = "20ms"
+  variance = 10
+}
+
+errors {
+  rate = 0.1
+  type = "delay"
+}
+`
+
+func TestParsesValidHCLConfig(t *testing.T) {
+	f, err := Parse("config.hcl", []byte(validHCLConfig))
+	require.NoError(t, err)
+
+	assert.Equal(t, "web", f.Config.Name)
+	assert.Equal(t, []string{"http://api:9090", "grpc://payments:9090"}, URIs(f.Config.Upstream.URIs))
+	assert.Equal(t, 2, f.Config.Upstream.Workers)
+	assert.Equal(t, Duration(20*time.Millisecond), f.Config.Timing.Percentile50)
+	assert.Equal(t, 0.1, f.Config.Errors.Rate)
+
+	env := f.Environment()
+	assert.Equal(t, "0.1", env["ERROR_RATE"])
+	assert.Equal(t, "10", env["TIMING_VARIANCE"])
+}
+
+func TestReturnsErrorWithLineForInvalidHCLValue(t *testing.T) {
+	_, err := Parse("config.hcl", []byte("name = \"web\"\n\ntiming {\n  p50 = \"fast\"\n}\n"))
+	require.Error(t, err)
+
+	assert.Equal(t, `config.hcl:4: timing.p50 expected a duration e.g. 100ms, got "fast"`, err.Error())
+}
+
+func TestReturnsErrorForHCLExpressions(t *testing.T) {
+	_, err := Parse("config.hcl", []byte("name = upper(\"web\")\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "config.hcl:1")
+	assert.Contains(t, err.Error(), "only literal values can be used")
+}
+
+func TestReturnsErrorForInvalidHCL(t *testing.T) {
+	_, err := Parse("config.hcl", []byte("timing {\n  p50 = \n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "config.hcl:2")
+}
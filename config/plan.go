@@ -0,0 +1,126 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Stage is a step in an execution plan, the upstreams in a stage are called
+// once all the upstreams in the previous stage have completed successfully
+type Stage struct {
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// Upstreams are called in parallel
+	Upstreams []UpstreamCall `yaml:"upstreams,omitempty" json:"upstreams,omitempty"`
+	// Groups are called in parallel with the upstreams, the upstreams in
+	// each group are called in order
+	Groups [][]UpstreamCall `yaml:"groups,omitempty" json:"groups,omitempty"`
+}
+
+// stage has the same fields as Stage without the custom encoding
+type stage Stage
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (s *Stage) UnmarshalYAML(n *yaml.Node) error {
+	if err := checkFields(n, reflect.TypeOf(stage{}), "stage"); err != nil {
+		return err
+	}
+
+	st := stage{}
+	if err := n.Decode(&st); err != nil {
+		return err
+	}
+
+	*s = Stage(st)
+
+	return nil
+}
+
+// CallGroups returns the groups of upstreams in the stage, every upstream in
+// Upstreams is returned as a group containing a single upstream
+func (s Stage) CallGroups() [][]UpstreamCall {
+	groups := [][]UpstreamCall{}
+	for _, u := range s.Upstreams {
+		groups = append(groups, []UpstreamCall{u})
+	}
+
+	return append(groups, s.Groups...)
+}
+
+// validate the stage
+func (s Stage) validate() error {
+	groups := s.CallGroups()
+	if len(groups) == 0 {
+		return fmt.Errorf("stage %q must define upstreams or groups", s.Name)
+	}
+
+	for _, g := range groups {
+		for _, u := range g {
+			if err := u.validate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// PlanCalls returns every upstream in the execution plan
+func PlanCalls(stages []Stage) []UpstreamCall {
+	calls := []UpstreamCall{}
+	for _, s := range stages {
+		for _, g := range s.CallGroups() {
+			calls = append(calls, g...)
+		}
+	}
+
+	return calls
+}
+
+// ParsePlan parses an execution plan from a JSON environment variable
+func ParsePlan(s string) ([]Stage, error) {
+	stages := []Stage{}
+	if strings.TrimSpace(s) == "" {
+		return stages, nil
+	}
+
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&stages); err != nil {
+		return nil, fmt.Errorf("unable to parse upstream plan: %s", err)
+	}
+
+	return stages, nil
+}
+
+// FormatPlan formats the execution plan as JSON so it can be parsed using
+// ParsePlan
+func FormatPlan(stages []Stage) string {
+	if len(stages) == 0 {
+		return ""
+	}
+
+	d, _ := json.Marshal(stages)
+
+	return string(d)
+}
+
+// checkFields returns an error when the mapping node contains a key which is
+// not a field of the type, decoding a node does not report unknown fields
+func checkFields(n *yaml.Node, t reflect.Type, name string) error {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i < len(n.Content)-1; i += 2 {
+		if _, ok := fieldForTag(t, n.Content[i].Value); !ok {
+			return fmt.Errorf("line %d: unknown %s field %q", n.Content[i].Line, name, n.Content[i].Value)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var planConfig = `
+upstream:
+  plan:
+    - name: auth
+      upstreams:
+        - http://auth:9090
+    - name: fetch
+      upstreams:
+        - http://inventory:9090
+      groups:
+        - - http://pricing:9090
+          - uri: http://tax:9090
+            method: POST
+`
+
+func TestParsesPlan(t *testing.T) {
+	f, err := Parse("config.yaml", []byte(planConfig))
+	require.NoError(t, err)
+
+	plan := f.Config.Upstream.Plan
+	require.Len(t, plan, 2)
+	assert.Equal(t, "auth", plan[0].Name)
+
+	groups := plan[1].CallGroups()
+	require.Len(t, groups, 2)
+	assert.Equal(t, []string{"http://inventory:9090"}, URIs(groups[0]))
+	assert.Equal(t, []string{"http://pricing:9090", "http://tax:9090"}, URIs(groups[1]))
+	assert.Equal(t, "POST", groups[1][1].Method)
+
+	assert.Len(t, PlanCalls(plan), 4)
+}
+
+func TestPlanIsFormattedAsJSON(t *testing.T) {
+	f, err := Parse("config.yaml", []byte(planConfig))
+	require.NoError(t, err)
+
+	plan, err := ParsePlan(f.Environment()["UPSTREAM_PLAN"])
+	require.NoError(t, err)
+
+	assert.Equal(t, f.Config.Upstream.Plan, plan)
+}
+
+func TestReturnsErrorForEmptyStage(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  plan:\n    - name: empty\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `upstream.plan stage "empty" must define upstreams or groups`)
+}
+
+func TestReturnsErrorForUnknownStageField(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  plan:\n    - parallel: [http://a:9090]\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `unknown stage field "parallel"`)
+}
+
+func TestReturnsErrorWhenPlanAndURIsAreSet(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris: http://a:9090\n  plan:\n    - upstreams: [http://b:9090]\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "config.yaml:4: upstream.plan can not be used with upstream.uris")
+}
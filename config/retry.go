@@ -0,0 +1,89 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v3"
+)
+
+// RetryPolicy defines how failed calls to an upstream are retried
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts including the first call
+	Attempts int `yaml:"attempts" json:"attempts"`
+	// Codes are the HTTP status codes which are retried, when no codes are
+	// set any error is retried. Connection errors and timeouts are always
+	// retried.
+	Codes []int `yaml:"codes,omitempty" json:"codes,omitempty"`
+	// GRPCCodes are the names of the gRPC status codes which are retried,
+	// e.g. UNAVAILABLE, when no codes are set any error is retried
+	GRPCCodes []string `yaml:"grpc_codes,omitempty" json:"grpc_codes,omitempty"`
+	// Backoff is the base interval between attempts, the interval doubles
+	// after each attempt and a random jitter is applied, default 25ms
+	Backoff Duration `yaml:"backoff,omitempty" json:"backoff,omitempty"`
+	// MaxBackoff is the maximum interval between attempts, default 10 times
+	// the base interval
+	MaxBackoff Duration `yaml:"max_backoff,omitempty" json:"max_backoff,omitempty"`
+	// PerTryTimeout is the timeout for each attempt
+	PerTryTimeout Duration `yaml:"per_try_timeout,omitempty" json:"per_try_timeout,omitempty"`
+}
+
+// retryPolicy has the same fields as RetryPolicy without the custom encoding
+type retryPolicy RetryPolicy
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (r *RetryPolicy) UnmarshalYAML(n *yaml.Node) error {
+	if err := checkFields(n, reflect.TypeOf(retryPolicy{}), "retry"); err != nil {
+		return err
+	}
+
+	rp := retryPolicy{}
+	if err := n.Decode(&rp); err != nil {
+		return err
+	}
+
+	*r = RetryPolicy(rp)
+
+	return nil
+}
+
+// validate the retry policy
+func (r RetryPolicy) validate() error {
+	if r.Attempts < 1 {
+		return fmt.Errorf("retry attempts must be greater than or equal to 1, got %d", r.Attempts)
+	}
+
+	for _, c := range r.Codes {
+		if c < 100 || c > 599 {
+			return fmt.Errorf("retry codes must be HTTP status codes, got %d", c)
+		}
+	}
+
+	for _, c := range r.GRPCCodes {
+		if _, err := ParseGRPCCode(c); err != nil {
+			return err
+		}
+	}
+
+	if r.Backoff < 0 || r.MaxBackoff < 0 || r.PerTryTimeout < 0 {
+		return fmt.Errorf("retry intervals must be greater than or equal to 0")
+	}
+
+	return nil
+}
+
+// ParseGRPCCode returns the gRPC status code with the given name, e.g.
+// UNAVAILABLE
+func ParseGRPCCode(name string) (codes.Code, error) {
+	var c codes.Code
+
+	d, _ := json.Marshal(strings.ToUpper(name))
+	if err := c.UnmarshalJSON(d); err != nil {
+		return c, fmt.Errorf("unknown gRPC code %q", name)
+	}
+
+	return c, nil
+}
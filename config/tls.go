@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UpstreamTLS defines how the connection to a grpcs:// upstream is secured,
+// values which are not set use the UPSTREAM_TLS settings
+type UpstreamTLS struct {
+	// CALocation is the PEM encoded CA bundle used to verify the upstream
+	CALocation string `yaml:"ca_location,omitempty" json:"ca_location,omitempty"`
+	// CertLocation and KeyLocation are the PEM encoded certificate and key
+	// presented to the upstream for mutual TLS
+	CertLocation string `yaml:"cert_location,omitempty" json:"cert_location,omitempty"`
+	KeyLocation  string `yaml:"key_location,omitempty" json:"key_location,omitempty"`
+	// ServerName overrides the name used for SNI and to verify the upstream
+	// certificate, when not set the host from the URI is used
+	ServerName string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
+}
+
+// upstreamTLS has the same fields as UpstreamTLS without the custom encoding
+type upstreamTLS UpstreamTLS
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (t *UpstreamTLS) UnmarshalYAML(n *yaml.Node) error {
+	if err := checkFields(n, reflect.TypeOf(upstreamTLS{}), "tls"); err != nil {
+		return err
+	}
+
+	ut := upstreamTLS{}
+	if err := n.Decode(&ut); err != nil {
+		return err
+	}
+
+	*t = UpstreamTLS(ut)
+
+	return nil
+}
+
+// validate the TLS settings
+func (t UpstreamTLS) validate() error {
+	if (t.CertLocation == "") != (t.KeyLocation == "") {
+		return fmt.Errorf("tls cert_location and key_location must be set together")
+	}
+
+	return nil
+}
@@ -0,0 +1,248 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// UpstreamCall defines an upstream service and how it is called. In files
+// and the admin API an upstream can be written as a URI when it uses the
+// default settings, or as a block of values.
+type UpstreamCall struct {
+	URI string `yaml:"uri" json:"uri"`
+	// Method is the HTTP method used to call the upstream, when not set a GET
+	// is used or a POST when a request body is sent
+	Method string `yaml:"method,omitempty" json:"method,omitempty"`
+	// Headers are added to the upstream request, for gRPC upstreams the
+	// headers are sent as metadata
+	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// Body is a Go template which is rendered with the details of the
+	// inbound request, when not set the generated request body is sent
+	Body string `yaml:"body,omitempty" json:"body,omitempty"`
+	// Timeout for the upstream request, when not set
+	// HTTP_CLIENT_REQUEST_TIMEOUT is used
+	Timeout Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	// ExpectedCodes are the HTTP status codes which are treated as a
+	// successful response, when not set only 200 is successful
+	ExpectedCodes []int `yaml:"expected_codes,omitempty" json:"expected_codes,omitempty"`
+	// AppendRequest overrides HTTP_CLIENT_APPEND_REQUEST for the upstream
+	AppendRequest *bool `yaml:"append_request,omitempty" json:"append_request,omitempty"`
+	// Protocol overrides HTTP_CLIENT_PROTOCOL for the upstream, http1 or
+	// http2
+	Protocol string `yaml:"protocol,omitempty" json:"protocol,omitempty"`
+	// Retry defines how failed calls are retried, when not set failed calls
+	// are not retried
+	Retry *RetryPolicy `yaml:"retry,omitempty" json:"retry,omitempty"`
+	// Optional upstreams do not fail the request when the call fails and the
+	// failure policy is required
+	Optional bool `yaml:"optional,omitempty" json:"optional,omitempty"`
+	// Hedge sends a duplicate call when the upstream is slow to respond, when
+	// not set a single call is made
+	Hedge *HedgePolicy `yaml:"hedge,omitempty" json:"hedge,omitempty"`
+	// CircuitBreaker stops calls to the upstream while it is failing, when
+	// not set calls are always made
+	CircuitBreaker *CircuitBreakerPolicy `yaml:"circuit_breaker,omitempty" json:"circuit_breaker,omitempty"`
+	// TLS overrides the UPSTREAM_TLS settings for a grpcs:// upstream
+	TLS *UpstreamTLS `yaml:"tls,omitempty" json:"tls,omitempty"`
+}
+
+// upstreamCall has the same fields as UpstreamCall without the custom
+// encoding
+type upstreamCall UpstreamCall
+
+// isURI returns true when the call only defines the URI
+func (u UpstreamCall) isURI() bool {
+	return u.Method == "" &&
+		len(u.Headers) == 0 &&
+		u.Body == "" &&
+		u.Timeout == 0 &&
+		len(u.ExpectedCodes) == 0 &&
+		u.AppendRequest == nil &&
+		u.Protocol == "" &&
+		!u.Optional &&
+		u.Retry == nil &&
+		u.Hedge == nil &&
+		u.CircuitBreaker == nil &&
+		u.TLS == nil
+}
+
+// MarshalJSON implements the json.Marshaler interface
+func (u UpstreamCall) MarshalJSON() ([]byte, error) {
+	if u.isURI() {
+		return json.Marshal(u.URI)
+	}
+
+	return json.Marshal(upstreamCall(u))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface
+func (u *UpstreamCall) UnmarshalJSON(data []byte) error {
+	if strings.HasPrefix(strings.TrimSpace(string(data)), `"`) {
+		*u = UpstreamCall{}
+		return json.Unmarshal(data, &u.URI)
+	}
+
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+
+	uc := upstreamCall{}
+	if err := dec.Decode(&uc); err != nil {
+		return err
+	}
+
+	*u = UpstreamCall(uc)
+
+	return nil
+}
+
+// MarshalYAML implements the yaml.Marshaler interface
+func (u UpstreamCall) MarshalYAML() (interface{}, error) {
+	if u.isURI() {
+		return u.URI, nil
+	}
+
+	return upstreamCall(u), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface
+func (u *UpstreamCall) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		*u = UpstreamCall{URI: n.Value}
+		return nil
+	}
+
+	if err := checkFields(n, reflect.TypeOf(upstreamCall{}), "upstream"); err != nil {
+		return err
+	}
+
+	uc := upstreamCall{}
+	if err := n.Decode(&uc); err != nil {
+		return err
+	}
+
+	*u = UpstreamCall(uc)
+
+	return nil
+}
+
+// validate the upstream
+func (u UpstreamCall) validate() error {
+	if !strings.HasPrefix(u.URI, "http://") && !strings.HasPrefix(u.URI, "https://") && !IsHTTP3(u.URI) && !IsGRPC(u.URI) {
+		return fmt.Errorf("must start with http://, https://, h3://, grpc:// or grpcs://, got %q", u.URI)
+	}
+
+	if u.Timeout < 0 {
+		return fmt.Errorf("timeout for %s must be greater than or equal to 0, got %s", u.URI, u.Timeout)
+	}
+
+	for _, c := range u.ExpectedCodes {
+		if c < 100 || c > 599 {
+			return fmt.Errorf("expected_codes for %s must be HTTP status codes, got %d", u.URI, c)
+		}
+	}
+
+	if u.Protocol != "" {
+		if IsGRPC(u.URI) || IsHTTP3(u.URI) {
+			return fmt.Errorf("protocol can only be set for http:// and https:// upstreams, got %s", u.URI)
+		}
+
+		if u.Protocol != "http1" && u.Protocol != "http2" {
+			return fmt.Errorf("protocol for %s must be http1 or http2, got %q", u.URI, u.Protocol)
+		}
+	}
+
+	if u.Retry != nil {
+		if err := u.Retry.validate(); err != nil {
+			return fmt.Errorf("%s for %s", err, u.URI)
+		}
+	}
+
+	if u.Hedge != nil {
+		if err := u.Hedge.validate(); err != nil {
+			return fmt.Errorf("%s for %s", err, u.URI)
+		}
+	}
+
+	if u.CircuitBreaker != nil {
+		if err := u.CircuitBreaker.validate(); err != nil {
+			return fmt.Errorf("%s for %s", err, u.URI)
+		}
+	}
+
+	if u.TLS != nil {
+		if !strings.HasPrefix(u.URI, "grpcs://") {
+			return fmt.Errorf("tls can only be set for grpcs:// upstreams, got %s", u.URI)
+		}
+
+		if err := u.TLS.validate(); err != nil {
+			return fmt.Errorf("%s for %s", err, u.URI)
+		}
+	}
+
+	return nil
+}
+
+// IsGRPC returns true when the URI is for a gRPC upstream, grpcs:// upstreams
+// are called using TLS
+func IsGRPC(uri string) bool {
+	return strings.HasPrefix(uri, "grpc://") || strings.HasPrefix(uri, "grpcs://")
+}
+
+// IsHTTP3 returns true when the URI is for an upstream which is called using
+// HTTP/3, h3:// upstreams are called using https
+func IsHTTP3(uri string) bool {
+	return strings.HasPrefix(uri, "h3://")
+}
+
+// ParseUpstreamCalls parses the upstreams from an environment variable, the
+// value is either a comma separated list of URIs or a JSON list
+func ParseUpstreamCalls(s string) ([]UpstreamCall, error) {
+	calls := []UpstreamCall{}
+
+	if strings.HasPrefix(strings.TrimSpace(s), "[") {
+		if err := json.Unmarshal([]byte(s), &calls); err != nil {
+			return nil, fmt.Errorf("unable to parse upstreams: %s", err)
+		}
+
+		return calls, nil
+	}
+
+	for _, u := range strings.Split(s, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			calls = append(calls, UpstreamCall{URI: u})
+		}
+	}
+
+	return calls, nil
+}
+
+// FormatUpstreamCalls formats the upstreams so they can be parsed using
+// ParseUpstreamCalls, upstreams which only define a URI are written as a
+// comma separated list
+func FormatUpstreamCalls(calls []UpstreamCall) string {
+	uris := []string{}
+	for _, c := range calls {
+		if !c.isURI() {
+			d, _ := json.Marshal(calls)
+			return string(d)
+		}
+
+		uris = append(uris, c.URI)
+	}
+
+	return strings.Join(uris, ",")
+}
+
+// URIs returns the URI of each upstream
+func URIs(calls []UpstreamCall) []string {
+	uris := []string{}
+	for _, c := range calls {
+		uris = append(uris, c.URI)
+	}
+
+	return uris
+}
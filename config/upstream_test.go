@@ -0,0 +1,213 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var upstreamConfig = `
+upstream:
+  uris:
+    - http://api:9090
+    - uri: http://payments:9090/payments
+      method: POST
+      headers:
+        Content-Type: application/json
+      body: '{"amount": 10}'
+      timeout: 2s
+      expected_codes: [200, 201]
+      append_request: false
+`
+
+func TestParsesUpstreamsWithSettings(t *testing.T) {
+	f, err := Parse("config.yaml", []byte(upstreamConfig))
+	require.NoError(t, err)
+
+	calls := f.Config.Upstream.URIs
+	require.Len(t, calls, 2)
+	assert.Equal(t, UpstreamCall{URI: "http://api:9090"}, calls[0])
+	assert.Equal(t, "POST", calls[1].Method)
+	assert.Equal(t, "application/json", calls[1].Headers["Content-Type"])
+	assert.Equal(t, `{"amount": 10}`, calls[1].Body)
+	assert.Equal(t, Duration(2*time.Second), calls[1].Timeout)
+	assert.Equal(t, []int{200, 201}, calls[1].ExpectedCodes)
+	require.NotNil(t, calls[1].AppendRequest)
+	assert.False(t, *calls[1].AppendRequest)
+}
+
+func TestReturnsErrorForUnknownUpstreamField(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: http://api:9090\n      verb: POST\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `unknown upstream field "verb"`)
+}
+
+func TestReturnsErrorForInvalidExpectedCodes(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: http://api:9090\n      expected_codes: [1000]\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "expected_codes for http://api:9090 must be HTTP status codes, got 1000")
+}
+
+func TestUpstreamsWithSettingsAreFormattedAsJSON(t *testing.T) {
+	f, err := Parse("config.yaml", []byte(upstreamConfig))
+	require.NoError(t, err)
+
+	env := f.Environment()["UPSTREAM_URIS"]
+	assert.Contains(t, env, `"method":"POST"`)
+
+	calls, err := ParseUpstreamCalls(env)
+	require.NoError(t, err)
+	assert.Equal(t, f.Config.Upstream.URIs, calls)
+}
+
+func TestParsesUpstreamsFromCommaSeparatedList(t *testing.T) {
+	calls, err := ParseUpstreamCalls("http://api:9090, grpc://payments:9090,")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"http://api:9090", "grpc://payments:9090"}, URIs(calls))
+}
+
+func TestUpstreamsWithoutSettingsAreEncodedAsURI(t *testing.T) {
+	d, err := json.Marshal([]UpstreamCall{{URI: "http://api:9090"}, {URI: "http://payments:9090", Method: "POST"}})
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `["http://api:9090", {"uri": "http://payments:9090", "method": "POST"}]`, string(d))
+}
+
+func TestParsesUpstreamRetryPolicy(t *testing.T) {
+	f, err := Parse("config.yaml", []byte(`
+upstream:
+  uris:
+    - uri: http://api:9090
+      retry:
+        attempts: 3
+        codes: [503]
+        backoff: 10ms
+        max_backoff: 100ms
+        per_try_timeout: 1s
+`))
+	require.NoError(t, err)
+
+	r := f.Config.Upstream.URIs[0].Retry
+	require.NotNil(t, r)
+	assert.Equal(t, 3, r.Attempts)
+	assert.Equal(t, []int{503}, r.Codes)
+	assert.Equal(t, Duration(10*time.Millisecond), r.Backoff)
+	assert.Equal(t, Duration(100*time.Millisecond), r.MaxBackoff)
+	assert.Equal(t, Duration(time.Second), r.PerTryTimeout)
+}
+
+func TestReturnsErrorForUnknownRetryField(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: http://api:9090\n      retry:\n        attempts: 2\n        tries: 3\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `unknown retry field "tries"`)
+}
+
+func TestReturnsErrorForInvalidRetryAttempts(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: http://api:9090\n      retry:\n        attempts: 0\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "retry attempts must be greater than or equal to 1, got 0 for http://api:9090")
+}
+
+func TestReturnsErrorForUnknownRetryGRPCCode(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: grpc://api:9090\n      retry:\n        attempts: 2\n        grpc_codes: [BROKEN]\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `unknown gRPC code "BROKEN"`)
+}
+
+func TestParsesGRPCCodeNames(t *testing.T) {
+	c, err := ParseGRPCCode("unavailable")
+	require.NoError(t, err)
+
+	assert.Equal(t, "Unavailable", c.String())
+}
+
+func TestReturnsErrorForCircuitBreakerWithoutThreshold(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: http://api:9090\n      circuit_breaker:\n        open_duration: 10s\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "circuit_breaker must set consecutive_failures or error_rate for http://api:9090")
+}
+
+func TestReturnsErrorForInvalidCircuitBreakerErrorRate(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: http://api:9090\n      circuit_breaker:\n        error_rate: 50\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "circuit_breaker error_rate must be between 0 and 1, got 50")
+}
+
+func TestParsesOptionalUpstreamsAndFailurePolicy(t *testing.T) {
+	f, err := Parse("config.yaml", []byte("upstream:\n  failure_policy: quorum\n  quorum: 1\n  uris:\n    - uri: http://api:9090\n      optional: true\n"))
+	require.NoError(t, err)
+
+	assert.True(t, f.Config.Upstream.URIs[0].Optional)
+	assert.Equal(t, "quorum", f.Config.Upstream.FailurePolicy)
+	assert.Equal(t, "1", f.Environment()["UPSTREAM_QUORUM"])
+}
+
+func TestReturnsErrorForHedgeWithoutDelay(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: http://api:9090\n      hedge:\n        percentile: 95\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "hedge delay must be greater than 0 for http://api:9090")
+}
+
+func TestParsesUpstreamTLS(t *testing.T) {
+	f, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: grpcs://api:9090\n      tls:\n        ca_location: /certs/ca.pem\n        server_name: api.mesh\n"))
+	require.NoError(t, err)
+
+	tls := f.Config.Upstream.URIs[0].TLS
+	require.NotNil(t, tls)
+	assert.Equal(t, "/certs/ca.pem", tls.CALocation)
+	assert.Equal(t, "api.mesh", tls.ServerName)
+}
+
+func TestReturnsErrorForTLSOnPlainUpstream(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: grpc://api:9090\n      tls:\n        server_name: api.mesh\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "tls can only be set for grpcs:// upstreams, got grpc://api:9090")
+}
+
+func TestReturnsErrorForTLSCertWithoutKey(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: grpcs://api:9090\n      tls:\n        cert_location: /certs/client.pem\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "tls cert_location and key_location must be set together for grpcs://api:9090")
+}
+
+func TestParsesUpstreamProtocol(t *testing.T) {
+	f, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: http://api:9090\n      protocol: http2\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "http2", f.Config.Upstream.URIs[0].Protocol)
+}
+
+func TestReturnsErrorForUnknownUpstreamProtocol(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: http://api:9090\n      protocol: http3\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), `protocol for http://api:9090 must be http1 or http2, got "http3"`)
+}
+
+func TestReturnsErrorForProtocolOnGRPCUpstream(t *testing.T) {
+	_, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - uri: grpc://api:9090\n      protocol: http2\n"))
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "protocol can only be set for http:// and https:// upstreams, got grpc://api:9090")
+}
+
+func TestParsesHTTP3Upstream(t *testing.T) {
+	f, err := Parse("config.yaml", []byte("upstream:\n  uris:\n    - h3://api:9090\n"))
+	require.NoError(t, err)
+
+	assert.True(t, IsHTTP3(f.Config.Upstream.URIs[0].URI))
+}
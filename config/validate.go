@@ -0,0 +1,93 @@
+package config
+
+import (
+	"reflect"
+)
+
+// ValidateChanges validates the values which differ from the previous
+// configuration, changes to values which can only be set when the service
+// starts are rejected
+func (c *Config) ValidateChanges(previous *Config) ValidationErrors {
+	var errs ValidationErrors
+
+	walkChanges(reflect.ValueOf(c).Elem(), reflect.ValueOf(previous).Elem(), "", func(f reflect.StructField, v, pv reflect.Value, path string) {
+		if f.Tag.Get("restart") == "true" {
+			errs = append(errs, ValidationError{Field: path, Message: "can only be changed by restarting the service"})
+			return
+		}
+
+		if err := validateValue(v, f.Tag.Get("validate")); err != nil {
+			errs = append(errs, ValidationError{Field: path, Message: err.Error()})
+		}
+	})
+
+	return errs
+}
+
+// ResetRestartValues sets any values which can only be set when the service
+// starts back to the previous value and returns the fields which were reset
+func (c *Config) ResetRestartValues(previous *Config) []string {
+	fields := []string{}
+
+	walkChanges(reflect.ValueOf(c).Elem(), reflect.ValueOf(previous).Elem(), "", func(f reflect.StructField, v, pv reflect.Value, path string) {
+		if f.Tag.Get("restart") == "true" {
+			v.Set(pv)
+			fields = append(fields, path)
+		}
+	})
+
+	return fields
+}
+
+// RestartValues returns a configuration which only contains the values which
+// can only be set when the service starts
+func (c *Config) RestartValues() Config {
+	r := Config{}
+	r.ResetRestartValues(c)
+
+	return r
+}
+
+// ChangedValues returns the fields which differ from the previous
+// configuration
+func (c *Config) ChangedValues(previous *Config) []string {
+	fields := []string{}
+
+	walkChanges(reflect.ValueOf(c).Elem(), reflect.ValueOf(previous).Elem(), "", func(f reflect.StructField, v, pv reflect.Value, path string) {
+		fields = append(fields, path)
+	})
+
+	return fields
+}
+
+// KeepValues sets the given fields which differ from the kept configuration
+// back to the kept value and returns the fields which were set
+func (c *Config) KeepValues(kept *Config, fields map[string]bool) []string {
+	set := []string{}
+
+	walkChanges(reflect.ValueOf(c).Elem(), reflect.ValueOf(kept).Elem(), "", func(f reflect.StructField, v, pv reflect.Value, path string) {
+		if fields[path] {
+			v.Set(pv)
+			set = append(set, path)
+		}
+	})
+
+	return set
+}
+
+// walkChanges calls changed for every value which differs between v and pv
+func walkChanges(v, pv reflect.Value, prefix string, changed func(f reflect.StructField, v, pv reflect.Value, path string)) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		path := prefix + f.Tag.Get("yaml")
+
+		if isSection(f.Type) {
+			walkChanges(v.Field(i), pv.Field(i), path+".", changed)
+			continue
+		}
+
+		if !reflect.DeepEqual(v.Field(i).Interface(), pv.Field(i).Interface()) {
+			changed(f, v.Field(i), pv.Field(i), path)
+		}
+	}
+}
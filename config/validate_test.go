@@ -0,0 +1,103 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateChangesReturnsNoErrorsForValidChanges(t *testing.T) {
+	f, err := Parse("config.yaml", []byte(validConfig))
+	require.NoError(t, err)
+
+	c := *f.Config
+	c.Errors.Rate = 0.5
+	c.Message = "changed"
+
+	assert.Empty(t, c.ValidateChanges(f.Config))
+}
+
+func TestValidateChangesReturnsErrorsForInvalidValues(t *testing.T) {
+	f, err := Parse("config.yaml", []byte(validConfig))
+	require.NoError(t, err)
+
+	c := *f.Config
+	c.Errors.Rate = 2
+	c.Errors.Type = "explode"
+
+	errs := c.ValidateChanges(f.Config)
+	require.Len(t, errs, 2)
+	assert.Equal(t, "errors.rate", errs[0].Field)
+	assert.Equal(t, "must be less than or equal to 1, got 2", errs[0].Message)
+	assert.Equal(t, "errors.type", errs[1].Field)
+}
+
+func TestValidateChangesIgnoresUnchangedInvalidValues(t *testing.T) {
+	// values which are not changed are not validated, the defaults for some
+	// values like the upstream workers are not valid for an empty config
+	c := Config{}
+	c.Message = "changed"
+
+	assert.Empty(t, c.ValidateChanges(&Config{}))
+}
+
+func TestValidateChangesRejectsValuesWhichRequireRestart(t *testing.T) {
+	c := Config{}
+	c.ListenAddr = "0.0.0.0:8080"
+
+	errs := c.ValidateChanges(&Config{})
+	require.Len(t, errs, 1)
+	assert.Equal(t, "listen_addr", errs[0].Field)
+	assert.Equal(t, "can only be changed by restarting the service", errs[0].Message)
+}
+
+func TestResetRestartValuesSetsPreviousValue(t *testing.T) {
+	c := Config{}
+	c.ListenAddr = "0.0.0.0:8080"
+	c.Logging.Level = "debug"
+	c.Message = "changed"
+
+	fields := c.ResetRestartValues(&Config{ListenAddr: "0.0.0.0:9090"})
+
+	assert.Equal(t, []string{"listen_addr", "logging.level"}, fields)
+	assert.Equal(t, "0.0.0.0:9090", c.ListenAddr)
+	assert.Equal(t, "", c.Logging.Level)
+	assert.Equal(t, "changed", c.Message)
+}
+
+func TestRestartValuesOnlyContainsValuesWhichRequireRestart(t *testing.T) {
+	c := Config{ListenAddr: "0.0.0.0:9090", Message: "hello"}
+	c.Logging.Level = "debug"
+	c.Errors.Rate = 0.5
+
+	r := c.RestartValues()
+
+	assert.Equal(t, "0.0.0.0:9090", r.ListenAddr)
+	assert.Equal(t, "debug", r.Logging.Level)
+	assert.Equal(t, "", r.Message)
+	assert.Equal(t, 0.0, r.Errors.Rate)
+}
+
+func TestChangedValuesReturnsChangedFields(t *testing.T) {
+	c := Config{Message: "changed"}
+	c.Errors.Rate = 0.5
+
+	assert.Equal(t, []string{"message", "errors.rate"}, c.ChangedValues(&Config{}))
+}
+
+func TestKeepValuesSetsKeptValue(t *testing.T) {
+	c := Config{Message: "from file"}
+	c.Errors.Rate = 0.1
+	c.Errors.Code = 500
+
+	kept := Config{Message: "from admin"}
+	kept.Errors.Rate = 0.5
+
+	fields := c.KeepValues(&kept, map[string]bool{"errors.rate": true, "timing.p50": true})
+
+	assert.Equal(t, []string{"errors.rate"}, fields)
+	assert.Equal(t, 0.5, c.Errors.Rate)
+	assert.Equal(t, 500, c.Errors.Code)
+	assert.Equal(t, "from file", c.Message)
+}
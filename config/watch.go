@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Watch polls the files returned by the files function at the given interval
+// and calls changed when the modification time or size of any of the files
+// changes. Polling is used rather than file system notifications as
+// Kubernetes ConfigMaps are updated by replacing a symlink. The returned
+// function stops watching.
+func Watch(interval time.Duration, files func() []string, changed func()) func() {
+	done := make(chan struct{})
+	last := fileStates(files())
+
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				current := fileStates(files())
+				if !statesEqual(last, current) {
+					last = current
+					changed()
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func fileStates(files []string) map[string]fileState {
+	states := map[string]fileState{}
+
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+
+		// a missing file has an empty state, when it is created it will be
+		// detected as a change
+		fi, err := os.Stat(f)
+		if err != nil {
+			states[f] = fileState{}
+			continue
+		}
+
+		states[f] = fileState{modTime: fi.ModTime(), size: fi.Size()}
+	}
+
+	return states
+}
+
+func statesEqual(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || !bv.modTime.Equal(v.modTime) || bv.size != v.size {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchCallsChangedWhenFileChanges(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(fn, []byte("name: web"), 0644)
+
+	var count int32
+	stop := Watch(5*time.Millisecond, func() []string { return []string{fn} }, func() {
+		atomic.AddInt32(&count, 1)
+	})
+	defer stop()
+
+	os.WriteFile(fn, []byte("name: api"), 0644)
+
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&count) == 1 }, 1*time.Second, 5*time.Millisecond)
+}
+
+func TestWatchDoesNotCallChangedWhenFileIsNotModified(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(fn, []byte("name: web"), 0644)
+
+	var count int32
+	stop := Watch(5*time.Millisecond, func() []string { return []string{fn, ""} }, func() {
+		atomic.AddInt32(&count, 1)
+	})
+	defer stop()
+
+	time.Sleep(50 * time.Millisecond)
+
+	assert.Equal(t, int32(0), atomic.LoadInt32(&count))
+}
@@ -19,6 +19,13 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type FakeServiceClient interface {
 	Handle(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	// ServerStream sends a stream of responses to a single request
+	ServerStream(ctx context.Context, in *Request, opts ...grpc.CallOption) (FakeService_ServerStreamClient, error)
+	// ClientStream reads a stream of requests and sends a single response
+	// when the client closes the stream
+	ClientStream(ctx context.Context, opts ...grpc.CallOption) (FakeService_ClientStreamClient, error)
+	// BidirectionalStream sends a response for every request
+	BidirectionalStream(ctx context.Context, opts ...grpc.CallOption) (FakeService_BidirectionalStreamClient, error)
 }
 
 type fakeServiceClient struct {
@@ -38,11 +45,115 @@ func (c *fakeServiceClient) Handle(ctx context.Context, in *Request, opts ...grp
 	return out, nil
 }
 
+func (c *fakeServiceClient) ServerStream(ctx context.Context, in *Request, opts ...grpc.CallOption) (FakeService_ServerStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FakeService_ServiceDesc.Streams[0], "/FakeService/ServerStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fakeServiceServerStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type FakeService_ServerStreamClient interface {
+	Recv() (*Response, error)
+	grpc.ClientStream
+}
+
+type fakeServiceServerStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *fakeServiceServerStreamClient) Recv() (*Response, error) {
+	m := new(Response)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fakeServiceClient) ClientStream(ctx context.Context, opts ...grpc.CallOption) (FakeService_ClientStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FakeService_ServiceDesc.Streams[1], "/FakeService/ClientStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fakeServiceClientStreamClient{stream}
+	return x, nil
+}
+
+type FakeService_ClientStreamClient interface {
+	Send(*Request) error
+	CloseAndRecv() (*Response, error)
+	grpc.ClientStream
+}
+
+type fakeServiceClientStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *fakeServiceClientStreamClient) Send(m *Request) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fakeServiceClientStreamClient) CloseAndRecv() (*Response, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Response)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *fakeServiceClient) BidirectionalStream(ctx context.Context, opts ...grpc.CallOption) (FakeService_BidirectionalStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FakeService_ServiceDesc.Streams[2], "/FakeService/BidirectionalStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &fakeServiceBidirectionalStreamClient{stream}
+	return x, nil
+}
+
+type FakeService_BidirectionalStreamClient interface {
+	Send(*Request) error
+	Recv() (*Response, error)
+	grpc.ClientStream
+}
+
+type fakeServiceBidirectionalStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *fakeServiceBidirectionalStreamClient) Send(m *Request) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *fakeServiceBidirectionalStreamClient) Recv() (*Response, error) {
+	m := new(Response)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // FakeServiceServer is the server API for FakeService service.
 // All implementations must embed UnimplementedFakeServiceServer
 // for forward compatibility
 type FakeServiceServer interface {
 	Handle(context.Context, *Request) (*Response, error)
+	// ServerStream sends a stream of responses to a single request
+	ServerStream(*Request, FakeService_ServerStreamServer) error
+	// ClientStream reads a stream of requests and sends a single response
+	// when the client closes the stream
+	ClientStream(FakeService_ClientStreamServer) error
+	// BidirectionalStream sends a response for every request
+	BidirectionalStream(FakeService_BidirectionalStreamServer) error
 	mustEmbedUnimplementedFakeServiceServer()
 }
 
@@ -53,6 +164,15 @@ type UnimplementedFakeServiceServer struct {
 func (UnimplementedFakeServiceServer) Handle(context.Context, *Request) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Handle not implemented")
 }
+func (UnimplementedFakeServiceServer) ServerStream(*Request, FakeService_ServerStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method ServerStream not implemented")
+}
+func (UnimplementedFakeServiceServer) ClientStream(FakeService_ClientStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method ClientStream not implemented")
+}
+func (UnimplementedFakeServiceServer) BidirectionalStream(FakeService_BidirectionalStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method BidirectionalStream not implemented")
+}
 func (UnimplementedFakeServiceServer) mustEmbedUnimplementedFakeServiceServer() {}
 
 // UnsafeFakeServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -84,6 +204,79 @@ func _FakeService_Handle_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _FakeService_ServerStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Request)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FakeServiceServer).ServerStream(m, &fakeServiceServerStreamServer{stream})
+}
+
+type FakeService_ServerStreamServer interface {
+	Send(*Response) error
+	grpc.ServerStream
+}
+
+type fakeServiceServerStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *fakeServiceServerStreamServer) Send(m *Response) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _FakeService_ClientStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FakeServiceServer).ClientStream(&fakeServiceClientStreamServer{stream})
+}
+
+type FakeService_ClientStreamServer interface {
+	SendAndClose(*Response) error
+	Recv() (*Request, error)
+	grpc.ServerStream
+}
+
+type fakeServiceClientStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *fakeServiceClientStreamServer) SendAndClose(m *Response) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fakeServiceClientStreamServer) Recv() (*Request, error) {
+	m := new(Request)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _FakeService_BidirectionalStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FakeServiceServer).BidirectionalStream(&fakeServiceBidirectionalStreamServer{stream})
+}
+
+type FakeService_BidirectionalStreamServer interface {
+	Send(*Response) error
+	Recv() (*Request, error)
+	grpc.ServerStream
+}
+
+type fakeServiceBidirectionalStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *fakeServiceBidirectionalStreamServer) Send(m *Response) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *fakeServiceBidirectionalStreamServer) Recv() (*Request, error) {
+	m := new(Request)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // FakeService_ServiceDesc is the grpc.ServiceDesc for FakeService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -96,6 +289,23 @@ var FakeService_ServiceDesc = grpc.ServiceDesc{
 			Handler:    _FakeService_Handle_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ServerStream",
+			Handler:       _FakeService_ServerStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ClientStream",
+			Handler:       _FakeService_ClientStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "BidirectionalStream",
+			Handler:       _FakeService_BidirectionalStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "api.proto",
 }
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// ConfigStore holds the configuration of the running service
+type ConfigStore interface {
+	// Config returns the effective configuration
+	Config() config.Config
+	// Update validates and applies the configuration, if the configuration
+	// is invalid a config.ValidationErrors is returned
+	Update(c config.Config) error
+}
+
+// CircuitBreakerStore holds the circuit breakers for the upstreams of the
+// running service
+type CircuitBreakerStore interface {
+	// CircuitBreakers returns the circuit breakers keyed by upstream URI
+	CircuitBreakers() map[string]*CircuitBreaker
+}
+
+// Admin defines the handler which allows the configuration of the service to
+// be read and modified while it is running
+type Admin struct {
+	logger   *logging.Logger
+	store    ConfigStore
+	breakers CircuitBreakerStore
+}
+
+// AdminErrors is returned when the configuration can not be updated
+type AdminErrors struct {
+	Errors config.ValidationErrors `json:"errors"`
+}
+
+// NewAdmin creates a new admin handler
+func NewAdmin(logger *logging.Logger, store ConfigStore, breakers CircuitBreakerStore) *Admin {
+	return &Admin{
+		logger:   logger,
+		store:    store,
+		breakers: breakers,
+	}
+}
+
+// Handle the request
+//
+// GET returns the effective configuration, PUT replaces the configuration
+// apart from values which can only be set when the service starts, and PATCH
+// modifies the values which are defined in the request body
+func (a *Admin) Handle(rw http.ResponseWriter, r *http.Request) {
+	a.logger.Log().Info("Admin called", "method", r.Method, "path", r.URL.Path)
+
+	switch r.Method {
+	case http.MethodGet:
+		a.writeConfig(rw, a.store.Config())
+	case http.MethodPut, http.MethodPatch:
+		a.updateConfig(rw, r)
+	default:
+		rw.Header().Set("Allow", "GET, PUT, PATCH")
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleCircuitBreakers returns the state of the circuit breakers for the
+// upstreams ordered by URI
+func (a *Admin) HandleCircuitBreakers(rw http.ResponseWriter, r *http.Request) {
+	a.logger.Log().Info("Admin called", "method", r.Method, "path", r.URL.Path)
+
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(CircuitBreakerStatuses(a.breakers.CircuitBreakers()))
+}
+
+func (a *Admin) updateConfig(rw http.ResponseWriter, r *http.Request) {
+	// a patch starts from the current configuration so that only the values
+	// in the request are changed, a put starts from the values which can only
+	// be set when the service starts so that they can be omitted. The config
+	// is copied by encoding it so that the lists in the current configuration
+	// are not modified.
+	current := a.store.Config()
+	if r.Method == http.MethodPut {
+		current = current.RestartValues()
+	}
+
+	c := config.Config{}
+	d, _ := json.Marshal(current)
+	json.Unmarshal(d, &c)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(&c)
+	if err != nil {
+		a.writeErrors(rw, config.ValidationErrors{decodeError(err)})
+		return
+	}
+
+	err = a.store.Update(c)
+	if errs, ok := err.(config.ValidationErrors); ok {
+		a.writeErrors(rw, errs)
+		return
+	}
+
+	if err != nil {
+		a.logger.Log().Error("Unable to update configuration", "error", err)
+		a.writeErrors(rw, config.ValidationErrors{{Message: err.Error()}})
+		return
+	}
+
+	a.logger.Log().Info("Updated configuration")
+
+	a.writeConfig(rw, a.store.Config())
+}
+
+func (a *Admin) writeConfig(rw http.ResponseWriter, c config.Config) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(c)
+}
+
+func (a *Admin) writeErrors(rw http.ResponseWriter, errs config.ValidationErrors) {
+	a.logger.Log().Error("Invalid configuration", "error", errs)
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(rw).Encode(AdminErrors{Errors: errs})
+}
+
+// decodeError converts an error returned when decoding the request body into
+// a validation error, setting the field when it is known
+func decodeError(err error) config.ValidationError {
+	if te, ok := err.(*json.UnmarshalTypeError); ok && te.Field != "" {
+		return config.ValidationError{Field: te.Field, Message: fmt.Sprintf("expected %s, got %s", te.Type, te.Value)}
+	}
+
+	return config.ValidationError{Message: err.Error()}
+}
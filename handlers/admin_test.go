@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testConfigStore struct {
+	config   config.Config
+	updated  *config.Config
+	breakers map[string]*CircuitBreaker
+}
+
+func (s *testConfigStore) Config() config.Config {
+	return s.config
+}
+
+func (s *testConfigStore) Update(c config.Config) error {
+	if errs := c.ValidateChanges(&s.config); len(errs) > 0 {
+		return errs
+	}
+
+	s.updated = &c
+	s.config = c
+
+	return nil
+}
+
+func (s *testConfigStore) CircuitBreakers() map[string]*CircuitBreaker {
+	return s.breakers
+}
+
+func setupAdmin(t *testing.T) (*Admin, *testConfigStore) {
+	s := &testConfigStore{
+		config: config.Config{
+			Name:    "test",
+			Message: "hello world",
+			Upstream: config.Upstream{
+				URIs:    []config.UpstreamCall{{URI: "http://api:9090"}},
+				Workers: 1,
+			},
+			Timing: config.Timing{Percentile50: config.Duration(10 * time.Millisecond)},
+			Errors: config.Errors{Type: "http_error", Code: 500},
+		},
+	}
+
+	return NewAdmin(logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil), s, s), s
+}
+
+func TestAdminGetReturnsConfig(t *testing.T) {
+	a, _ := setupAdmin(t)
+	r := httptest.NewRequest(http.MethodGet, "/admin/config", nil)
+	rr := httptest.NewRecorder()
+
+	a.Handle(rr, r)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	c := config.Config{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &c))
+	assert.Equal(t, "hello world", c.Message)
+	assert.Contains(t, rr.Body.String(), `"p50":"10ms"`)
+}
+
+func TestAdminPatchUpdatesDefinedValues(t *testing.T) {
+	a, s := setupAdmin(t)
+	r := httptest.NewRequest(http.MethodPatch, "/admin/config", bytes.NewBufferString(`{"errors":{"rate":0.5},"timing":{"p90":"50ms"}}`))
+	rr := httptest.NewRecorder()
+
+	a.Handle(rr, r)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NotNil(t, s.updated)
+	assert.Equal(t, 0.5, s.updated.Errors.Rate)
+	assert.Equal(t, "http_error", s.updated.Errors.Type)
+	assert.Equal(t, config.Duration(50*time.Millisecond), s.updated.Timing.Percentile90)
+	assert.Equal(t, "hello world", s.updated.Message)
+}
+
+func TestAdminPatchDoesNotModifyCurrentLists(t *testing.T) {
+	a, s := setupAdmin(t)
+	current := s.config
+	r := httptest.NewRequest(http.MethodPatch, "/admin/config", bytes.NewBufferString(`{"upstream":{"uris":["http://payments:9090"]}}`))
+	rr := httptest.NewRecorder()
+
+	a.Handle(rr, r)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{"http://payments:9090"}, config.URIs(s.updated.Upstream.URIs))
+	assert.Equal(t, []string{"http://api:9090"}, config.URIs(current.Upstream.URIs))
+}
+
+func TestAdminPutReplacesConfig(t *testing.T) {
+	a, s := setupAdmin(t)
+	s.config = config.Config{Message: "hello world"}
+	r := httptest.NewRequest(http.MethodPut, "/admin/config", bytes.NewBufferString(`{"message":"replaced"}`))
+	rr := httptest.NewRecorder()
+
+	a.Handle(rr, r)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, config.Config{Message: "replaced"}, *s.updated)
+}
+
+func TestAdminPutKeepsOmittedValuesWhichRequireRestart(t *testing.T) {
+	a, s := setupAdmin(t)
+	s.config.ListenAddr = "0.0.0.0:9090"
+	r := httptest.NewRequest(http.MethodPut, "/admin/config", bytes.NewBufferString(`{"message":"replaced","upstream":{"workers":2},"errors":{"type":"delay"}}`))
+	rr := httptest.NewRecorder()
+
+	a.Handle(rr, r)
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "replaced", s.updated.Message)
+	assert.Equal(t, "test", s.updated.Name)
+	assert.Equal(t, "0.0.0.0:9090", s.updated.ListenAddr)
+	assert.Empty(t, s.updated.Upstream.URIs)
+}
+
+func TestAdminReturnsStructuredErrorsForInvalidValues(t *testing.T) {
+	a, s := setupAdmin(t)
+	r := httptest.NewRequest(http.MethodPatch, "/admin/config", bytes.NewBufferString(`{"errors":{"rate":2},"name":"changed"}`))
+	rr := httptest.NewRecorder()
+
+	a.Handle(rr, r)
+
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Nil(t, s.updated)
+
+	resp := AdminErrors{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp.Errors, 2)
+	assert.Equal(t, "name", resp.Errors[0].Field)
+	assert.Equal(t, "can only be changed by restarting the service", resp.Errors[0].Message)
+	assert.Equal(t, "errors.rate", resp.Errors[1].Field)
+	assert.Equal(t, "must be less than or equal to 1, got 2", resp.Errors[1].Message)
+}
+
+func TestAdminReturnsErrorForInvalidType(t *testing.T) {
+	a, s := setupAdmin(t)
+	r := httptest.NewRequest(http.MethodPatch, "/admin/config", bytes.NewBufferString(`{"errors":{"rate":"high"}}`))
+	rr := httptest.NewRecorder()
+
+	a.Handle(rr, r)
+
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Nil(t, s.updated)
+
+	resp := AdminErrors{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp.Errors, 1)
+	assert.Equal(t, "errors.rate", resp.Errors[0].Field)
+	assert.Equal(t, "expected float64, got string", resp.Errors[0].Message)
+}
+
+func TestAdminReturnsErrorForUnknownField(t *testing.T) {
+	a, s := setupAdmin(t)
+	r := httptest.NewRequest(http.MethodPatch, "/admin/config", bytes.NewBufferString(`{"errors":{"percent":2}}`))
+	rr := httptest.NewRecorder()
+
+	a.Handle(rr, r)
+
+	require.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Nil(t, s.updated)
+	assert.Contains(t, rr.Body.String(), `unknown field \"percent\"`)
+}
+
+func TestAdminReturnsMethodNotAllowed(t *testing.T) {
+	a, _ := setupAdmin(t)
+	r := httptest.NewRequest(http.MethodDelete, "/admin/config", nil)
+	rr := httptest.NewRecorder()
+
+	a.Handle(rr, r)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestAdminReturnsCircuitBreakerState(t *testing.T) {
+	a, s := setupAdmin(t)
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+
+	cb := NewCircuitBreaker("http://payments:9090", config.CircuitBreakerPolicy{ConsecutiveFailures: 1}, l)
+	done, _ := cb.Allow()
+	done(CallFailed)
+
+	s.breakers = map[string]*CircuitBreaker{
+		"http://payments:9090": cb,
+		"http://api:9090":      NewCircuitBreaker("http://api:9090", config.CircuitBreakerPolicy{ConsecutiveFailures: 1}, l),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/admin/circuit_breakers", nil)
+	rr := httptest.NewRecorder()
+
+	a.HandleCircuitBreakers(rr, r)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	statuses := []CircuitBreakerStatus{}
+	err := json.Unmarshal(rr.Body.Bytes(), &statuses)
+	require.NoError(t, err)
+
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "http://api:9090", statuses[0].URI)
+	assert.Equal(t, CircuitClosed, statuses[0].State)
+	assert.Equal(t, "http://payments:9090", statuses[1].URI)
+	assert.Equal(t, CircuitOpen, statuses[1].State)
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+const (
+	defaultCircuitBreakerMinRequests  = 10
+	defaultCircuitBreakerWindow       = 10 * time.Second
+	defaultCircuitBreakerOpenDuration = 5 * time.Second
+)
+
+// States of a circuit breaker
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// CallResult is the result of a call recorded by a circuit breaker
+type CallResult int
+
+// Results of a call
+const (
+	CallSucceeded CallResult = iota
+	CallFailed
+	// CallCancelled is not recorded, the call neither succeeded nor failed
+	CallCancelled
+)
+
+// ErrCircuitOpen is returned when a call is not made because the circuit
+// breaker for the upstream is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker stops calls to an upstream after it has failed, once the
+// open duration has elapsed a number of probe calls are allowed and when
+// they all succeed the circuit breaker closes
+type CircuitBreaker struct {
+	mutex  sync.Mutex
+	uri    string
+	policy config.CircuitBreakerPolicy
+	log    *logging.Logger
+	now    func() time.Time
+
+	state               string
+	openedAt            time.Time
+	consecutiveFailures int
+	windowStart         time.Time
+	requests            int
+	failures            int
+	probes              int
+	probeSuccesses      int
+	// generation changes every time the state changes so that the result of
+	// a call started in a previous state is ignored
+	generation int
+}
+
+// CircuitBreakerStatus is the current state of a circuit breaker
+type CircuitBreakerStatus struct {
+	URI                 string `json:"uri"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	Requests            int    `json:"requests"`
+	Failures            int    `json:"failures"`
+	OpenedAt            string `json:"opened_at,omitempty"`
+}
+
+// NewCircuitBreaker creates a closed circuit breaker for the upstream, the
+// policy must have been validated
+func NewCircuitBreaker(uri string, p config.CircuitBreakerPolicy, l *logging.Logger) *CircuitBreaker {
+	return &CircuitBreaker{
+		uri:         uri,
+		policy:      p,
+		log:         l,
+		now:         time.Now,
+		state:       CircuitClosed,
+		windowStart: time.Now(),
+	}
+}
+
+// Policy returns the policy the circuit breaker was created with
+func (b *CircuitBreaker) Policy() config.CircuitBreakerPolicy {
+	return b.policy
+}
+
+// Allow returns an error wrapping ErrCircuitOpen when the call must not be
+// made, otherwise the returned function must be called with the result of
+// the call. A nil circuit breaker allows every call.
+func (b *CircuitBreaker) Allow() (func(CallResult), error) {
+	if b == nil {
+		return func(CallResult) {}, nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.openDuration() {
+		b.setState(CircuitHalfOpen)
+	}
+
+	switch b.state {
+	case CircuitOpen:
+		return nil, fmt.Errorf("%w for upstream %s", ErrCircuitOpen, b.uri)
+	case CircuitHalfOpen:
+		if b.probes >= b.halfOpenRequests() {
+			return nil, fmt.Errorf("%w for upstream %s, waiting for probe requests", ErrCircuitOpen, b.uri)
+		}
+
+		b.probes++
+	}
+
+	gen := b.generation
+
+	return func(r CallResult) {
+		b.record(gen, r)
+	}, nil
+}
+
+// Status returns the current state of the circuit breaker
+func (b *CircuitBreaker) Status() CircuitBreakerStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	s := CircuitBreakerStatus{
+		URI:                 b.uri,
+		State:               b.state,
+		ConsecutiveFailures: b.consecutiveFailures,
+		Requests:            b.requests,
+		Failures:            b.failures,
+	}
+
+	if b.state != CircuitClosed {
+		s.OpenedAt = b.openedAt.Format(timeFormat)
+	}
+
+	return s
+}
+
+// record the result of a call, a cancelled call is ignored apart from
+// releasing its probe so that another call can be made while half open
+func (b *CircuitBreaker) record(gen int, r CallResult) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if gen != b.generation {
+		return
+	}
+
+	if r == CallCancelled {
+		if b.state == CircuitHalfOpen {
+			b.probes--
+		}
+
+		return
+	}
+
+	success := r == CallSucceeded
+
+	if b.state == CircuitHalfOpen {
+		if !success {
+			b.setState(CircuitOpen)
+			return
+		}
+
+		b.probeSuccesses++
+		if b.probeSuccesses >= b.halfOpenRequests() {
+			b.setState(CircuitClosed)
+		}
+
+		return
+	}
+
+	now := b.now()
+	if now.Sub(b.windowStart) >= b.window() {
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+	}
+
+	b.requests++
+
+	if success {
+		b.consecutiveFailures = 0
+	} else {
+		b.failures++
+		b.consecutiveFailures++
+	}
+
+	if b.policy.ConsecutiveFailures > 0 && b.consecutiveFailures >= b.policy.ConsecutiveFailures {
+		b.setState(CircuitOpen)
+		return
+	}
+
+	if b.policy.ErrorRate > 0 && b.requests >= b.minRequests() &&
+		float64(b.failures)/float64(b.requests) >= b.policy.ErrorRate {
+		b.setState(CircuitOpen)
+	}
+}
+
+// setState changes the state and resets the counters, the caller must hold
+// the mutex
+func (b *CircuitBreaker) setState(s string) {
+	b.log.CircuitBreakerStateChanged(b.uri, b.state, s)
+
+	now := b.now()
+	if s == CircuitOpen {
+		b.openedAt = now
+	}
+
+	b.state = s
+	b.generation++
+	b.probes = 0
+	b.probeSuccesses = 0
+	b.consecutiveFailures = 0
+	b.windowStart = now
+	b.requests = 0
+	b.failures = 0
+}
+
+func (b *CircuitBreaker) openDuration() time.Duration {
+	if b.policy.OpenDuration > 0 {
+		return time.Duration(b.policy.OpenDuration)
+	}
+
+	return defaultCircuitBreakerOpenDuration
+}
+
+func (b *CircuitBreaker) window() time.Duration {
+	if b.policy.Window > 0 {
+		return time.Duration(b.policy.Window)
+	}
+
+	return defaultCircuitBreakerWindow
+}
+
+func (b *CircuitBreaker) minRequests() int {
+	if b.policy.MinRequests > 0 {
+		return b.policy.MinRequests
+	}
+
+	return defaultCircuitBreakerMinRequests
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.policy.HalfOpenRequests > 0 {
+		return b.policy.HalfOpenRequests
+	}
+
+	return 1
+}
+
+// CircuitBreakerStatuses returns the status of the circuit breakers ordered by
+// the URI of the upstream
+func CircuitBreakerStatuses(breakers map[string]*CircuitBreaker) []CircuitBreakerStatus {
+	statuses := []CircuitBreakerStatus{}
+	for _, b := range breakers {
+		statuses = append(statuses, b.Status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].URI < statuses[j].URI
+	})
+
+	return statuses
+}
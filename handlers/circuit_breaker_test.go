@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func setupCircuitBreaker(p config.CircuitBreakerPolicy) (*CircuitBreaker, *testClock) {
+	c := &testClock{now: time.Now()}
+
+	cb := NewCircuitBreaker("http://api:9090", p, logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil))
+	cb.now = c.Now
+
+	return cb, c
+}
+
+func call(t *testing.T, cb *CircuitBreaker, success bool) {
+	done, err := cb.Allow()
+	require.NoError(t, err)
+
+	if success {
+		done(CallSucceeded)
+	} else {
+		done(CallFailed)
+	}
+}
+
+func TestCircuitBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	cb, _ := setupCircuitBreaker(config.CircuitBreakerPolicy{ConsecutiveFailures: 2})
+
+	call(t, cb, false)
+	call(t, cb, true)
+	call(t, cb, false)
+	assert.Equal(t, CircuitClosed, cb.Status().State)
+
+	call(t, cb, false)
+	assert.Equal(t, CircuitOpen, cb.Status().State)
+
+	_, err := cb.Allow()
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+}
+
+func TestCircuitBreakerOpensWhenErrorRateExceeded(t *testing.T) {
+	cb, _ := setupCircuitBreaker(config.CircuitBreakerPolicy{ErrorRate: 0.5, MinRequests: 4})
+
+	call(t, cb, false)
+	call(t, cb, true)
+	call(t, cb, false)
+	assert.Equal(t, CircuitClosed, cb.Status().State)
+
+	call(t, cb, true)
+	assert.Equal(t, CircuitOpen, cb.Status().State)
+}
+
+func TestCircuitBreakerResetsErrorRateAfterWindow(t *testing.T) {
+	cb, c := setupCircuitBreaker(config.CircuitBreakerPolicy{
+		ErrorRate:   0.5,
+		MinRequests: 2,
+		Window:      config.Duration(time.Second),
+	})
+
+	call(t, cb, false)
+	c.now = c.now.Add(2 * time.Second)
+	call(t, cb, true)
+	call(t, cb, true)
+
+	assert.Equal(t, CircuitClosed, cb.Status().State)
+	assert.Equal(t, 2, cb.Status().Requests)
+	assert.Equal(t, 0, cb.Status().Failures)
+}
+
+func TestCircuitBreakerClosesAfterSuccessfulProbes(t *testing.T) {
+	cb, c := setupCircuitBreaker(config.CircuitBreakerPolicy{
+		ConsecutiveFailures: 1,
+		OpenDuration:        config.Duration(time.Second),
+		HalfOpenRequests:    2,
+	})
+
+	call(t, cb, false)
+	c.now = c.now.Add(time.Second)
+
+	// only the probe requests are allowed while half open
+	done1, err := cb.Allow()
+	require.NoError(t, err)
+	done2, err := cb.Allow()
+	require.NoError(t, err)
+	_, err = cb.Allow()
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	assert.Equal(t, CircuitHalfOpen, cb.Status().State)
+
+	done1(CallSucceeded)
+	done2(CallSucceeded)
+	assert.Equal(t, CircuitClosed, cb.Status().State)
+}
+
+func TestCircuitBreakerReopensWhenProbeFails(t *testing.T) {
+	cb, c := setupCircuitBreaker(config.CircuitBreakerPolicy{
+		ConsecutiveFailures: 1,
+		OpenDuration:        config.Duration(time.Second),
+	})
+
+	call(t, cb, false)
+	c.now = c.now.Add(time.Second)
+	call(t, cb, false)
+
+	assert.Equal(t, CircuitOpen, cb.Status().State)
+}
+
+func TestCircuitBreakerIgnoresCancelledProbe(t *testing.T) {
+	cb, c := setupCircuitBreaker(config.CircuitBreakerPolicy{
+		ConsecutiveFailures: 1,
+		OpenDuration:        config.Duration(time.Second),
+	})
+
+	call(t, cb, false)
+	c.now = c.now.Add(time.Second)
+
+	done, err := cb.Allow()
+	require.NoError(t, err)
+	done(CallCancelled)
+
+	// the cancelled probe does not close the circuit breaker but another
+	// probe is allowed
+	assert.Equal(t, CircuitHalfOpen, cb.Status().State)
+
+	call(t, cb, true)
+	assert.Equal(t, CircuitClosed, cb.Status().State)
+}
+
+func TestCircuitBreakerIgnoresCancelledCalls(t *testing.T) {
+	cb, _ := setupCircuitBreaker(config.CircuitBreakerPolicy{ErrorRate: 0.5, MinRequests: 2})
+
+	call(t, cb, false)
+
+	done, err := cb.Allow()
+	require.NoError(t, err)
+	done(CallCancelled)
+
+	assert.Equal(t, 1, cb.Status().Requests)
+}
+
+func TestNilCircuitBreakerAllowsCalls(t *testing.T) {
+	var cb *CircuitBreaker
+
+	done, err := cb.Allow()
+	require.NoError(t, err)
+
+	done(CallFailed)
+}
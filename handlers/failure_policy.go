@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// Failure policies which decide if failed upstream calls fail the request
+const (
+	// FailOnAny fails the request when any upstream call fails
+	FailOnAny = "any"
+	// FailOnRequired fails the request when a call to an upstream which is
+	// not optional fails
+	FailOnRequired = "required"
+	// FailOnQuorum fails the request when fewer than the quorum of upstream
+	// calls succeed
+	FailOnQuorum = "quorum"
+)
+
+// FailurePolicy decides if failed upstream calls fail the request
+type FailurePolicy struct {
+	Type string
+	// Quorum is the number of calls which must succeed when Type is quorum
+	Quorum int
+	// DegradedCode is returned when calls failed but the policy allowed the
+	// request to succeed
+	DegradedCode int
+}
+
+// upstreamResults counts the results of the upstream calls for a request
+type upstreamResults struct {
+	mutex     sync.Mutex
+	policy    FailurePolicy
+	succeeded int
+	failed    int
+}
+
+// record the result of a call, the error is returned when the policy does not
+// allow the call to fail
+func (u *upstreamResults) record(optional bool, r *response.Response, err error) error {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	r.Optional = optional
+
+	if err == nil {
+		u.succeeded++
+		return nil
+	}
+
+	u.failed++
+
+	switch u.policy.Type {
+	case FailOnRequired:
+		if optional {
+			return nil
+		}
+	case FailOnQuorum:
+		return nil
+	}
+
+	return err
+}
+
+// check returns an error when the policy requires more calls to succeed
+func (u *upstreamResults) check() error {
+	if u.policy.Type == FailOnQuorum && u.succeeded < u.policy.Quorum {
+		return fmt.Errorf("%d upstream calls succeeded, expected a quorum of %d", u.succeeded, u.policy.Quorum)
+	}
+
+	return nil
+}
+
+// degraded returns true when calls failed but the request can succeed
+func (u *upstreamResults) degraded() bool {
+	return u.failed > 0
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/nicholasjackson/fake-service/logging"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// HealthGRPC implements the gRPC health checking protocol using the same
+// state as the HTTP health and ready handlers. Services are NOT_SERVING
+// until the readiness delay has elapsed and while the health check response
+// code is not 200, otherwise the status set for the service is returned.
+type HealthGRPC struct {
+	grpc_health_v1.UnimplementedHealthServer
+	logger *logging.Logger
+	health *Health
+	ready  *Ready
+	// services are the names of the registered gRPC services, the empty
+	// name is the server as a whole
+	services map[string]bool
+}
+
+// NewHealthGRPC creates a new gRPC health service for the registered
+// services
+func NewHealthGRPC(logger *logging.Logger, health *Health, ready *Ready, services []string) *HealthGRPC {
+	h := &HealthGRPC{
+		logger:   logger,
+		health:   health,
+		ready:    ready,
+		services: map[string]bool{"": true},
+	}
+
+	for _, s := range services {
+		h.services[s] = true
+	}
+
+	return h
+}
+
+// Check returns the status of the service
+func (h *HealthGRPC) Check(ctx context.Context, in *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	hq := h.logger.CallHealthGRPC(in.Service)
+	defer hq.Finished()
+
+	st, _ := h.status(in.Service)
+	hq.SetMetadata("response", st.String())
+
+	if st == grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", in.Service)
+	}
+
+	return &grpc_health_v1.HealthCheckResponse{Status: st}, nil
+}
+
+// Watch sends the status of the service and then sends the new status each
+// time it changes
+func (h *HealthGRPC) Watch(in *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	h.logger.Log().Info("Watching gRPC health", "service", in.Service)
+
+	// ready is set to nil once the delay has elapsed so that the closed
+	// channel is not selected again
+	ready := h.ready.Done()
+	last := grpc_health_v1.HealthCheckResponse_ServingStatus(-1)
+
+	for {
+		st, changed := h.status(in.Service)
+		if st != last {
+			err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: st})
+			if err != nil {
+				return err
+			}
+
+			last = st
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-changed:
+		case <-ready:
+			ready = nil
+		}
+	}
+}
+
+// status returns the status of the service and a channel which is closed
+// when the status may have changed
+func (h *HealthGRPC) status(service string) (grpc_health_v1.HealthCheckResponse_ServingStatus, <-chan struct{}) {
+	code, services, changed := h.health.state()
+
+	st, ok := services[service]
+	if !ok {
+		if !h.services[service] {
+			return grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, changed
+		}
+
+		st = grpc_health_v1.HealthCheckResponse_SERVING
+	}
+
+	if code != http.StatusOK || !h.ready.Complete() {
+		return grpc_health_v1.HealthCheckResponse_NOT_SERVING, changed
+	}
+
+	return st, changed
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func setupHealthGRPC(t *testing.T, delay time.Duration) (grpc_health_v1.HealthClient, *Health) {
+	h := setupHealth(t, http.StatusOK)
+	r := setupReady(t, http.StatusOK, http.StatusServiceUnavailable, delay)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(s, NewHealthGRPC(h.logger, h, r, []string{"FakeService"}))
+
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return grpc_health_v1.NewHealthClient(conn), h
+}
+
+func checkStatus(t *testing.T, c grpc_health_v1.HealthClient, service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	resp, err := c.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+	require.NoError(t, err)
+
+	return resp.Status
+}
+
+func TestHealthGRPCReturnsServingForRegisteredServices(t *testing.T) {
+	c, _ := setupHealthGRPC(t, 0)
+
+	assert.Eventually(t, func() bool {
+		return checkStatus(t, c, "") == grpc_health_v1.HealthCheckResponse_SERVING
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, checkStatus(t, c, "FakeService"))
+}
+
+func TestHealthGRPCReturnsNotFoundForUnknownService(t *testing.T) {
+	c, _ := setupHealthGRPC(t, 0)
+
+	_, err := c.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "Payments"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestHealthGRPCReturnsNotServingBeforeReady(t *testing.T) {
+	c, _ := setupHealthGRPC(t, time.Minute)
+
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, checkStatus(t, c, "FakeService"))
+}
+
+func TestHealthGRPCReturnsNotServingWhenHealthCheckFails(t *testing.T) {
+	c, h := setupHealthGRPC(t, 0)
+	h.SetStatusCode(http.StatusInternalServerError)
+
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, checkStatus(t, c, ""))
+}
+
+func TestHealthGRPCReturnsServiceStatus(t *testing.T) {
+	c, h := setupHealthGRPC(t, 0)
+	h.SetGRPCServices(map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{
+		"FakeService": grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+		"Payments":    grpc_health_v1.HealthCheckResponse_SERVING,
+	})
+
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, checkStatus(t, c, "FakeService"))
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, checkStatus(t, c, "Payments"))
+}
+
+func TestHealthGRPCWatchSendsChanges(t *testing.T) {
+	c, h := setupHealthGRPC(t, 300*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	w, err := c.Watch(ctx, &grpc_health_v1.HealthCheckRequest{Service: "FakeService"})
+	require.NoError(t, err)
+
+	expected := []grpc_health_v1.HealthCheckResponse_ServingStatus{
+		grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+		grpc_health_v1.HealthCheckResponse_SERVING,
+		grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+	}
+
+	for i, e := range expected {
+		resp, err := w.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, e, resp.Status)
+
+		// once the service is ready change the status
+		if i == 1 {
+			h.SetGRPCServices(map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{
+				"FakeService": grpc_health_v1.HealthCheckResponse_NOT_SERVING,
+			})
+		}
+	}
+}
+
+func TestHealthGRPCWatchReturnsServiceUnknown(t *testing.T) {
+	c, _ := setupHealthGRPC(t, 0)
+
+	w, err := c.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "Payments"})
+	require.NoError(t, err)
+
+	resp, err := w.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVICE_UNKNOWN, resp.Status)
+}
@@ -3,21 +3,31 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"reflect"
+	"sync"
 
 	"github.com/nicholasjackson/fake-service/logging"
+	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
 // Health defines the health handler for the service
 type Health struct {
 	logger     *logging.Logger
 	statusCode int
+	// grpcServices are the statuses set for gRPC services
+	grpcServices map[string]grpc_health_v1.HealthCheckResponse_ServingStatus
+	// changed is closed and replaced when the health changes
+	changed chan struct{}
+	mutex   sync.RWMutex
 }
 
 // NewHealth creates a new health handler
 func NewHealth(logger *logging.Logger, code int) *Health {
 	return &Health{
-		logger,
-		code,
+		logger:       logger,
+		statusCode:   code,
+		grpcServices: map[string]grpc_health_v1.HealthCheckResponse_ServingStatus{},
+		changed:      make(chan struct{}),
 	}
 }
 
@@ -26,12 +36,51 @@ func (h *Health) Handle(rw http.ResponseWriter, r *http.Request) {
 	hq := h.logger.CallHealthHTTP()
 	defer hq.Finished()
 
-	hq.SetMetadata("response", fmt.Sprintf("%d", h.statusCode))
+	h.mutex.RLock()
+	code := h.statusCode
+	h.mutex.RUnlock()
 
-	rw.WriteHeader(h.statusCode)
+	hq.SetMetadata("response", fmt.Sprintf("%d", code))
+
+	rw.WriteHeader(code)
 	fmt.Fprint(rw, "OK")
 }
 
+// SetStatusCode sets the status code returned by the handler
 func (h *Health) SetStatusCode(code int) {
-	h.statusCode = code
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.statusCode != code {
+		h.statusCode = code
+		h.notify()
+	}
+}
+
+// SetGRPCServices sets the status returned by the gRPC health service for
+// each service, services which are not set use the status of the server
+func (h *Health) SetGRPCServices(s map[string]grpc_health_v1.HealthCheckResponse_ServingStatus) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !reflect.DeepEqual(h.grpcServices, s) {
+		h.grpcServices = s
+		h.notify()
+	}
+}
+
+// state returns the status code, the gRPC service statuses and a channel
+// which is closed when either changes
+func (h *Health) state() (int, map[string]grpc_health_v1.HealthCheckResponse_ServingStatus, <-chan struct{}) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return h.statusCode, h.grpcServices, h.changed
+}
+
+// notify the watchers that the health has changed, the caller must hold the
+// mutex
+func (h *Health) notify() {
+	close(h.changed)
+	h.changed = make(chan struct{})
 }
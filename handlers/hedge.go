@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	opentracing "github.com/opentracing/opentracing-go"
+)
+
+const (
+	// hedgeSamples is the number of call durations kept to calculate the
+	// percentile
+	hedgeSamples = 100
+	// hedgeMinSamples is the number of calls which must be observed before
+	// the percentile is used as the delay
+	hedgeMinSamples = 10
+)
+
+// HedgePolicy defines when a duplicate call is sent to a slow upstream
+type HedgePolicy struct {
+	Delay time.Duration
+	// Percentile of the observed call duration to use as the delay, when 0
+	// Delay is always used
+	Percentile float64
+
+	mutex     sync.Mutex
+	durations []time.Duration
+	next      int
+}
+
+// hedgeCall is the result of one of the calls made by callWithHedging
+type hedgeCall struct {
+	hedge     bool
+	startTime time.Time
+	endTime   time.Time
+	resp      *response.Response
+	err       error
+}
+
+// NewHedgePolicy creates a hedge policy from the definition
+func NewHedgePolicy(c config.HedgePolicy) *HedgePolicy {
+	return &HedgePolicy{
+		Delay:      time.Duration(c.Delay),
+		Percentile: c.Percentile,
+	}
+}
+
+// delay returns the time to wait for a response before the duplicate call
+// is sent
+func (h *HedgePolicy) delay() time.Duration {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.Percentile == 0 || len(h.durations) < hedgeMinSamples {
+		return h.Delay
+	}
+
+	sorted := append([]time.Duration{}, h.durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	i := int(math.Ceil(h.Percentile/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	return sorted[i]
+}
+
+// observe records the time taken to receive a response, only the most
+// recent durations are kept
+func (h *HedgePolicy) observe(d time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if len(h.durations) < hedgeSamples {
+		h.durations = append(h.durations, d)
+		return
+	}
+
+	h.durations[h.next] = d
+	h.next = (h.next + 1) % hedgeSamples
+}
+
+// attempt returns the record of the call for the upstream response
+func (c hedgeCall) attempt(cancelled bool) response.Attempt {
+	a := response.Attempt{
+		Attempt:   1,
+		StartTime: c.startTime.Format(timeFormat),
+		EndTime:   c.endTime.Format(timeFormat),
+		Duration:  c.endTime.Sub(c.startTime).String(),
+		Hedge:     c.hedge,
+		Cancelled: cancelled,
+		Code:      c.resp.Code,
+	}
+
+	if c.err != nil {
+		a.Error = c.err.Error()
+	}
+
+	return a
+}
+
+// callWithHedging makes the call, when the upstream has a hedge policy and
+// the call has not completed after the delay a duplicate call is sent. The
+// response from the first call to succeed is used and the other call is
+// cancelled, the last error is returned when both calls fail. When the
+// duplicate call is sent both calls are added to the attempts of the response.
+func callWithHedging(ctx context.Context, sc opentracing.SpanContext, u *Upstream, l *logging.Logger, f attemptFunc) (*response.Response, error) {
+	if u.Hedge == nil {
+		return f(ctx, sc)
+	}
+
+	lp := l.CallUpstreamHedged(u.URI, sc)
+	defer lp.Finished()
+
+	calls := make(chan hedgeCall, 2)
+	cancels := []context.CancelFunc{}
+
+	start := func(hedge bool) {
+		cctx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+
+		go func() {
+			st := time.Now()
+			r, err := f(cctx, lp.Span.Context())
+			calls <- hedgeCall{hedge, st, time.Now(), r, err}
+		}()
+	}
+
+	st := time.Now()
+	start(false)
+
+	d := u.Hedge.delay()
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	// wait for a call to succeed or for every call which was sent to fail,
+	// the duplicate call is only sent once
+	completed := []hedgeCall{}
+	hedge := timer.C
+	for len(completed) < len(cancels) {
+		select {
+		case c := <-calls:
+			completed = append(completed, c)
+		case <-hedge:
+			hedge = nil
+
+			l.HedgeUpstream(lp.Span, u.URI, d)
+			start(true)
+
+			continue
+		}
+
+		if completed[len(completed)-1].err == nil {
+			break
+		}
+	}
+
+	// cancel the call which has not completed
+	for _, cancel := range cancels {
+		cancel()
+	}
+
+	u.Hedge.observe(time.Since(st))
+
+	result := completed[len(completed)-1]
+
+	if len(cancels) > 1 {
+		// the other call has either failed or is waited for once cancelled so
+		// that its span is complete
+		var other hedgeCall
+		cancelled := len(completed) == 1
+		if cancelled {
+			other = <-calls
+		} else {
+			other = completed[0]
+		}
+
+		attempts := []response.Attempt{result.attempt(false), other.attempt(cancelled)}
+
+		// the original call is always the first attempt
+		if result.hedge {
+			attempts[0], attempts[1] = attempts[1], attempts[0]
+		}
+
+		result.resp.Attempts = attempts
+		lp.SetMetadata("hedge.won", strconv.FormatBool(result.hedge))
+	}
+
+	lp.SetError(result.err)
+
+	return result.resp, result.err
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupHedge(delay time.Duration) (*Upstream, *logging.Logger, opentracing.SpanContext) {
+	u := &Upstream{
+		URI:   "http://api:9090",
+		Hedge: NewHedgePolicy(config.HedgePolicy{Delay: config.Duration(delay)}),
+	}
+
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+
+	return u, l, opentracing.StartSpan("test").Context()
+}
+
+func TestHedgeUsesFirstResponseAndCancelsSlowCall(t *testing.T) {
+	u, l, sc := setupHedge(time.Millisecond)
+	calls := int32(0)
+
+	r, err := callWithHedging(context.Background(), sc, u, l, func(ctx context.Context, sc opentracing.SpanContext) (*response.Response, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// the original call does not complete until it is cancelled
+			<-ctx.Done()
+			return &response.Response{Code: -1}, ctx.Err()
+		}
+
+		return &response.Response{Name: "hedge", Code: 200}, nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "hedge", r.Name)
+	assert.Equal(t, int32(2), calls)
+
+	require.Len(t, r.Attempts, 2)
+	assert.False(t, r.Attempts[0].Hedge)
+	assert.True(t, r.Attempts[0].Cancelled)
+	assert.True(t, r.Attempts[1].Hedge)
+	assert.False(t, r.Attempts[1].Cancelled)
+	assert.Equal(t, 200, r.Attempts[1].Code)
+}
+
+func TestHedgeIsNotSentWhenCallCompletesBeforeDelay(t *testing.T) {
+	u, l, sc := setupHedge(time.Second)
+	calls := int32(0)
+
+	r, err := callWithHedging(context.Background(), sc, u, l, func(ctx context.Context, sc opentracing.SpanContext) (*response.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return &response.Response{Code: 200}, nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), calls)
+	assert.Empty(t, r.Attempts)
+}
+
+func TestHedgeDelayUsesObservedPercentile(t *testing.T) {
+	h := NewHedgePolicy(config.HedgePolicy{Delay: config.Duration(time.Second), Percentile: 90})
+
+	for i := 1; i < hedgeMinSamples; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+
+	assert.Equal(t, time.Second, h.delay())
+
+	h.observe(10 * time.Millisecond)
+	assert.Equal(t, 9*time.Millisecond, h.delay())
+}
+
+func TestHedgeKeepsMostRecentDurations(t *testing.T) {
+	h := NewHedgePolicy(config.HedgePolicy{Delay: config.Duration(time.Second), Percentile: 50})
+
+	for i := 0; i < hedgeSamples*2; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+
+	assert.Len(t, h.durations, hedgeSamples)
+	assert.Equal(t, 149*time.Millisecond, h.delay())
+}
+
+func TestHedgeUsesSuccessfulResponseWhenFirstResponseFails(t *testing.T) {
+	u, l, sc := setupHedge(time.Millisecond)
+	calls := int32(0)
+	hedged := make(chan struct{})
+
+	r, err := callWithHedging(context.Background(), sc, u, l, func(ctx context.Context, sc opentracing.SpanContext) (*response.Response, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// the original call fails as soon as the hedge has been sent
+			<-hedged
+			return &response.Response{Code: 500}, fmt.Errorf("boom")
+		}
+
+		close(hedged)
+		time.Sleep(20 * time.Millisecond)
+
+		return &response.Response{Name: "hedge", Code: 200}, nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, "hedge", r.Name)
+
+	require.Len(t, r.Attempts, 2)
+	assert.False(t, r.Attempts[0].Hedge)
+	assert.False(t, r.Attempts[0].Cancelled)
+	assert.Equal(t, "boom", r.Attempts[0].Error)
+	assert.True(t, r.Attempts[1].Hedge)
+	assert.Equal(t, 200, r.Attempts[1].Code)
+}
+
+func TestHedgeReturnsLastErrorWhenBothCallsFail(t *testing.T) {
+	u, l, sc := setupHedge(time.Millisecond)
+	calls := int32(0)
+
+	_, err := callWithHedging(context.Background(), sc, u, l, func(ctx context.Context, sc opentracing.SpanContext) (*response.Response, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(10 * time.Millisecond)
+			return &response.Response{Code: 500}, fmt.Errorf("original")
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		return &response.Response{Code: 503}, fmt.Errorf("hedge")
+	})
+
+	assert.EqualError(t, err, "hedge")
+	assert.Equal(t, int32(2), calls)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/errors"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/timing"
+)
+
+// Raw modes which decide how the TCP and UDP listeners respond
+const (
+	// RawEcho sends the data received back to the client
+	RawEcho = "echo"
+	// RawPayload responds to the data received with the payload
+	RawPayload = "payload"
+	// RawUpstream forwards the data received to the upstreams and sends their
+	// responses to the client
+	RawUpstream = "upstream"
+)
+
+// defaultUDPTimeout is the time to wait for a UDP upstream to respond when no
+// timeout is set, upstreams may never respond to a datagram
+const defaultUDPTimeout = 10 * time.Second
+
+// RawSettings define the behaviour of the TCP and UDP listeners
+type RawSettings struct {
+	Mode string
+	// Payload is sent in response to the data received in payload mode
+	Payload []byte
+	// UpstreamURIs are the tcp:// and udp:// URIs data is forwarded to in
+	// upstream mode, each listener only uses the upstreams for its network
+	UpstreamURIs []string
+	// Timeout is the time to wait when connecting to a TCP upstream or for a
+	// UDP upstream to respond. When 0 TCP connections wait for the operating
+	// system timeout and UDP upstreams use defaultUDPTimeout
+	Timeout time.Duration
+}
+
+// udpTimeout returns the time to wait for a UDP upstream to respond
+func (s RawSettings) udpTimeout() time.Duration {
+	if s.Timeout <= 0 {
+		return defaultUDPTimeout
+	}
+
+	return s.Timeout
+}
+
+// upstreams returns the addresses of the upstreams for the network
+func (s RawSettings) upstreams(network string) []string {
+	addrs := []string{}
+	for _, u := range s.UpstreamURIs {
+		if a := strings.TrimPrefix(u, network+"://"); a != u {
+			addrs = append(addrs, a)
+		}
+	}
+
+	return addrs
+}
+
+// rawHandler contains the settings shared by the TCP and UDP handlers
+type rawHandler struct {
+	// mutex guards the settings which can be replaced using Update
+	mutex         sync.RWMutex
+	network       string
+	duration      *timing.RequestDuration
+	errorInjector *errors.Injector
+	raw           RawSettings
+	log           *logging.Logger
+}
+
+// Update replaces the settings for the handler, connections which are open
+// keep using the previous settings
+func (h *rawHandler) Update(s Settings) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.duration = s.Duration
+	h.errorInjector = s.ErrorInjector
+	h.raw = s.Raw
+}
+
+// settings returns a copy of the current settings
+func (h *rawHandler) settings() (*timing.RequestDuration, *errors.Injector, RawSettings) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	return h.duration, h.errorInjector, h.raw
+}
+
+// injectError returns the error when the injector fails the connection or
+// datagram
+func (h *rawHandler) injectError(ei *errors.Injector) error {
+	er := ei.Do()
+	if er == nil {
+		return nil
+	}
+
+	if er.Error == errors.ErrorRateLimit {
+		h.log.RequestRateLimited(h.network)
+	} else {
+		h.log.RequestErrorInjected(h.network, er.Code)
+	}
+
+	return er.Error
+}
+
+// wait sleeps for the remainder of the randomised duration since the
+// connection or datagram was received at st
+func (h *rawHandler) wait(hq *logging.LogProcess, d *timing.RequestDuration, st time.Time) {
+	rd := d.Calculate() - time.Since(st)
+	if rd <= 0 {
+		return
+	}
+
+	lp := h.log.SleepService(hq.Span, rd)
+	time.Sleep(rd)
+	lp.Finished()
+}
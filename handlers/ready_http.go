@@ -22,6 +22,8 @@ type Ready struct {
 	delay         time.Duration
 	mutex         sync.Mutex
 	complete      bool
+	// done is closed when the delay has elapsed
+	done chan struct{}
 }
 
 // NewReady creates a new ready handler
@@ -32,6 +34,7 @@ func NewReady(logger *logging.Logger, successCode, failureCode int, delay time.D
 		statusMessage: StartingMessage,
 		delay:         delay,
 		mutex:         sync.Mutex{},
+		done:          make(chan struct{}),
 	}
 
 	// set the status code to unavailable until the delay has passed
@@ -41,6 +44,7 @@ func NewReady(logger *logging.Logger, successCode, failureCode int, delay time.D
 		r.statusCode = successCode
 		r.statusMessage = OKMessage
 		r.complete = true
+		close(r.done)
 	})
 
 	return r
@@ -66,3 +70,9 @@ func (h *Ready) Complete() bool {
 
 	return h.complete
 }
+
+// Done returns a channel which is closed when the readiness handler delay
+// elapses
+func (h *Ready) Done() <-chan struct{} {
+	return h.done
+}
@@ -2,20 +2,18 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/config"
 	"github.com/nicholasjackson/fake-service/errors"
 	"github.com/nicholasjackson/fake-service/grpc/api"
 	"github.com/nicholasjackson/fake-service/load"
 	"github.com/nicholasjackson/fake-service/logging"
 	"github.com/nicholasjackson/fake-service/response"
 	"github.com/nicholasjackson/fake-service/timing"
-	"github.com/nicholasjackson/fake-service/worker"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -23,10 +21,16 @@ import (
 // FakeServer implements the gRPC interface
 type FakeServer struct {
 	api.UnimplementedFakeServiceServer
+	// mutex guards the settings which can be replaced using Update
+	mutex            sync.RWMutex
 	name             string
 	message          string
+	bodyTemplates    BodyTemplates
 	duration         *timing.RequestDuration
 	upstreamURIs     []string
+	upstreams        map[string]*Upstream
+	plan             []config.Stage
+	failurePolicy    FailurePolicy
 	workerCount      int
 	defaultClient    client.HTTP
 	grpcClients      map[string]client.GRPC
@@ -34,6 +38,7 @@ type FakeServer struct {
 	loadGenerator    *load.Generator
 	log              *logging.Logger
 	requestGenerator load.RequestGenerator
+	stream           StreamSettings
 	waitTillReady    bool
 	readinessHandler *Ready
 }
@@ -43,6 +48,9 @@ func NewFakeServer(
 	name, message string,
 	duration *timing.RequestDuration,
 	upstreamURIs []string,
+	upstreams map[string]*Upstream,
+	plan []config.Stage,
+	failurePolicy FailurePolicy,
 	workerCount int,
 	defaultClient client.HTTP,
 	grpcClients map[string]client.GRPC,
@@ -50,6 +58,7 @@ func NewFakeServer(
 	loadGenerator *load.Generator,
 	l *logging.Logger,
 	requestGenerator load.RequestGenerator,
+	stream StreamSettings,
 	waitTillReady bool,
 	readinessHandler *Ready,
 ) *FakeServer {
@@ -60,6 +69,9 @@ func NewFakeServer(
 		message:                        message,
 		duration:                       duration,
 		upstreamURIs:                   upstreamURIs,
+		upstreams:                      upstreams,
+		plan:                           plan,
+		failurePolicy:                  failurePolicy,
 		workerCount:                    workerCount,
 		defaultClient:                  defaultClient,
 		grpcClients:                    grpcClients,
@@ -67,11 +79,57 @@ func NewFakeServer(
 		loadGenerator:                  loadGenerator,
 		log:                            l,
 		requestGenerator:               requestGenerator,
+		stream:                         stream,
 		waitTillReady:                  waitTillReady,
 		readinessHandler:               readinessHandler,
 	}
 }
 
+// Update replaces the settings for the server, requests which are in
+// progress complete using the previous settings
+func (f *FakeServer) Update(s Settings) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.message = s.Message
+	f.bodyTemplates = s.BodyTemplates
+	f.duration = s.Duration
+	f.upstreamURIs = s.UpstreamURIs
+	f.upstreams = s.Upstreams
+	f.plan = s.Plan
+	f.failurePolicy = s.FailurePolicy
+	f.workerCount = s.WorkerCount
+	f.defaultClient = s.DefaultClient
+	f.grpcClients = s.GRPCClients
+	f.errorInjector = s.ErrorInjector
+	f.loadGenerator = s.LoadGenerator
+	f.requestGenerator = s.RequestGenerator
+	f.stream = s.Stream
+}
+
+// settings returns a copy of the current settings
+func (f *FakeServer) settings() Settings {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+
+	return Settings{
+		Message:          f.message,
+		BodyTemplates:    f.bodyTemplates,
+		Duration:         f.duration,
+		UpstreamURIs:     f.upstreamURIs,
+		Upstreams:        f.upstreams,
+		Plan:             f.plan,
+		FailurePolicy:    f.failurePolicy,
+		WorkerCount:      f.workerCount,
+		DefaultClient:    f.defaultClient,
+		GRPCClients:      f.grpcClients,
+		ErrorInjector:    f.errorInjector,
+		LoadGenerator:    f.loadGenerator,
+		RequestGenerator: f.requestGenerator,
+		Stream:           f.stream,
+	}
+}
+
 // Handle implements the FakeServer Handle interface method
 func (f *FakeServer) Handle(ctx context.Context, in *api.Request) (*api.Response, error) {
 	if f.waitTillReady && !f.readinessHandler.Complete() {
@@ -79,9 +137,13 @@ func (f *FakeServer) Handle(ctx context.Context, in *api.Request) (*api.Response
 		return nil, status.Error(codes.Unavailable, "Server Unavailable")
 	}
 
+	// take a copy of the settings so that the request is not affected
+	// if they change while it is being handled
+	s := f.settings()
+
 	// start timing the service this is used later for the total request time
 	ts := time.Now()
-	finished := f.loadGenerator.Generate()
+	finished := s.LoadGenerator.Generate()
 	defer finished()
 
 	hq := f.log.HandleGRCPRequest(ctx)
@@ -91,12 +153,19 @@ func (f *FakeServer) Handle(ctx context.Context, in *api.Request) (*api.Response
 	resp.Name = f.name
 	resp.Type = "gRPC"
 	resp.IPAddresses = getIPInfo()
+	resp.Peer = hq.Peer
 
 	// are we injecting errors, if so return the error
-	if er := f.errorInjector.Do(); er != nil {
+	if er := s.ErrorInjector.Do(); er != nil {
 		resp.Code = er.Code
 		resp.Error = er.Error.Error()
 
+		if er.Error == errors.ErrorRateLimit {
+			f.log.RequestRateLimited("grpc")
+		} else {
+			f.log.RequestErrorInjected("grpc", er.Code)
+		}
+
 		hq.SetError(er.Error)
 		hq.SetMetadata("response", strconv.Itoa(er.Code))
 
@@ -110,25 +179,24 @@ func (f *FakeServer) Handle(ctx context.Context, in *api.Request) (*api.Response
 
 	// if we need to create upstream requests create a worker pool
 	var upstreamError error
-	if len(f.upstreamURIs) > 0 {
-		data := f.requestGenerator.Generate()
-		wp := worker.New(f.workerCount, func(uri string) (*response.Response, error) {
-			if strings.HasPrefix(uri, "http://") {
-				return workerHTTP(hq.Span.Context(), uri, f.defaultClient, nil, f.log, data)
+	if len(s.UpstreamURIs) > 0 || len(s.Plan) > 0 {
+		generated := s.RequestGenerator.Generate()
+		data := newGRPCRequestData(ctx)
+
+		err := s.callUpstreams(resp, s.UpstreamURIs, s.Plan, func(uri string) (*response.Response, error) {
+			u := s.upstream(uri)
+
+			body, err := u.Body(data, generated)
+			if err != nil {
+				return &response.Response{URI: uri, Error: err.Error()}, err
 			}
 
-			return workerGRPC(hq.Span.Context(), uri, f.grpcClients, f.log, data)
+			return callUpstream(ctx, hq.Span.Context(), u, nil, s.GRPCClients, f.log, body)
 		})
 
-		err := wp.Do(f.upstreamURIs)
-
 		if err != nil {
 			upstreamError = err
 		}
-
-		for _, v := range wp.Responses() {
-			resp.AppendUpstream(v.URI, *v.Response)
-		}
 	}
 
 	if upstreamError != nil {
@@ -146,7 +214,7 @@ func (f *FakeServer) Handle(ctx context.Context, in *api.Request) (*api.Response
 	}
 
 	// service time is equal to the randomised time - the current time take
-	d := f.duration.Calculate()
+	d := s.Duration.Calculate()
 	et := time.Now().Sub(ts)
 	rd := d - et
 	if rd > 0 {
@@ -156,6 +224,10 @@ func (f *FakeServer) Handle(ctx context.Context, in *api.Request) (*api.Response
 		lp.Finished()
 	}
 
+	if resp.Degraded {
+		hq.SetMetadata("degraded", "true")
+	}
+
 	// log response code
 	hq.SetMetadata("response", "0")
 
@@ -165,14 +237,24 @@ func (f *FakeServer) Handle(ctx context.Context, in *api.Request) (*api.Response
 	resp.EndTime = te.Format(timeFormat)
 	resp.Duration = te.Sub(ts).String()
 
-	// add the response body if there is no upstream error
-	if upstreamError == nil {
-		if strings.HasPrefix(f.message, "{") {
-			resp.Body = json.RawMessage(f.message)
-		} else {
-			resp.Body = json.RawMessage(fmt.Sprintf(`"%s"`, f.message))
-		}
+	// add the response body, the request fails when the body template can
+	// not be rendered
+	body, err := s.BodyTemplates.render(s.Message, newResponseData(newGRPCRequestData(ctx), resp))
+	if err != nil {
+		resp.Code = int(codes.Internal)
+		resp.Error = err.Error()
+
+		hq.SetMetadata("response", strconv.Itoa(resp.Code))
+		hq.SetError(err)
+
+		// encode the response into the gRPC error message
+		s := status.New(codes.Code(resp.Code), err.Error())
+		s, _ = s.WithDetails(&api.Response{Message: resp.ToJSON()})
+
+		return nil, s.Err()
 	}
 
+	resp.Body = body
+
 	return &api.Response{Message: resp.ToJSON()}, nil
 }
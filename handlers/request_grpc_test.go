@@ -19,7 +19,9 @@ import (
 	"github.com/nicholasjackson/fake-service/timing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -47,7 +49,7 @@ func setupFakeServer(t *testing.T, uris []string, errorRate float64) (*FakeServe
 	i := errors.NewInjector(l.Log(), errorRate, int(codes.Internal), "http_error", 0, 0, 0)
 	lg := load.NewGenerator(0, 0, 0, 0, hclog.Default())
 
-	return NewFakeServer("test", "hello world", d, uris, 1, c, grpcClients, i, lg, l, load.NoopRequestGenerator, false, rh), c, grpcClients
+	return NewFakeServer("test", "hello world", d, uris, nil, nil, FailurePolicy{}, 1, c, grpcClients, i, lg, l, load.NoopRequestGenerator, StreamSettings{}, false, rh), c, grpcClients
 }
 
 func TestGRPCWaitsUntilReadinessCompletes(t *testing.T) {
@@ -185,3 +187,31 @@ func TestGRPCServiceHandlesRequestWithGRPCUpstream(t *testing.T) {
 	assert.Equal(t, "grpc://test.com", mr.UpstreamCalls["grpc://test.com"].URI)
 	assert.Equal(t, "abc", mr.UpstreamCalls["grpc://test.com"].Headers["test"])
 }
+
+func TestGRPCServiceRendersBodyTemplate(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	fs.message = `{"user": "{{ .Headers.Get "x-user" }}"}`
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-user", "nic"))
+
+	resp, err := fs.Handle(ctx, nil)
+	assert.NoError(t, err)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(resp.Message))
+
+	assert.JSONEq(t, `{"user": "nic"}`, string(mr.Body))
+}
+
+func TestGRPCServiceFailsWhenBodyIsNotValidJSON(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	fs.message = `{"user": "{{ .Headers.Get "x-user" }}"}`
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-user", `a"b`))
+
+	_, err := fs.Handle(ctx, nil)
+	require.Error(t, err)
+
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Contains(t, err.Error(), "not valid JSON")
+}
@@ -1,20 +1,19 @@
 package handlers
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/config"
 	"github.com/nicholasjackson/fake-service/errors"
 	"github.com/nicholasjackson/fake-service/load"
 	"github.com/nicholasjackson/fake-service/logging"
 	"github.com/nicholasjackson/fake-service/response"
+	"github.com/nicholasjackson/fake-service/routes"
 	"github.com/nicholasjackson/fake-service/timing"
-	"github.com/nicholasjackson/fake-service/worker"
 )
 
 // done is a message sent when an upstream worker has completed
@@ -25,12 +24,18 @@ type done struct {
 
 // Request handles inbound requests and makes any necessary upstream calls
 type Request struct {
+	// mutex guards the settings which can be replaced using Update
+	mutex sync.RWMutex
 	// name of the service
 	name string
 	// message to return to caller
 	message          string
+	bodyTemplates    BodyTemplates
 	duration         *timing.RequestDuration
 	upstreamURIs     []string
+	upstreams        map[string]*Upstream
+	plan             []config.Stage
+	failurePolicy    FailurePolicy
 	workerCount      int
 	defaultClient    client.HTTP
 	grpcClients      map[string]client.GRPC
@@ -40,6 +45,9 @@ type Request struct {
 	requestGenerator load.RequestGenerator
 	waitTillReady    bool
 	readinessHandler *Ready
+	// routes allow individual paths to override the message, timing, errors and upstreams
+	routes *routes.Table
+	sse    SSESettings
 }
 
 // NewRequest creates a new request handler
@@ -47,6 +55,9 @@ func NewRequest(
 	name, message string,
 	duration *timing.RequestDuration,
 	upstreamURIs []string,
+	upstreams map[string]*Upstream,
+	plan []config.Stage,
+	failurePolicy FailurePolicy,
 	workerCount int,
 	defaultClient client.HTTP,
 	grpcClients map[string]client.GRPC,
@@ -56,6 +67,8 @@ func NewRequest(
 	requestGenerator load.RequestGenerator,
 	waitTillReady bool,
 	readinessHandler *Ready,
+	routeTable *routes.Table,
+	sse SSESettings,
 ) *Request {
 
 	return &Request{
@@ -63,6 +76,9 @@ func NewRequest(
 		message:          message,
 		duration:         duration,
 		upstreamURIs:     upstreamURIs,
+		upstreams:        upstreams,
+		plan:             plan,
+		failurePolicy:    failurePolicy,
 		workerCount:      workerCount,
 		defaultClient:    defaultClient,
 		grpcClients:      grpcClients,
@@ -72,6 +88,55 @@ func NewRequest(
 		requestGenerator: requestGenerator,
 		waitTillReady:    waitTillReady,
 		readinessHandler: readinessHandler,
+		routes:           routeTable,
+		sse:              sse,
+	}
+}
+
+// Update replaces the settings for the handler, requests which are in
+// progress complete using the previous settings
+func (rq *Request) Update(s Settings) {
+	rq.mutex.Lock()
+	defer rq.mutex.Unlock()
+
+	rq.message = s.Message
+	rq.bodyTemplates = s.BodyTemplates
+	rq.duration = s.Duration
+	rq.upstreamURIs = s.UpstreamURIs
+	rq.upstreams = s.Upstreams
+	rq.plan = s.Plan
+	rq.failurePolicy = s.FailurePolicy
+	rq.workerCount = s.WorkerCount
+	rq.defaultClient = s.DefaultClient
+	rq.grpcClients = s.GRPCClients
+	rq.errorInjector = s.ErrorInjector
+	rq.loadGenerator = s.LoadGenerator
+	rq.requestGenerator = s.RequestGenerator
+	rq.routes = s.Routes
+	rq.sse = s.SSE
+}
+
+// settings returns a copy of the current settings
+func (rq *Request) settings() Settings {
+	rq.mutex.RLock()
+	defer rq.mutex.RUnlock()
+
+	return Settings{
+		Message:          rq.message,
+		BodyTemplates:    rq.bodyTemplates,
+		Duration:         rq.duration,
+		UpstreamURIs:     rq.upstreamURIs,
+		Upstreams:        rq.upstreams,
+		Plan:             rq.plan,
+		FailurePolicy:    rq.failurePolicy,
+		WorkerCount:      rq.workerCount,
+		DefaultClient:    rq.defaultClient,
+		GRPCClients:      rq.grpcClients,
+		ErrorInjector:    rq.errorInjector,
+		LoadGenerator:    rq.loadGenerator,
+		RequestGenerator: rq.requestGenerator,
+		Routes:           rq.routes,
+		SSE:              rq.sse,
 	}
 }
 
@@ -83,8 +148,12 @@ func (rq *Request) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// take a copy of the settings so that the request is not affected
+	// if they change while it is being handled
+	s := rq.settings()
+
 	// generate 100% CPU load for service
-	finished := rq.loadGenerator.Generate()
+	finished := s.LoadGenerator.Generate()
 	defer finished()
 
 	// start timing the service this is used later for the total request time
@@ -94,17 +163,48 @@ func (rq *Request) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	hq := rq.log.HandleHTTPRequest(r)
 	defer hq.Finished()
 
+	hq.SetMetadata("protocol", r.Proto)
+
 	resp := &response.Response{}
 	resp.Name = rq.name
 	resp.Type = "HTTP"
+	resp.Protocol = r.Proto
 	resp.URI = r.URL.String()
 	resp.IPAddresses = getIPInfo()
+	resp.Peer = hq.Peer
+
+	// by default use the service configuration, if the request matches a
+	// route use the configuration for the route
+	message := s.Message
+	code := http.StatusOK
+	duration := s.Duration
+	errorInjector := s.ErrorInjector
+	upstreamURIs := s.UpstreamURIs
+	plan := s.Plan
+
+	rt, params := s.Routes.Match(r)
+	if rt != nil {
+		hq.SetMetadata("route", rt.Path)
+
+		message = rt.Message
+		code = rt.Code
+		duration = rt.Duration
+		errorInjector = rt.ErrorInjector
+		upstreamURIs = rt.UpstreamURIs
+		plan = rt.Plan
+	}
 
 	// are we injecting errors, if so return the error
-	if er := rq.errorInjector.Do(); er != nil {
+	if er := errorInjector.Do(); er != nil {
 		resp.Code = er.Code
 		resp.Error = er.Error.Error()
 
+		if er.Error == errors.ErrorRateLimit {
+			rq.log.RequestRateLimited("http")
+		} else {
+			rq.log.RequestErrorInjected("http", er.Code)
+		}
+
 		// log the error response
 		hq.SetError(er.Error)
 		hq.SetMetadata("response", strconv.Itoa(er.Code))
@@ -114,27 +214,23 @@ func (rq *Request) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// if we need to create upstream requests create a worker pool
-	var upstreamError error
-	if len(rq.upstreamURIs) > 0 {
-		body := rq.requestGenerator.Generate()
-		wp := worker.New(rq.workerCount, func(uri string) (*response.Response, error) {
-			if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
-				return workerHTTP(hq.Span.Context(), uri, rq.defaultClient, r, rq.log, body)
+	// stream the response as server-sent events
+	if s.SSE.requested(r) {
+		var upstreams func(completed func(*response.Response)) error
+		if len(upstreamURIs) > 0 || len(plan) > 0 {
+			upstreams = func(completed func(*response.Response)) error {
+				return rq.callUpstreams(r, s, hq, resp, upstreamURIs, plan, params, completed)
 			}
-
-			return workerGRPC(hq.Span.Context(), uri, rq.grpcClients, rq.log, body)
-		})
-
-		err := wp.Do(rq.upstreamURIs)
-
-		if err != nil {
-			upstreamError = err
 		}
 
-		for _, v := range wp.Responses() {
-			resp.AppendUpstream(v.URI, *v.Response)
-		}
+		rq.serveEvents(rw, r, s, hq, resp, message, params, upstreams)
+		return
+	}
+
+	// if we need to create upstream requests create a worker pool
+	var upstreamError error
+	if len(upstreamURIs) > 0 || len(plan) > 0 {
+		upstreamError = rq.callUpstreams(r, s, hq, resp, upstreamURIs, plan, params, nil)
 	}
 
 	if upstreamError != nil {
@@ -146,7 +242,7 @@ func (rq *Request) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		hq.SetError(upstreamError)
 	} else {
 		// service time is equal to the randomised time - the current time take
-		d := rq.duration.Calculate()
+		d := duration.Calculate()
 		et := time.Now().Sub(ts)
 		rd := d - et
 		if rd > 0 {
@@ -156,10 +252,20 @@ func (rq *Request) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			lp.Finished()
 		}
 
-		resp.Code = http.StatusOK
+		// upstream calls failed but the failure policy allowed the request
+		// to succeed
+		if resp.Degraded {
+			hq.SetMetadata("degraded", "true")
+
+			if s.FailurePolicy.DegradedCode != 0 {
+				code = s.FailurePolicy.DegradedCode
+			}
+		}
+
+		resp.Code = code
 
 		// log response code
-		hq.SetMetadata("response", strconv.Itoa(http.StatusOK))
+		hq.SetMetadata("response", strconv.Itoa(code))
 	}
 
 	// compute total elapsed time including delay
@@ -168,12 +274,59 @@ func (rq *Request) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	resp.EndTime = te.Format(timeFormat)
 	resp.Duration = te.Sub(ts).String()
 
-	// add the response body
-	if strings.HasPrefix(rq.message, "{") {
-		resp.Body = json.RawMessage(rq.message)
-	} else {
-		resp.Body = json.RawMessage(fmt.Sprintf(`"%s"`, rq.message))
+	// add the response body, the request fails when the body template can
+	// not be rendered
+	body, err := s.BodyTemplates.render(message, newResponseData(newRequestData(r, params), resp))
+	if err != nil && upstreamError == nil {
+		resp.Code = http.StatusInternalServerError
+		resp.Error = err.Error()
+
+		hq.SetMetadata("response", strconv.Itoa(resp.Code))
+		hq.SetError(err)
+	}
+
+	resp.Body = body
+
+	// the status code for an upstream error has already been written
+	if upstreamError == nil {
+		rw.WriteHeader(resp.Code)
 	}
 
 	rw.Write([]byte(resp.ToJSON()))
 }
+
+// callUpstreams calls the upstreams for the request using a worker pool, or
+// the stages of the plan, completed is called with the response from each
+// upstream as its call completes
+func (rq *Request) callUpstreams(
+	r *http.Request,
+	s Settings,
+	hq *logging.LogProcess,
+	resp *response.Response,
+	upstreamURIs []string,
+	plan []config.Stage,
+	params map[string]string,
+	completed func(*response.Response),
+) error {
+	generated := s.RequestGenerator.Generate()
+	data := newRequestData(r, params)
+
+	return s.callUpstreams(resp, upstreamURIs, plan, func(uri string) (*response.Response, error) {
+		u := s.upstream(uri)
+
+		ur, err := func() (*response.Response, error) {
+			body, err := u.Body(data, generated)
+			if err != nil {
+				return &response.Response{URI: uri, Error: err.Error()}, err
+			}
+
+			return callUpstream(r.Context(), hq.Span.Context(), u, r, s.GRPCClients, rq.log, body)
+		}()
+
+		if completed != nil {
+			completed(ur)
+		}
+
+		return ur, err
+	})
+}
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -11,14 +13,17 @@ import (
 
 	"github.com/hashicorp/go-hclog"
 	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/config"
 	"github.com/nicholasjackson/fake-service/errors"
 	"github.com/nicholasjackson/fake-service/grpc/api"
 	"github.com/nicholasjackson/fake-service/load"
 	"github.com/nicholasjackson/fake-service/logging"
 	"github.com/nicholasjackson/fake-service/response"
+	"github.com/nicholasjackson/fake-service/routes"
 	"github.com/nicholasjackson/fake-service/timing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -131,6 +136,77 @@ func TestRequestCompletesWithNoUpstreamsJSONBody(t *testing.T) {
 	assert.Len(t, mr.UpstreamCalls, 0)
 }
 
+func TestRequestUsesMatchingRoute(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+
+	rt, err := routes.New([]routes.Definition{
+		{Method: "POST", Path: "/orders", Message: "order created", Code: http.StatusCreated},
+	}, hclog.NewNullLogger())
+	assert.NoError(t, err)
+	h.routes = rt
+
+	h.ServeHTTP(rr, r)
+	mr := response.Response{}
+	mr.FromJSON([]byte(rr.Body.String()))
+
+	c.AssertNotCalled(t, "Do", mock.Anything, mock.Anything)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, http.StatusCreated, mr.Code)
+
+	d, err := mr.Body.MarshalJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, "\"order created\"", string(d))
+	assert.Len(t, mr.UpstreamCalls, 0)
+}
+
+func TestRequestFallsBackWhenNoRouteMatches(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/orders", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+
+	rt, err := routes.New([]routes.Definition{
+		{Method: "POST", Path: "/orders", Message: "order created", Code: http.StatusCreated},
+	}, hclog.NewNullLogger())
+	assert.NoError(t, err)
+	h.routes = rt
+
+	h.ServeHTTP(rr, r)
+	mr := response.Response{}
+	mr.FromJSON([]byte(rr.Body.String()))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	d, err := mr.Body.MarshalJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, "\"hello world\"", string(d))
+}
+
+func TestRequestUsesUpdatedSettings(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+
+	s := h.settings()
+	s.Message = "updated"
+	s.UpstreamURIs = []string{}
+	h.Update(s)
+
+	r := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(rr.Body.String()))
+
+	c.AssertNotCalled(t, "Do", mock.Anything, mock.Anything)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	d, err := mr.Body.MarshalJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, "\"updated\"", string(d))
+	assert.Len(t, mr.UpstreamCalls, 0)
+}
+
 func TestRequestCompletesWithInjectedError(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader([]byte("")))
 	rr := httptest.NewRecorder()
@@ -289,3 +365,350 @@ func TestRequestCompletesWithGRPCUpstreamsError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 	assert.Equal(t, "test", mr.Name)
 }
+
+func TestRequestCallsUpstreamUsingUpstreamSettings(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://payments.com"}, 0)
+
+	u, err := NewUpstream(config.UpstreamCall{
+		URI:     "http://payments.com",
+		Method:  http.MethodPut,
+		Headers: map[string]string{"X-Source": "web"},
+		Body:    `{"order": "{{ index .Params "id" }}", "user": "{{ .Headers.Get "X-User" }}"}`,
+	}, c)
+	require.NoError(t, err)
+	h.upstreams = map[string]*Upstream{u.URI: u}
+
+	rt, err := routes.New([]routes.Definition{
+		{Path: "/orders/{id}", UpstreamURIs: []config.UpstreamCall{{URI: "http://payments.com"}}},
+	}, hclog.NewNullLogger())
+	require.NoError(t, err)
+	h.routes = rt
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "payments"}`), nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/orders/123", nil)
+	r.Header.Set("X-User", "nic")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req := c.Calls[0].Arguments.Get(0).(*http.Request)
+	assert.Equal(t, http.MethodPut, req.Method)
+	assert.Equal(t, "web", req.Header.Get("X-Source"))
+
+	body, _ := io.ReadAll(req.Body)
+	assert.JSONEq(t, `{"order": "123", "user": "nic"}`, string(body))
+}
+
+func TestRequestUsesDefaultUpstreamSettings(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "test"}`), nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	req := c.Calls[0].Arguments.Get(0).(*http.Request)
+	assert.Equal(t, http.MethodGet, req.Method)
+}
+
+func TestRequestCallsUpstreamsUsingPlan(t *testing.T) {
+	h, c, _ := setupRequest(t, nil, 0)
+	h.plan = []config.Stage{
+		{Name: "auth", Upstreams: []config.UpstreamCall{{URI: "http://auth.com"}}},
+		{Upstreams: []config.UpstreamCall{{URI: "http://inventory.com"}, {URI: "http://pricing.com"}}},
+	}
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(rr.Body.String()))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	c.AssertNumberOfCalls(t, "Do", 3)
+
+	require.Len(t, mr.UpstreamCalls, 2)
+	assert.Equal(t, "auth", mr.UpstreamCalls["stage_1"].Name)
+	assert.Equal(t, "Stage", mr.UpstreamCalls["stage_1"].Type)
+	assert.Contains(t, mr.UpstreamCalls["stage_1"].UpstreamCalls, "http://auth.com")
+	assert.Equal(t, "stage 2", mr.UpstreamCalls["stage_2"].Name)
+	assert.Len(t, mr.UpstreamCalls["stage_2"].UpstreamCalls, 2)
+}
+
+func TestRequestSkipsStagesAfterUpstreamError(t *testing.T) {
+	h, c, _ := setupRequest(t, nil, 0)
+	h.plan = []config.Stage{
+		{Upstreams: []config.UpstreamCall{{URI: "http://auth.com"}}},
+		{Upstreams: []config.UpstreamCall{{URI: "http://inventory.com"}}},
+	}
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusInternalServerError, []byte(`{"name": "auth"}`), fmt.Errorf("boom"))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(rr.Body.String()))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	c.AssertNumberOfCalls(t, "Do", 1)
+	assert.Equal(t, "stage skipped, previous stage failed", mr.UpstreamCalls["stage_2"].Error)
+	assert.True(t, mr.UpstreamCalls["stage_2"].UpstreamCalls["http://inventory.com"].Skipped)
+}
+
+func TestRequestRetriesFailedUpstreamCalls(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://payments.com"}, 0)
+
+	u, err := NewUpstream(config.UpstreamCall{
+		URI:   "http://payments.com",
+		Retry: &config.RetryPolicy{Attempts: 3, Backoff: config.Duration(time.Millisecond)},
+	}, c)
+	require.NoError(t, err)
+	h.upstreams = map[string]*Upstream{u.URI: u}
+
+	c.On("Do", mock.Anything, mock.Anything).Once().Return(http.StatusServiceUnavailable, nil, fmt.Errorf("Boom"))
+	c.On("Do", mock.Anything, mock.Anything).Once().Return(http.StatusOK, []byte(`{"name": "payments"}`), nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	c.AssertNumberOfCalls(t, "Do", 2)
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	attempts := mr.UpstreamCalls["http://payments.com"].Attempts
+	require.Len(t, attempts, 2)
+	assert.Equal(t, http.StatusServiceUnavailable, attempts[0].Code)
+	assert.Equal(t, "Boom", attempts[0].Error)
+	assert.Equal(t, http.StatusOK, attempts[1].Code)
+	assert.NotEmpty(t, attempts[1].Backoff)
+}
+
+func TestRequestStopsRetryingAfterMaxAttempts(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://payments.com"}, 0)
+
+	u, err := NewUpstream(config.UpstreamCall{
+		URI:   "http://payments.com",
+		Retry: &config.RetryPolicy{Attempts: 3, Backoff: config.Duration(time.Millisecond)},
+	}, c)
+	require.NoError(t, err)
+	h.upstreams = map[string]*Upstream{u.URI: u}
+
+	c.On("Do", mock.Anything, mock.Anything).Return(-1, nil, fmt.Errorf("Boom"))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	c.AssertNumberOfCalls(t, "Do", 3)
+}
+
+func TestRequestDoesNotRetryCodesNotInPolicy(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://payments.com"}, 0)
+
+	u, err := NewUpstream(config.UpstreamCall{
+		URI:   "http://payments.com",
+		Retry: &config.RetryPolicy{Attempts: 3, Codes: []int{http.StatusServiceUnavailable}},
+	}, c)
+	require.NoError(t, err)
+	h.upstreams = map[string]*Upstream{u.URI: u}
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusBadRequest, nil, fmt.Errorf("Boom"))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	c.AssertNumberOfCalls(t, "Do", 1)
+}
+
+func TestRequestStopsRetryingWhenClientGoesAway(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://payments.com"}, 0)
+
+	u, err := NewUpstream(config.UpstreamCall{
+		URI:   "http://payments.com",
+		Retry: &config.RetryPolicy{Attempts: 3, Backoff: config.Duration(time.Second)},
+	}, c)
+	require.NoError(t, err)
+	h.upstreams = map[string]*Upstream{u.URI: u}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the client goes away while the first call is in progress
+	c.On("Do", mock.Anything, mock.Anything).Run(func(mock.Arguments) { cancel() }).Return(-1, nil, fmt.Errorf("Boom"))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	st := time.Now()
+	h.ServeHTTP(rr, r)
+
+	c.AssertNumberOfCalls(t, "Do", 1)
+	assert.Less(t, time.Since(st), time.Second)
+}
+
+func TestRequestFailsFastWhenCircuitBreakerOpen(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://payments.com"}, 0)
+
+	u, err := NewUpstream(config.UpstreamCall{URI: "http://payments.com"}, c)
+	require.NoError(t, err)
+	u.CircuitBreaker = NewCircuitBreaker(u.URI, config.CircuitBreakerPolicy{ConsecutiveFailures: 1}, h.log)
+	h.upstreams = map[string]*Upstream{u.URI: u}
+
+	c.On("Do", mock.Anything, mock.Anything).Return(-1, nil, fmt.Errorf("Boom"))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	c.AssertNumberOfCalls(t, "Do", 1)
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+	assert.Contains(t, mr.UpstreamCalls["http://payments.com"].Error, "circuit breaker is open")
+}
+
+func setupOptionalUpstreams(t *testing.T, h *Request, c *client.MockHTTP) {
+	h.upstreamURIs = []string{"http://auth.com", "http://recommendations.com"}
+	h.upstreams = map[string]*Upstream{}
+
+	for _, uc := range []config.UpstreamCall{{URI: "http://auth.com"}, {URI: "http://recommendations.com", Optional: true}} {
+		u, err := NewUpstream(uc, c)
+		require.NoError(t, err)
+
+		h.upstreams[u.URI] = u
+	}
+
+	c.On("Do", mock.MatchedBy(func(r *http.Request) bool { return r.URL.Host == "auth.com" }), mock.Anything).Return(http.StatusOK, []byte(`{"name": "auth"}`), nil)
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusInternalServerError, nil, fmt.Errorf("boom"))
+}
+
+func TestRequestIsDegradedWhenOptionalUpstreamFails(t *testing.T) {
+	h, c, _ := setupRequest(t, nil, 0)
+	h.failurePolicy = FailurePolicy{Type: FailOnRequired, DegradedCode: http.StatusPartialContent}
+	setupOptionalUpstreams(t, h, c)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.True(t, mr.Degraded)
+	assert.True(t, mr.UpstreamCalls["http://recommendations.com"].Optional)
+	assert.Equal(t, "boom", mr.UpstreamCalls["http://recommendations.com"].Error)
+}
+
+func TestRequestFailsWhenOptionalUpstreamFailsWithFailOnAny(t *testing.T) {
+	h, c, _ := setupRequest(t, nil, 0)
+	h.failurePolicy = FailurePolicy{Type: FailOnAny}
+	setupOptionalUpstreams(t, h, c)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestRequestSucceedsWhenQuorumOfUpstreamsSucceed(t *testing.T) {
+	h, c, _ := setupRequest(t, nil, 0)
+	h.failurePolicy = FailurePolicy{Type: FailOnQuorum, Quorum: 1, DegradedCode: http.StatusOK}
+	setupOptionalUpstreams(t, h, c)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestRequestFailsWhenQuorumOfUpstreamsNotReached(t *testing.T) {
+	h, c, _ := setupRequest(t, nil, 0)
+	h.failurePolicy = FailurePolicy{Type: FailOnQuorum, Quorum: 2}
+	setupOptionalUpstreams(t, h, c)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestRequestRendersBodyTemplate(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?name=nic", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	h.message = `{"hello": "{{ .Query.Get "name" }}", "upstream": "{{ (index .Upstreams "http://test.com").Name }}"}`
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream", "body": "OK"}`), nil)
+
+	h.ServeHTTP(rr, r)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(rr.Body.String()))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"hello": "nic", "upstream": "upstream"}`, string(mr.Body))
+}
+
+func TestRequestEscapesRequestDataInBody(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?name=a%22b", nil)
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+	h.message = `Hello {{ .Query.Get "name" }}`
+
+	h.ServeHTTP(rr, r)
+
+	mr := response.Response{}
+	require.NoError(t, mr.FromJSON(rr.Body.Bytes()))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `"Hello a\"b"`, string(mr.Body))
+}
+
+func TestRequestFailsWhenBodyIsNotValidJSON(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/?name=a%22b", nil)
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+	h.message = `{"name": "{{ .Query.Get "name" }}"}`
+
+	h.ServeHTTP(rr, r)
+
+	mr := response.Response{}
+	require.NoError(t, mr.FromJSON(rr.Body.Bytes()))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, mr.Error, "not valid JSON")
+}
+
+func TestRequestFailsWhenBodyTemplateCanNotBeRendered(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+	h.message = `{{ json .Missing.Field }}`
+
+	h.ServeHTTP(rr, r)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(rr.Body.String()))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, mr.Error, "unable to render body")
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	opentracing "github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc/codes"
+)
+
+const defaultRetryBackoff = 25 * time.Millisecond
+
+// RetryPolicy defines how failed calls to an upstream are retried
+type RetryPolicy struct {
+	Attempts int
+	// Codes are the HTTP status codes which are retried, when empty any
+	// error is retried
+	Codes map[int]bool
+	// GRPCCodes are the gRPC status codes which are retried, when empty any
+	// error is retried
+	GRPCCodes     map[codes.Code]bool
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	PerTryTimeout time.Duration
+}
+
+// attemptFunc makes a single call to an upstream, the context is cancelled
+// when the per try timeout expires
+type attemptFunc func(ctx context.Context, sc opentracing.SpanContext) (*response.Response, error)
+
+// NewRetryPolicy creates a retry policy from the definition, the definition
+// must have been validated
+func NewRetryPolicy(c config.RetryPolicy) *RetryPolicy {
+	p := &RetryPolicy{
+		Attempts:      c.Attempts,
+		Codes:         map[int]bool{},
+		GRPCCodes:     map[codes.Code]bool{},
+		Backoff:       time.Duration(c.Backoff),
+		MaxBackoff:    time.Duration(c.MaxBackoff),
+		PerTryTimeout: time.Duration(c.PerTryTimeout),
+	}
+
+	if p.Backoff == 0 {
+		p.Backoff = defaultRetryBackoff
+	}
+
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = 10 * p.Backoff
+	}
+
+	for _, c := range c.Codes {
+		p.Codes[c] = true
+	}
+
+	for _, n := range c.GRPCCodes {
+		gc, _ := config.ParseGRPCCode(n)
+		p.GRPCCodes[gc] = true
+	}
+
+	return p
+}
+
+// backoff returns the time to wait before the given retry, the interval
+// doubles for each retry up to the maximum and full jitter is applied
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// retryable returns true when the failed response can be retried, calls which
+// fail before a response is received are always retried
+func (p *RetryPolicy) retryable(uri string, r *response.Response) bool {
+	if config.IsGRPC(uri) {
+		return len(p.GRPCCodes) == 0 || p.GRPCCodes[codes.Code(r.Code)]
+	}
+
+	if r.Code == -1 {
+		return true
+	}
+
+	return len(p.Codes) == 0 || p.Codes[r.Code]
+}
+
+// context returns the context for an attempt made for the request context
+func (p *RetryPolicy) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.PerTryTimeout > 0 {
+		return context.WithTimeout(ctx, p.PerTryTimeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// callWithRetries calls the upstream, when the upstream has a retry policy
+// failed calls are retried and every attempt is added to the response. Calls
+// are not retried once the request context is done.
+func callWithRetries(ctx context.Context, sc opentracing.SpanContext, u *Upstream, l *logging.Logger, f attemptFunc) (*response.Response, error) {
+	if u.Retry == nil {
+		return f(ctx, sc)
+	}
+
+	lp := l.CallUpstreamWithRetries(u.URI, sc)
+	defer lp.Finished()
+
+	var r *response.Response
+	var err error
+	attempts := []response.Attempt{}
+
+	for i := 1; i <= u.Retry.Attempts; i++ {
+		a := response.Attempt{Attempt: i}
+
+		if i > 1 {
+			d := u.Retry.backoff(i - 1)
+			l.RetryUpstream(lp.Span, u.URI, i, d)
+
+			if !sleep(ctx, d) {
+				break
+			}
+
+			a.Backoff = d.String()
+		}
+
+		actx, cancel := u.Retry.context(ctx)
+		st := time.Now()
+		r, err = f(actx, lp.Span.Context())
+		et := time.Now()
+		cancel()
+
+		a.StartTime = st.Format(timeFormat)
+		a.EndTime = et.Format(timeFormat)
+		a.Duration = et.Sub(st).String()
+		a.Code = r.Code
+		if err != nil {
+			a.Error = err.Error()
+		}
+
+		if len(r.Attempts) == 0 {
+			attempts = append(attempts, a)
+		}
+
+		// the attempt was hedged, record both of the calls
+		for j, ha := range r.Attempts {
+			ha.Attempt = i
+			if j == 0 {
+				ha.Backoff = a.Backoff
+			}
+
+			attempts = append(attempts, ha)
+		}
+
+		// calls are not retried while the circuit breaker is open or once the
+		// client has gone away
+		if err == nil || errors.Is(err, ErrCircuitOpen) || ctx.Err() != nil || !u.Retry.retryable(u.URI, r) {
+			break
+		}
+	}
+
+	lp.SetMetadata("attempts", strconv.Itoa(len(attempts)))
+	lp.SetError(err)
+
+	r.Attempts = attempts
+
+	return r, err
+}
+
+// sleep waits for the duration, false is returned when the context is done
+// before the duration has passed
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
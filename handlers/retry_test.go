@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+)
+
+func TestRetryPolicyUsesDefaultBackoff(t *testing.T) {
+	p := NewRetryPolicy(config.RetryPolicy{Attempts: 2})
+
+	assert.Equal(t, defaultRetryBackoff, p.Backoff)
+	assert.Equal(t, 10*defaultRetryBackoff, p.MaxBackoff)
+}
+
+func TestRetryBackoffIsLimitedByMaxBackoff(t *testing.T) {
+	p := NewRetryPolicy(config.RetryPolicy{
+		Attempts:   10,
+		Backoff:    config.Duration(10 * time.Millisecond),
+		MaxBackoff: config.Duration(40 * time.Millisecond),
+	})
+
+	for i := 0; i < 100; i++ {
+		assert.LessOrEqual(t, p.backoff(1), 10*time.Millisecond)
+		assert.LessOrEqual(t, p.backoff(2), 20*time.Millisecond)
+		assert.LessOrEqual(t, p.backoff(8), 40*time.Millisecond)
+	}
+}
+
+func TestRetryableAlwaysRetriesConnectionErrors(t *testing.T) {
+	p := NewRetryPolicy(config.RetryPolicy{Attempts: 2, Codes: []int{503}})
+
+	assert.True(t, p.retryable("http://api", &response.Response{Code: -1}))
+	assert.True(t, p.retryable("http://api", &response.Response{Code: 503}))
+	assert.False(t, p.retryable("http://api", &response.Response{Code: 500}))
+}
+
+func TestRetryableUsesGRPCCodesForGRPCUpstreams(t *testing.T) {
+	p := NewRetryPolicy(config.RetryPolicy{Attempts: 2, GRPCCodes: []string{"UNAVAILABLE"}})
+
+	assert.True(t, p.retryable("grpc://api", &response.Response{Code: int(codes.Unavailable)}))
+	assert.False(t, p.retryable("grpc://api", &response.Response{Code: int(codes.Internal)}))
+}
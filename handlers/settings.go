@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/errors"
+	"github.com/nicholasjackson/fake-service/load"
+	"github.com/nicholasjackson/fake-service/routes"
+	"github.com/nicholasjackson/fake-service/timing"
+)
+
+// Settings is the configuration used by the request handlers which can be
+// replaced while the service is running. Requests take a copy of the
+// settings when they start so any change only affects new requests.
+type Settings struct {
+	Message string
+	// BodyTemplates are the parsed templates for the service and route
+	// messages
+	BodyTemplates BodyTemplates
+	Duration      *timing.RequestDuration
+	UpstreamURIs  []string
+	// Plan arranges the upstreams into stages, when set UpstreamURIs is ignored
+	Plan []config.Stage
+	// FailurePolicy decides if failed upstream calls fail the request
+	FailurePolicy FailurePolicy
+	// Upstreams contains the settings for the upstreams of the service and
+	// routes, keyed by URI
+	Upstreams     map[string]*Upstream
+	WorkerCount   int
+	DefaultClient client.HTTP
+	GRPCClients   map[string]client.GRPC
+	// CircuitBreakers for the upstreams keyed by URI, the circuit breakers
+	// are reused when the settings are recreated so their state is kept
+	CircuitBreakers  map[string]*CircuitBreaker
+	ErrorInjector    *errors.Injector
+	LoadGenerator    *load.Generator
+	RequestGenerator load.RequestGenerator
+	Routes           *routes.Table
+	// Stream defines the responses sent by the gRPC streaming methods
+	Stream StreamSettings
+	// WebSocket defines the behaviour of the WebSocket handler
+	WebSocket WebSocketSettings
+	// SSE defines when requests are answered with server-sent events
+	SSE SSESettings
+	// Raw defines how the TCP and UDP listeners respond
+	Raw RawSettings
+}
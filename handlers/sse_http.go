@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/opentracing/opentracing-go"
+)
+
+// SSE modes which decide when a request is answered with a stream of
+// server-sent events
+const (
+	// SSEDisabled never streams events
+	SSEDisabled = "disabled"
+	// SSEAccept streams events when the request accepts text/event-stream
+	SSEAccept = "accept"
+	// SSEAlways streams events for every request
+	SSEAlways = "always"
+)
+
+// SSESettings define the events sent when a request is answered with
+// server-sent events
+type SSESettings struct {
+	Mode string
+	// Events is the number of message events sent
+	Events int
+	// Interval is the delay before each message event is sent
+	Interval time.Duration
+	// PayloadSize is the size of the random payload added to message events
+	PayloadSize int
+	// UpstreamEvents sends an event with the response from each upstream as
+	// its call completes
+	UpstreamEvents bool
+}
+
+// requested returns true when the request should be answered with events
+func (s SSESettings) requested(r *http.Request) bool {
+	switch s.Mode {
+	case SSEAlways:
+		return true
+	case SSEAccept:
+		return acceptsEvents(r)
+	}
+
+	return false
+}
+
+// acceptsEvents returns true when the request accepts server-sent events
+func acceptsEvents(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// serveEvents streams the response as server-sent events, callUpstreams is nil
+// when there are no upstreams. An upstream event is sent as each upstream call
+// completes when upstream events are enabled, then
+// the message events are sent on the interval and the final response event
+// contains the complete response. The stream ends early when the client
+// disconnects or the write timeout for the server is reached.
+func (rq *Request) serveEvents(
+	rw http.ResponseWriter,
+	r *http.Request,
+	s Settings,
+	hq *logging.LogProcess,
+	resp *response.Response,
+	message string,
+	params map[string]string,
+	callUpstreams func(completed func(*response.Response)) error,
+) {
+	ts := time.Now()
+	hq.SetMetadata("sse", "true")
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.WriteHeader(http.StatusOK)
+
+	ew := &eventWriter{rc: http.NewResponseController(rw), rw: rw, log: rq.log, span: hq.Span}
+
+	// send the headers so that the client knows the stream has started
+	if err := ew.rc.Flush(); err != nil {
+		hq.SetError(fmt.Errorf("unable to stream events: %s", err))
+		return
+	}
+
+	code := http.StatusOK
+
+	if callUpstreams != nil {
+		var completed func(*response.Response)
+		if s.SSE.UpstreamEvents {
+			completed = func(ur *response.Response) {
+				ew.write("upstream", ur)
+			}
+		}
+
+		if err := callUpstreams(completed); err != nil {
+			code = http.StatusInternalServerError
+			resp.Error = err.Error()
+			hq.SetError(err)
+		}
+	}
+
+	// the body is rendered for every event so that functions such as uuid
+	// return a different value for each event
+	data := newRequestData(r, params)
+	body, bodyErr := s.BodyTemplates.render(message, newResponseData(data, resp))
+
+	for i := 1; i <= s.SSE.Events && ew.err == nil && bodyErr == nil; i++ {
+		if err := waitInterval(r.Context(), s.SSE.Interval); err != nil {
+			hq.SetError(fmt.Errorf("client closed the stream: %s", r.Context().Err()))
+			return
+		}
+
+		ew.write("message", &response.Response{
+			Name:     rq.name,
+			Type:     "HTTP",
+			Sequence: i,
+			Body:     body,
+			Payload:  randomPayload(s.SSE.PayloadSize),
+			Code:     http.StatusOK,
+		})
+
+		body, bodyErr = s.BodyTemplates.render(message, newResponseData(data, resp))
+	}
+
+	// upstream calls failed but the failure policy allowed the request to
+	// succeed
+	if resp.Degraded {
+		hq.SetMetadata("degraded", "true")
+
+		if s.FailurePolicy.DegradedCode != 0 {
+			code = s.FailurePolicy.DegradedCode
+		}
+	}
+
+	// the stream fails when the body template can not be rendered
+	if bodyErr != nil && resp.Error == "" {
+		code = http.StatusInternalServerError
+		resp.Error = bodyErr.Error()
+		hq.SetError(bodyErr)
+	}
+
+	te := time.Now()
+	resp.StartTime = ts.Format(timeFormat)
+	resp.EndTime = te.Format(timeFormat)
+	resp.Duration = te.Sub(ts).String()
+	resp.Body = body
+	resp.Code = code
+
+	ew.write("response", resp)
+
+	hq.SetMetadata("response", strconv.Itoa(code))
+	if ew.err != nil {
+		hq.SetError(fmt.Errorf("unable to write event: %s", ew.err))
+	}
+}
+
+// eventWriter writes server-sent events to the response, events are written
+// by the upstream workers so writes are serialised
+type eventWriter struct {
+	mutex sync.Mutex
+	rc    *http.ResponseController
+	rw    http.ResponseWriter
+	log   *logging.Logger
+	span  opentracing.Span
+	// id of the last event written
+	id int
+	// err is the first error writing an event, no more events are written
+	// once an error has occurred
+	err error
+}
+
+// write the response as the data for an event and flush it to the client
+func (w *eventWriter) write(event string, r *response.Response) {
+	d, _ := json.Marshal(r)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.err != nil {
+		return
+	}
+
+	w.id++
+	if _, err := fmt.Fprintf(w.rw, "id: %d\nevent: %s\ndata: %s\n\n", w.id, event, d); err != nil {
+		w.err = err
+		return
+	}
+
+	if err := w.rc.Flush(); err != nil {
+		w.err = err
+		return
+	}
+
+	w.log.SendEvent(w.span, event, w.id)
+}
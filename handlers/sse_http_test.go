@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type sseEvent struct {
+	id       string
+	event    string
+	response response.Response
+}
+
+func readEvents(t *testing.T, body string) []sseEvent {
+	events := []sseEvent{}
+	e := sseEvent{}
+
+	s := bufio.NewScanner(strings.NewReader(body))
+	for s.Scan() {
+		k, v, _ := strings.Cut(s.Text(), ": ")
+
+		switch k {
+		case "id":
+			e.id = v
+		case "event":
+			e.event = v
+		case "data":
+			require.NoError(t, e.response.FromJSON([]byte(v)))
+		case "":
+			events = append(events, e)
+			e = sseEvent{}
+		}
+	}
+
+	return events
+}
+
+func sseRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader([]byte("")))
+	r.Header.Set("Accept", "text/event-stream")
+
+	return r
+}
+
+func TestRequestStreamsEventsWhenAccepted(t *testing.T) {
+	rr := httptest.NewRecorder()
+	h, c, _ := setupRequest(t, nil, 0)
+	h.sse = SSESettings{Mode: SSEAccept, Events: 3, Interval: time.Millisecond, PayloadSize: 8}
+
+	h.ServeHTTP(rr, sseRequest())
+
+	c.AssertNotCalled(t, "Do", mock.Anything)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+
+	events := readEvents(t, rr.Body.String())
+	require.Len(t, events, 4)
+
+	for i, e := range events[:3] {
+		assert.Equal(t, "message", e.event)
+		assert.Equal(t, i+1, e.response.Sequence)
+		assert.Equal(t, "test", e.response.Name)
+		assert.Len(t, e.response.Payload, 8)
+	}
+
+	assert.Equal(t, "4", events[3].id)
+	assert.Equal(t, "response", events[3].event)
+	assert.Equal(t, http.StatusOK, events[3].response.Code)
+}
+
+func TestRequestDoesNotStreamEventsWhenNotAccepted(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+	h.sse = SSESettings{Mode: SSEAccept, Events: 3}
+
+	h.ServeHTTP(rr, r)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotEqual(t, "text/event-stream", rr.Header().Get("Content-Type"))
+}
+
+func TestRequestDoesNotStreamEventsWhenDisabled(t *testing.T) {
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+	h.sse = SSESettings{Mode: SSEDisabled, Events: 3}
+
+	h.ServeHTTP(rr, sseRequest())
+
+	assert.NotEqual(t, "text/event-stream", rr.Header().Get("Content-Type"))
+}
+
+func TestRequestAlwaysStreamsEvents(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+	h.sse = SSESettings{Mode: SSEAlways, Events: 1}
+
+	h.ServeHTTP(rr, r)
+
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+	assert.Len(t, readEvents(t, rr.Body.String()), 2)
+}
+
+func TestRequestStreamsUpstreamEvents(t *testing.T) {
+	rr := httptest.NewRecorder()
+	h, c, _ := setupRequest(t, []string{"http://test.com", "http://test2.com"}, 0)
+	h.sse = SSESettings{Mode: SSEAccept, Events: 1, UpstreamEvents: true}
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream", "body": "OK"}`), nil)
+
+	h.ServeHTTP(rr, sseRequest())
+
+	events := readEvents(t, rr.Body.String())
+	require.Len(t, events, 4)
+
+	uris := []string{}
+	for _, e := range events[:2] {
+		assert.Equal(t, "upstream", e.event)
+		assert.Equal(t, "upstream", e.response.Name)
+		uris = append(uris, e.response.URI)
+	}
+	assert.ElementsMatch(t, []string{"http://test.com", "http://test2.com"}, uris)
+
+	assert.Equal(t, "message", events[2].event)
+	assert.Equal(t, "response", events[3].event)
+	assert.Len(t, events[3].response.UpstreamCalls, 2)
+}
+
+func TestRequestStreamsErrorWhenUpstreamFails(t *testing.T) {
+	rr := httptest.NewRecorder()
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	h.sse = SSESettings{Mode: SSEAccept}
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusInternalServerError, []byte(`{"name": "upstream", "code": 500, "error": "boom"}`), fmt.Errorf("Error processing upstream"))
+
+	h.ServeHTTP(rr, sseRequest())
+
+	events := readEvents(t, rr.Body.String())
+	require.Len(t, events, 1)
+
+	assert.Equal(t, "response", events[0].event)
+	assert.Equal(t, http.StatusInternalServerError, events[0].response.Code)
+	assert.NotEmpty(t, events[0].response.Error)
+}
+
+func TestRequestAsksUpstreamsForJSONWhenEventsAccepted(t *testing.T) {
+	rr := httptest.NewRecorder()
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	h.sse = SSESettings{Mode: SSEAccept}
+
+	c.On("Do", mock.MatchedBy(func(r *http.Request) bool {
+		return r.Header.Get("Accept") == "application/json"
+	}), mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	h.ServeHTTP(rr, sseRequest())
+
+	c.AssertNumberOfCalls(t, "Do", 1)
+}
+
+func TestRequestDoesNotSetUpstreamAcceptWhenEventsNotAccepted(t *testing.T) {
+	rr := httptest.NewRecorder()
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+
+	c.On("Do", mock.MatchedBy(func(r *http.Request) bool {
+		return r.Header.Get("Accept") == ""
+	}), mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept", "application/xml")
+	h.ServeHTTP(rr, r)
+
+	c.AssertNumberOfCalls(t, "Do", 1)
+}
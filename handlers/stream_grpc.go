@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/errors"
+	"github.com/nicholasjackson/fake-service/grpc/api"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StreamSettings define the responses sent by the gRPC streaming methods
+type StreamSettings struct {
+	// Messages is the number of responses sent by ServerStream
+	Messages int
+	// Interval is the delay before each response is sent
+	Interval time.Duration
+	// MessageSize is the size of the random data added to each response
+	MessageSize int
+	// ErrorAfter ends the stream with ErrorCode once the number of messages
+	// have been sent or received, 0 disables the error
+	ErrorAfter int
+	ErrorCode  codes.Code
+}
+
+// ServerStream implements the FakeServer ServerStream interface method, the
+// configured number of responses are sent for the request
+func (f *FakeServer) ServerStream(in *api.Request, stream api.FakeService_ServerStreamServer) error {
+	s, hq, err := f.startStream(stream.Context(), "ServerStream")
+	if err != nil {
+		return err
+	}
+	defer hq.Finished()
+
+	for i := 1; i <= s.Stream.Messages; i++ {
+		if err := waitInterval(stream.Context(), s.Stream.Interval); err != nil {
+			return f.endStream(hq, i-1, err)
+		}
+
+		resp, err := f.streamResponse(stream.Context(), s, hq, i, 0)
+		if err != nil {
+			return f.endStream(hq, i-1, err)
+		}
+
+		if err := stream.Send(resp); err != nil {
+			return f.endStream(hq, i-1, err)
+		}
+
+		if err := f.streamError(s.Stream, i); err != nil {
+			return f.endStream(hq, i, err)
+		}
+	}
+
+	return f.endStream(hq, s.Stream.Messages, nil)
+}
+
+// ClientStream implements the FakeServer ClientStream interface method, a
+// single response containing the number of messages received is sent when
+// the client closes the stream
+func (f *FakeServer) ClientStream(stream api.FakeService_ClientStreamServer) error {
+	s, hq, err := f.startStream(stream.Context(), "ClientStream")
+	if err != nil {
+		return err
+	}
+	defer hq.Finished()
+
+	received := 0
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return f.endStream(hq, received, err)
+		}
+
+		received++
+		if err := f.streamError(s.Stream, received); err != nil {
+			return f.endStream(hq, received, err)
+		}
+	}
+
+	resp, err := f.streamResponse(stream.Context(), s, hq, 0, received)
+	if err != nil {
+		return f.endStream(hq, received, err)
+	}
+
+	return f.endStream(hq, received, stream.SendAndClose(resp))
+}
+
+// BidirectionalStream implements the FakeServer BidirectionalStream interface
+// method, a response is sent for every request until the client closes the
+// stream
+func (f *FakeServer) BidirectionalStream(stream api.FakeService_BidirectionalStreamServer) error {
+	s, hq, err := f.startStream(stream.Context(), "BidirectionalStream")
+	if err != nil {
+		return err
+	}
+	defer hq.Finished()
+
+	for i := 1; ; i++ {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			return f.endStream(hq, i-1, nil)
+		}
+
+		if err != nil {
+			return f.endStream(hq, i-1, err)
+		}
+
+		if err := waitInterval(stream.Context(), s.Stream.Interval); err != nil {
+			return f.endStream(hq, i-1, err)
+		}
+
+		resp, err := f.streamResponse(stream.Context(), s, hq, i, i)
+		if err != nil {
+			return f.endStream(hq, i-1, err)
+		}
+
+		if err := stream.Send(resp); err != nil {
+			return f.endStream(hq, i-1, err)
+		}
+
+		if err := f.streamError(s.Stream, i); err != nil {
+			return f.endStream(hq, i, err)
+		}
+	}
+}
+
+// startStream returns the settings used for the stream and starts logging it,
+// an error is returned when the service is not ready
+func (f *FakeServer) startStream(ctx context.Context, method string) (Settings, *logging.LogProcess, error) {
+	if f.waitTillReady && !f.readinessHandler.Complete() {
+		f.log.Log().Info("Service Unavailable")
+		return Settings{}, nil, status.Error(codes.Unavailable, "Server Unavailable")
+	}
+
+	// take a copy of the settings so that the stream is not affected if they
+	// change while it is open
+	s := f.settings()
+
+	hq := f.log.HandleGRCPRequest(ctx)
+	hq.SetMetadata("method", method)
+
+	return s, hq, nil
+}
+
+// endStream records the number of messages and the status of the stream
+func (f *FakeServer) endStream(hq *logging.LogProcess, messages int, err error) error {
+	hq.SetMetadata("messages", strconv.Itoa(messages))
+	hq.SetMetadata("response", strconv.Itoa(int(status.Code(err))))
+
+	if err != nil {
+		hq.SetError(err)
+	}
+
+	return err
+}
+
+// streamError returns the injected error once the number of messages sent or
+// received reaches the configured limit
+func (f *FakeServer) streamError(s StreamSettings, messages int) error {
+	if s.ErrorAfter == 0 || messages < s.ErrorAfter {
+		return nil
+	}
+
+	f.log.RequestErrorInjected("grpc", int(s.ErrorCode))
+
+	return status.Error(s.ErrorCode, errors.ErrorInjection.Error())
+}
+
+// streamResponse creates a response for the stream, sequence is the position
+// of the response and received the number of messages read from the client.
+// An Internal error is returned when the message can not be rendered.
+func (f *FakeServer) streamResponse(ctx context.Context, s Settings, hq *logging.LogProcess, sequence, received int) (*api.Response, error) {
+	resp := &response.Response{
+		Name:     f.name,
+		Type:     "gRPC",
+		Peer:     hq.Peer,
+		Sequence: sequence,
+		Received: received,
+	}
+
+	body, err := s.BodyTemplates.render(s.Message, newResponseData(newGRPCRequestData(ctx), resp))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp.Body = body
+
+	data := make([]byte, s.Stream.MessageSize)
+	rand.Read(data)
+
+	return &api.Response{Message: resp.ToJSON(), Data: data}, nil
+}
+
+// waitInterval waits for the interval or until the stream is closed
+func waitInterval(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-t.C:
+		return nil
+	}
+}
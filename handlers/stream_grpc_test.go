@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/grpc/api"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func setupStreamServer(t *testing.T, ss StreamSettings) api.FakeServiceClient {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	fs.stream = ss
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := grpc.NewServer()
+	api.RegisterFakeServiceServer(s, fs)
+
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return api.NewFakeServiceClient(conn)
+}
+
+func streamResponse(t *testing.T, r *api.Response) response.Response {
+	mr := response.Response{}
+	require.NoError(t, mr.FromJSON([]byte(r.Message)))
+
+	return mr
+}
+
+func TestGRPCServerStreamSendsMessages(t *testing.T) {
+	c := setupStreamServer(t, StreamSettings{Messages: 3, Interval: time.Millisecond, MessageSize: 10})
+
+	st, err := c.ServerStream(context.Background(), &api.Request{})
+	require.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		r, err := st.Recv()
+		require.NoError(t, err)
+
+		assert.Equal(t, i, streamResponse(t, r).Sequence)
+		assert.Len(t, r.Data, 10)
+	}
+
+	_, err = st.Recv()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestGRPCServerStreamReturnsErrorAfterMessages(t *testing.T) {
+	c := setupStreamServer(t, StreamSettings{Messages: 5, ErrorAfter: 2, ErrorCode: codes.Unavailable})
+
+	st, err := c.ServerStream(context.Background(), &api.Request{})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		_, err := st.Recv()
+		require.NoError(t, err)
+	}
+
+	_, err = st.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestGRPCServerStreamIsCancelledByDeadline(t *testing.T) {
+	c := setupStreamServer(t, StreamSettings{Messages: 5, Interval: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	st, err := c.ServerStream(ctx, &api.Request{})
+	require.NoError(t, err)
+
+	_, err = st.Recv()
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
+
+func TestGRPCClientStreamReturnsMessagesReceived(t *testing.T) {
+	c := setupStreamServer(t, StreamSettings{})
+
+	st, err := c.ClientStream(context.Background())
+	require.NoError(t, err)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, st.Send(&api.Request{}))
+	}
+
+	r, err := st.CloseAndRecv()
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, streamResponse(t, r).Received)
+}
+
+func TestGRPCClientStreamReturnsErrorAfterMessages(t *testing.T) {
+	c := setupStreamServer(t, StreamSettings{ErrorAfter: 2, ErrorCode: codes.Internal})
+
+	st, err := c.ClientStream(context.Background())
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, st.Send(&api.Request{}))
+	}
+
+	_, err = st.CloseAndRecv()
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
+
+func TestGRPCBidirectionalStreamRespondsToEachRequest(t *testing.T) {
+	c := setupStreamServer(t, StreamSettings{})
+
+	st, err := c.BidirectionalStream(context.Background())
+	require.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, st.Send(&api.Request{}))
+
+		r, err := st.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, i, streamResponse(t, r).Sequence)
+	}
+
+	require.NoError(t, st.CloseSend())
+
+	_, err = st.Recv()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestGRPCBidirectionalStreamReturnsErrorAfterMessages(t *testing.T) {
+	c := setupStreamServer(t, StreamSettings{ErrorAfter: 1, ErrorCode: codes.Aborted})
+
+	st, err := c.BidirectionalStream(context.Background())
+	require.NoError(t, err)
+
+	require.NoError(t, st.Send(&api.Request{}))
+
+	_, err = st.Recv()
+	require.NoError(t, err)
+
+	_, err = st.Recv()
+	assert.Equal(t, codes.Aborted, status.Code(err))
+}
+
+func TestGRPCStreamResponseRendersBodyTemplate(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-user", "nic"))
+	hq := fs.log.HandleGRCPRequest(ctx)
+	defer hq.Finished()
+
+	r, err := fs.streamResponse(ctx, Settings{Message: `{"user": {{ json (.Headers.Get "x-user") }}}`}, hq, 1, 0)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"user": "nic"}`, string(streamResponse(t, r).Body))
+}
+
+func TestGRPCStreamResponseReturnsErrorWhenBodyIsNotValidJSON(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-user", `a"b`))
+	hq := fs.log.HandleGRCPRequest(ctx)
+	defer hq.Finished()
+
+	_, err := fs.streamResponse(ctx, Settings{Message: `{"user": "{{ .Headers.Get "x-user" }}"}`}, hq, 1, 0)
+
+	assert.Equal(t, codes.Internal, status.Code(err))
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// TCP handles connections to the raw TCP listener
+type TCP struct {
+	rawHandler
+}
+
+// NewTCP creates a new TCP handler
+func NewTCP(s Settings, l *logging.Logger) *TCP {
+	t := &TCP{rawHandler{network: "tcp", log: l}}
+	t.Update(s)
+
+	return t
+}
+
+// Serve handles the connections accepted by the listener until it is closed,
+// nil is returned when the listener is closed
+func (t *TCP) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		go t.handle(conn)
+	}
+}
+
+// handle responds to the data sent on the connection until the client closes
+// it, injected errors reset the connection
+func (t *TCP) handle(conn net.Conn) {
+	defer conn.Close()
+
+	st := time.Now()
+	d, ei, s := t.settings()
+
+	hq := t.log.HandleTCPConnection(conn.RemoteAddr())
+	defer hq.Finished()
+
+	hq.SetMetadata("mode", s.Mode)
+
+	if err := t.injectError(ei); err != nil {
+		// closing the connection without lingering sends a reset to the client
+		if tc, ok := conn.(*net.TCPConn); ok {
+			tc.SetLinger(0)
+		}
+
+		hq.SetError(err)
+		return
+	}
+
+	// the latency is added once for each connection before any data is sent
+	t.wait(hq, d, st)
+
+	c := &tcpConnection{
+		conn:     conn,
+		settings: s,
+		log:      t.log,
+		hq:       hq,
+	}
+
+	if err := c.run(); err != nil {
+		hq.SetError(err)
+	}
+}
+
+// tcpUpstream is a connection to an upstream, the span for the upstream is
+// finished when the connection is closed
+type tcpUpstream struct {
+	uri  string
+	conn net.Conn
+	lp   *logging.LogProcess
+}
+
+// tcpConnection is a connection from a client
+type tcpConnection struct {
+	conn     net.Conn
+	settings RawSettings
+	log      *logging.Logger
+	hq       *logging.LogProcess
+}
+
+// run responds to the data read from the client until the connection is
+// closed
+func (c *tcpConnection) run() error {
+	if c.settings.Mode == RawUpstream {
+		return c.forward()
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := c.conn.Read(buf)
+		if n > 0 {
+			c.log.RawMessage(c.hq.Span, "tcp", "", "received", n)
+
+			data := c.settings.Payload
+			if c.settings.Mode == RawEcho {
+				data = buf[:n]
+			}
+
+			if _, err := c.conn.Write(data); err != nil {
+				return err
+			}
+
+			c.log.RawMessage(c.hq.Span, "tcp", "", "sent", len(data))
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// forward sends the data read from the client to the upstreams and the data
+// read from the upstreams to the client, it returns once the client and the
+// upstreams have closed their connections
+func (c *tcpConnection) forward() error {
+	upstreams, err := c.dialUpstreams()
+	defer c.closeUpstreams(upstreams)
+
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, len(upstreams)+1)
+
+	for _, u := range upstreams {
+		go func(u *tcpUpstream) {
+			errs <- c.copyFromUpstream(u)
+		}(u)
+	}
+
+	go func() {
+		err := c.copyToUpstreams(upstreams)
+
+		// tell the upstreams that the client has finished sending data so that
+		// they close their connections
+		for _, u := range upstreams {
+			if tc, ok := u.conn.(*net.TCPConn); ok {
+				tc.CloseWrite()
+			}
+		}
+
+		errs <- err
+	}()
+
+	var forwardErr error
+	for i := 0; i < cap(errs); i++ {
+		err := <-errs
+		if err == nil || forwardErr != nil {
+			continue
+		}
+
+		// closing the connections stops the remaining copies
+		forwardErr = err
+		c.conn.Close()
+		for _, u := range upstreams {
+			u.conn.Close()
+		}
+	}
+
+	return forwardErr
+}
+
+// copyFromUpstream writes the data read from the upstream to the client until
+// the upstream closes the connection
+func (c *tcpConnection) copyFromUpstream(u *tcpUpstream) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := u.conn.Read(buf)
+		if n > 0 {
+			c.log.RawMessage(c.hq.Span, "tcp", u.uri, "received", n)
+
+			if _, err := c.conn.Write(buf[:n]); err != nil {
+				return err
+			}
+
+			c.log.RawMessage(c.hq.Span, "tcp", "", "sent", n)
+		}
+
+		if err == io.EOF || errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+
+		if err != nil {
+			u.lp.SetError(err)
+			return fmt.Errorf("upstream %s closed the connection: %s", u.uri, err)
+		}
+	}
+}
+
+// copyToUpstreams writes the data read from the client to every upstream
+// until the client closes the connection
+func (c *tcpConnection) copyToUpstreams(upstreams []*tcpUpstream) error {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := c.conn.Read(buf)
+		if n > 0 {
+			c.log.RawMessage(c.hq.Span, "tcp", "", "received", n)
+
+			for _, u := range upstreams {
+				if _, err := u.conn.Write(buf[:n]); err != nil {
+					return fmt.Errorf("unable to send data to upstream %s: %s", u.uri, err)
+				}
+
+				c.log.RawMessage(c.hq.Span, "tcp", u.uri, "sent", n)
+			}
+		}
+
+		if err == io.EOF || errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// dialUpstreams connects to the tcp:// upstreams
+func (c *tcpConnection) dialUpstreams() ([]*tcpUpstream, error) {
+	upstreams := []*tcpUpstream{}
+
+	for _, addr := range c.settings.upstreams("tcp") {
+		uri := "tcp://" + addr
+		lp := c.log.CallRawUpstream("tcp", uri, c.hq.Span.Context())
+
+		conn, err := net.DialTimeout("tcp", addr, c.settings.Timeout)
+		if err != nil {
+			lp.SetError(err)
+			lp.Finished()
+
+			return upstreams, fmt.Errorf("unable to connect to upstream %s: %s", uri, err)
+		}
+
+		upstreams = append(upstreams, &tcpUpstream{uri: uri, conn: conn, lp: lp})
+	}
+
+	return upstreams, nil
+}
+
+// closeUpstreams closes the connections to the upstreams
+func (c *tcpConnection) closeUpstreams(upstreams []*tcpUpstream) {
+	for _, u := range upstreams {
+		u.conn.Close()
+		u.lp.Finished()
+	}
+}
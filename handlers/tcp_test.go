@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/errors"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/timing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rawSettings(r RawSettings, errorRate float64) Settings {
+	r.Timeout = time.Second
+
+	return Settings{
+		Duration:      timing.NewRequestDuration(time.Nanosecond, time.Nanosecond, time.Nanosecond, 0),
+		ErrorInjector: errors.NewInjector(hclog.Default(), errorRate, http.StatusInternalServerError, "http_error", 0, 0, 0),
+		Raw:           r,
+	}
+}
+
+func setupTCP(t *testing.T, s Settings) string {
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go NewTCP(s, l).Serve(ln)
+
+	return ln.Addr().String()
+}
+
+func dialTCP(t *testing.T, addr string) net.Conn {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	return conn
+}
+
+func TestTCPEchoesData(t *testing.T) {
+	conn := dialTCP(t, setupTCP(t, rawSettings(RawSettings{Mode: RawEcho}, 0)))
+
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	d := make([]byte, 4)
+	_, err = io.ReadFull(conn, d)
+	require.NoError(t, err)
+
+	assert.Equal(t, "ping", string(d))
+}
+
+func TestTCPRespondsWithPayload(t *testing.T) {
+	conn := dialTCP(t, setupTCP(t, rawSettings(RawSettings{Mode: RawPayload, Payload: []byte("pong")}, 0)))
+
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	d := make([]byte, 4)
+	_, err = io.ReadFull(conn, d)
+	require.NoError(t, err)
+
+	assert.Equal(t, "pong", string(d))
+}
+
+func TestTCPForwardsDataToUpstreams(t *testing.T) {
+	upstream := setupTCP(t, rawSettings(RawSettings{Mode: RawEcho}, 0))
+	addr := setupTCP(t, rawSettings(RawSettings{
+		Mode:         RawUpstream,
+		UpstreamURIs: []string{"tcp://" + upstream, "udp://127.0.0.1:1"},
+	}, 0))
+
+	conn := dialTCP(t, addr)
+
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	d := make([]byte, 4)
+	_, err = io.ReadFull(conn, d)
+	require.NoError(t, err)
+
+	assert.Equal(t, "ping", string(d))
+
+	// the connection is closed once the client and the upstream have closed
+	// their connections
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	_, err = conn.Read(d)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestTCPClosesConnectionWhenUpstreamUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ln.Close()
+
+	conn := dialTCP(t, setupTCP(t, rawSettings(RawSettings{
+		Mode:         RawUpstream,
+		UpstreamURIs: []string{"tcp://" + ln.Addr().String()},
+	}, 0)))
+
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+}
+
+func TestTCPResetsConnectionWhenErrorInjected(t *testing.T) {
+	addr := setupTCP(t, rawSettings(RawSettings{Mode: RawEcho}, 1))
+
+	// the reset can be received before the connection has been established
+	conn, err := net.Dial("tcp", addr)
+	if err == nil {
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+	}
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "connection reset by peer")
+}
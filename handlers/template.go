@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/google/uuid"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// templateFuncs are the functions which can be used in the response body and
+// upstream body templates
+var templateFuncs = template.FuncMap{
+	// uuid returns a random version 4 UUID
+	"uuid": func() string {
+		return uuid.NewString()
+	},
+	// randomInt returns a random integer between min and max inclusive
+	"randomInt": func(min, max int) int {
+		if max <= min {
+			return min
+		}
+
+		return min + rand.Intn(max-min+1)
+	},
+	// now returns the current time which can be formatted using its methods
+	"now": time.Now,
+	// timestamp returns the current time in RFC 3339 format
+	"timestamp": func() string {
+		return time.Now().Format(time.RFC3339)
+	},
+	// fake replaces the {name} tags in the string with fake data, e.g.
+	// {firstname}, {email} or {city}, # is replaced with a random digit and
+	// ? with a random letter
+	"fake": gofakeit.Generate,
+	// json returns the value encoded as JSON
+	"json": func(v interface{}) (string, error) {
+		d, err := json.Marshal(v)
+		return string(d), err
+	},
+}
+
+// ResponseData is the data available to the response body template
+type ResponseData struct {
+	RequestData
+	// Upstreams are the responses from the upstreams keyed by URI
+	Upstreams map[string]response.Response
+}
+
+// newResponseData returns the template data for the response to the request
+func newResponseData(d RequestData, resp *response.Response) ResponseData {
+	return ResponseData{RequestData: d, Upstreams: resp.UpstreamCalls}
+}
+
+// BodyTemplates are the parsed response body templates keyed by message, they
+// are created with the settings so that the messages are parsed once
+type BodyTemplates map[string]*template.Template
+
+// NewBodyTemplates parses the messages which contain actions, an error is
+// returned when a message is not a valid template
+func NewBodyTemplates(messages ...string) (BodyTemplates, error) {
+	bt := BodyTemplates{}
+
+	for _, m := range messages {
+		if !strings.Contains(m, "{{") {
+			continue
+		}
+
+		t, err := parseBody(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body template: %s", err)
+		}
+
+		bt[m] = t
+	}
+
+	return bt, nil
+}
+
+// parseBody parses the message as a template
+func parseBody(message string) (*template.Template, error) {
+	return template.New("body").Option("missingkey=zero").Funcs(templateFuncs).Parse(message)
+}
+
+// render renders the message as a template and returns it as the body of a
+// response, messages which do not contain actions are not rendered and
+// messages which were not parsed with the settings are parsed for each call. An error
+// is returned when a body which starts with { is not valid JSON, the values
+// inserted into a JSON body are not escaped so the json function should be
+// used for values which are not trusted.
+func (bt BodyTemplates) render(message string, d ResponseData) (json.RawMessage, error) {
+	body := message
+
+	if strings.Contains(message, "{{") {
+		t, ok := bt[message]
+		if !ok {
+			var err error
+			if t, err = parseBody(message); err != nil {
+				return nil, fmt.Errorf("invalid body template: %s", err)
+			}
+		}
+
+		b := &bytes.Buffer{}
+		if err := t.Execute(b, d); err != nil {
+			return nil, fmt.Errorf("unable to render body: %s", err)
+		}
+
+		body = b.String()
+	}
+
+	rb := messageBody(body)
+	if !json.Valid(rb) {
+		return nil, fmt.Errorf("body is not valid JSON: %s", body)
+	}
+
+	return rb, nil
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func templateData() ResponseData {
+	return ResponseData{
+		RequestData: RequestData{
+			Method:  http.MethodGet,
+			Path:    "/orders/123",
+			Query:   url.Values{"page": []string{"2"}},
+			Headers: http.Header{"X-User": []string{"nic"}},
+			Params:  map[string]string{"id": "123"},
+		},
+		Upstreams: map[string]response.Response{
+			"http://payments:9090": {Name: "payments", Code: http.StatusOK},
+		},
+	}
+}
+
+func TestRenderBodyReturnsMessageWithoutActions(t *testing.T) {
+	b, err := BodyTemplates{}.render("hello world", templateData())
+	require.NoError(t, err)
+
+	assert.Equal(t, `"hello world"`, string(b))
+}
+
+func TestRenderBodyUsesRequestData(t *testing.T) {
+	b, err := BodyTemplates{}.render(`{"id": "{{ .Params.id }}", "user": "{{ .Headers.Get "X-User" }}", "page": "{{ .Query.Get "page" }}", "path": "{{ .Path }}"}`, templateData())
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"id": "123", "user": "nic", "page": "2", "path": "/orders/123"}`, string(b))
+}
+
+func TestRenderBodyUsesUpstreamResponses(t *testing.T) {
+	b, err := BodyTemplates{}.render(`{{ (index .Upstreams "http://payments:9090").Name }}`, templateData())
+	require.NoError(t, err)
+
+	assert.Equal(t, `"payments"`, string(b))
+}
+
+func TestRenderBodyUsesFunctions(t *testing.T) {
+	b, err := BodyTemplates{}.render(`{"id": "{{ uuid }}", "count": {{ randomInt 1 5 }}, "time": "{{ timestamp }}", "year": {{ now.Year }}, "code": "{{ fake "###" }}"}`, ResponseData{})
+	require.NoError(t, err)
+
+	mr := struct {
+		ID    string
+		Count int
+		Time  string
+		Year  int
+		Code  string
+	}{}
+	require.NoError(t, json.Unmarshal(b, &mr))
+
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f-]{36}$`), mr.ID)
+	assert.GreaterOrEqual(t, mr.Count, 1)
+	assert.LessOrEqual(t, mr.Count, 5)
+	assert.Equal(t, time.Now().Year(), mr.Year)
+	assert.Regexp(t, regexp.MustCompile(`^[0-9]{3}$`), mr.Code)
+
+	_, err = time.Parse(time.RFC3339, mr.Time)
+	assert.NoError(t, err)
+}
+
+func TestRenderBodyEncodesJSON(t *testing.T) {
+	b, err := BodyTemplates{}.render(`{"params": {{ json .Params }}}`, templateData())
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{"params": {"id": "123"}}`, string(b))
+}
+
+func TestRenderBodyEscapesStrings(t *testing.T) {
+	d := templateData()
+	d.Query = url.Values{"name": []string{`a"b`}}
+
+	b, err := BodyTemplates{}.render(`Hello {{ .Query.Get "name" }}`, d)
+	require.NoError(t, err)
+
+	assert.Equal(t, `"Hello a\"b"`, string(b))
+}
+
+func TestRenderBodyReturnsErrorForInvalidJSON(t *testing.T) {
+	d := templateData()
+	d.Query = url.Values{"name": []string{`a"b`}}
+
+	_, err := BodyTemplates{}.render(`{"name": "{{ .Query.Get "name" }}"}`, d)
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "not valid JSON")
+}
+
+func TestRenderBodyUsesParsedTemplates(t *testing.T) {
+	bt, err := NewBodyTemplates("hello world", "{{ .Path }}")
+	require.NoError(t, err)
+
+	// only messages with actions are parsed
+	assert.Len(t, bt, 1)
+
+	b, err := bt.render("{{ .Path }}", templateData())
+	require.NoError(t, err)
+
+	assert.Equal(t, `"/orders/123"`, string(b))
+}
+
+func TestNewBodyTemplatesReturnsErrorForInvalidTemplate(t *testing.T) {
+	_, err := NewBodyTemplates("hello world", "{{ .Params.id ")
+	require.Error(t, err)
+
+	assert.Contains(t, err.Error(), "invalid body template")
+}
+
+func TestRandomIntReturnsMinWhenRangeIsEmpty(t *testing.T) {
+	f := templateFuncs["randomInt"].(func(int, int) int)
+
+	assert.Equal(t, 3, f(3, 3))
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/tracing"
+	"github.com/openzipkin/zipkin-go/model"
+)
+
+// defaultTraceLimit is the number of traces returned when the request does
+// not set a limit, this is the same as the Zipkin API
+const defaultTraceLimit = 10
+
+// Traces defines the handler for the built-in trace collector, it implements
+// the Zipkin v2 API used to send spans and the endpoints used to query
+// traces
+type Traces struct {
+	logger *logging.Logger
+	store  *tracing.SpanStore
+}
+
+// NewTraces creates a new trace collector handler
+func NewTraces(logger *logging.Logger, store *tracing.SpanStore) *Traces {
+	return &Traces{
+		logger: logger,
+		store:  store,
+	}
+}
+
+// HandleSpans adds the spans in the request body to the store, the body is
+// an array of spans in the Zipkin v2 JSON format
+func (t *Traces) HandleSpans(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.Header().Set("Allow", "POST")
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	spans := []model.SpanModel{}
+	err := json.NewDecoder(r.Body).Decode(&spans)
+	if err != nil {
+		t.logger.Log().Error("Unable to decode spans", "error", err)
+		http.Error(rw, "Unable to decode spans: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t.logger.Log().Debug("Received spans", "count", len(spans))
+	t.store.Add(spans)
+
+	rw.WriteHeader(http.StatusAccepted)
+}
+
+// HandleTraces returns the most recent traces, the query parameters
+// serviceName and limit filter the traces
+func (t *Traces) HandleTraces(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := tracing.TraceQuery{
+		ServiceName: r.URL.Query().Get("serviceName"),
+		Limit:       defaultTraceLimit,
+	}
+
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit < 1 {
+			http.Error(rw, "limit must be a number greater than 0", http.StatusBadRequest)
+			return
+		}
+
+		q.Limit = limit
+	}
+
+	t.writeJSON(rw, t.store.Traces(q))
+}
+
+// HandleTrace returns the spans for the trace id at the end of the path
+func (t *Traces) HandleTrace(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := model.TraceIDFromHex(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+	if err != nil {
+		http.Error(rw, "Invalid trace id", http.StatusBadRequest)
+		return
+	}
+
+	spans, ok := t.store.Trace(id)
+	if !ok {
+		http.Error(rw, "Trace not found", http.StatusNotFound)
+		return
+	}
+
+	t.writeJSON(rw, spans)
+}
+
+// HandleServices returns the names of the services which have sent spans
+func (t *Traces) HandleServices(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", "GET")
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t.writeJSON(rw, t.store.Services())
+}
+
+func (t *Traces) writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(v)
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/tracing"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSpans = `[
+  {
+    "traceId": "0af7651916cd43dd8448eb211c80319c",
+    "id": "b7ad6b7169203331",
+    "name": "handle_request",
+    "timestamp": 1700000000000000,
+    "duration": 1500,
+    "localEndpoint": {"serviceName": "web"}
+  },
+  {
+    "traceId": "0af7651916cd43dd8448eb211c80319c",
+    "parentId": "b7ad6b7169203331",
+    "id": "100e369a28d0130c",
+    "name": "call_upstream",
+    "timestamp": 1700000000000100,
+    "duration": 1000,
+    "localEndpoint": {"serviceName": "web"}
+  }
+]`
+
+func setupTraces(t *testing.T) *Traces {
+	th := NewTraces(logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil), tracing.NewSpanStore(10))
+
+	rr := httptest.NewRecorder()
+	th.HandleSpans(rr, httptest.NewRequest(http.MethodPost, "/api/v2/spans", bytes.NewBufferString(testSpans)))
+	require.Equal(t, http.StatusAccepted, rr.Code)
+
+	return th
+}
+
+func TestTracesReturnsReceivedSpans(t *testing.T) {
+	th := setupTraces(t)
+
+	rr := httptest.NewRecorder()
+	th.HandleTraces(rr, httptest.NewRequest(http.MethodGet, "/api/v2/traces?serviceName=web", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	traces := [][]model.SpanModel{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &traces))
+
+	require.Len(t, traces, 1)
+	require.Len(t, traces[0], 2)
+	assert.Equal(t, "handle_request", traces[0][0].Name)
+	assert.Equal(t, "call_upstream", traces[0][1].Name)
+}
+
+func TestTraceReturnsSpansForID(t *testing.T) {
+	th := setupTraces(t)
+
+	rr := httptest.NewRecorder()
+	th.HandleTrace(rr, httptest.NewRequest(http.MethodGet, "/api/v2/trace/0af7651916cd43dd8448eb211c80319c", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	spans := []model.SpanModel{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &spans))
+	assert.Len(t, spans, 2)
+}
+
+func TestTraceReturnsNotFoundForUnknownID(t *testing.T) {
+	th := setupTraces(t)
+
+	rr := httptest.NewRecorder()
+	th.HandleTrace(rr, httptest.NewRequest(http.MethodGet, "/api/v2/trace/1234", nil))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestTracesReturnsServices(t *testing.T) {
+	th := setupTraces(t)
+
+	rr := httptest.NewRecorder()
+	th.HandleServices(rr, httptest.NewRequest(http.MethodGet, "/api/v2/services", nil))
+
+	assert.JSONEq(t, `["web"]`, rr.Body.String())
+}
+
+func TestSpansReturnsBadRequestForInvalidBody(t *testing.T) {
+	th := setupTraces(t)
+
+	rr := httptest.NewRecorder()
+	th.HandleSpans(rr, httptest.NewRequest(http.MethodPost, "/api/v2/spans", bytes.NewBufferString(`{"traceId":`)))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// maxDatagramSize is the largest UDP payload which can be received
+const maxDatagramSize = 65535
+
+// UDP handles datagrams received by the raw UDP listener
+type UDP struct {
+	rawHandler
+}
+
+// NewUDP creates a new UDP handler
+func NewUDP(s Settings, l *logging.Logger) *UDP {
+	u := &UDP{rawHandler{network: "udp", log: l}}
+	u.Update(s)
+
+	return u
+}
+
+// Serve handles the datagrams read from the connection until it is closed,
+// nil is returned when the connection is closed
+func (u *UDP) Serve(pc net.PacketConn) error {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		data := append([]byte{}, buf[:n]...)
+		go u.handle(pc, addr, data)
+	}
+}
+
+// handle responds to a datagram, injected errors drop the datagram without
+// sending a response
+func (u *UDP) handle(pc net.PacketConn, addr net.Addr, data []byte) {
+	st := time.Now()
+	d, ei, s := u.settings()
+
+	hq := u.log.HandleUDPDatagram(addr, len(data))
+	defer hq.Finished()
+
+	hq.SetMetadata("mode", s.Mode)
+	u.log.RawMessage(hq.Span, "udp", "", "received", len(data))
+
+	if err := u.injectError(ei); err != nil {
+		hq.SetError(err)
+		return
+	}
+
+	var responses [][]byte
+	switch s.Mode {
+	case RawEcho:
+		responses = [][]byte{data}
+	case RawPayload:
+		responses = [][]byte{s.Payload}
+	case RawUpstream:
+		var err error
+		responses, err = u.forward(hq, s, data)
+		if err != nil {
+			hq.SetError(err)
+		}
+	}
+
+	u.wait(hq, d, st)
+
+	for _, r := range responses {
+		if _, err := pc.WriteTo(r, addr); err != nil {
+			hq.SetError(err)
+			return
+		}
+
+		u.log.RawMessage(hq.Span, "udp", "", "sent", len(r))
+	}
+}
+
+// forward sends the datagram to the udp:// upstreams, the responses from the
+// upstreams which responded are returned with the first error
+func (u *UDP) forward(hq *logging.LogProcess, s RawSettings, data []byte) ([][]byte, error) {
+	addrs := s.upstreams("udp")
+	responses := make([][]byte, len(addrs))
+	errs := make([]error, len(addrs))
+
+	wg := sync.WaitGroup{}
+	for i, addr := range addrs {
+		wg.Add(1)
+
+		go func(i int, addr string) {
+			defer wg.Done()
+			responses[i], errs[i] = u.call(hq, s.udpTimeout(), "udp://"+addr, addr, data)
+		}(i, addr)
+	}
+
+	wg.Wait()
+
+	received := [][]byte{}
+	var err error
+	for i := range addrs {
+		if errs[i] != nil {
+			if err == nil {
+				err = errs[i]
+			}
+
+			continue
+		}
+
+		received = append(received, responses[i])
+	}
+
+	return received, err
+}
+
+// call sends the datagram to the upstream and returns the first datagram it
+// responds with
+func (u *UDP) call(hq *logging.LogProcess, timeout time.Duration, uri, addr string, data []byte) ([]byte, error) {
+	lp := u.log.CallRawUpstream("udp", uri, hq.Span.Context())
+	defer lp.Finished()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		lp.SetError(err)
+		return nil, fmt.Errorf("unable to connect to upstream %s: %s", uri, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(data); err != nil {
+		lp.SetError(err)
+		return nil, fmt.Errorf("unable to send data to upstream %s: %s", uri, err)
+	}
+
+	u.log.RawMessage(hq.Span, "udp", uri, "sent", len(data))
+
+	buf := make([]byte, maxDatagramSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		lp.SetError(err)
+		return nil, fmt.Errorf("no response from upstream %s: %s", uri, err)
+	}
+
+	u.log.RawMessage(hq.Span, "udp", uri, "received", n)
+
+	return buf[:n], nil
+}
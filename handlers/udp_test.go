@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupUDP(t *testing.T, s Settings) string {
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+
+	go NewUDP(s, l).Serve(pc)
+
+	return pc.LocalAddr().String()
+}
+
+func sendDatagram(t *testing.T, addr string, data string, timeout time.Duration) (string, error) {
+	conn, err := net.Dial("udp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	_, err = conn.Write([]byte(data))
+	require.NoError(t, err)
+
+	d := make([]byte, maxDatagramSize)
+	n, err := conn.Read(d)
+
+	return string(d[:n]), err
+}
+
+func TestUDPEchoesDatagrams(t *testing.T) {
+	addr := setupUDP(t, rawSettings(RawSettings{Mode: RawEcho}, 0))
+
+	d, err := sendDatagram(t, addr, "ping", 5*time.Second)
+	require.NoError(t, err)
+
+	assert.Equal(t, "ping", d)
+}
+
+func TestUDPRespondsWithPayload(t *testing.T) {
+	addr := setupUDP(t, rawSettings(RawSettings{Mode: RawPayload, Payload: []byte("pong")}, 0))
+
+	d, err := sendDatagram(t, addr, "ping", 5*time.Second)
+	require.NoError(t, err)
+
+	assert.Equal(t, "pong", d)
+}
+
+func TestUDPForwardsDatagramsToUpstreams(t *testing.T) {
+	upstream := setupUDP(t, rawSettings(RawSettings{Mode: RawPayload, Payload: []byte("pong")}, 0))
+	addr := setupUDP(t, rawSettings(RawSettings{
+		Mode:         RawUpstream,
+		UpstreamURIs: []string{"udp://" + upstream, "tcp://127.0.0.1:1"},
+	}, 0))
+
+	d, err := sendDatagram(t, addr, "ping", 5*time.Second)
+	require.NoError(t, err)
+
+	assert.Equal(t, "pong", d)
+}
+
+func TestUDPDropsDatagramsWhenErrorInjected(t *testing.T) {
+	addr := setupUDP(t, rawSettings(RawSettings{Mode: RawEcho}, 1))
+
+	_, err := sendDatagram(t, addr, "ping", 100*time.Millisecond)
+	require.Error(t, err)
+
+	assert.True(t, err.(net.Error).Timeout())
+}
+
+func TestUDPUpstreamTimeoutDefaultsWhenNotSet(t *testing.T) {
+	assert.Equal(t, defaultUDPTimeout, RawSettings{}.udpTimeout())
+	assert.Equal(t, time.Second, RawSettings{Timeout: time.Second}.udpTimeout())
+}
+
+func TestUDPCallFailsWhenUpstreamDoesNotRespond(t *testing.T) {
+	// the upstream reads datagrams without responding
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+	u := NewUDP(rawSettings(RawSettings{Mode: RawUpstream}, 0), l)
+	hq := l.HandleUDPDatagram(pc.LocalAddr(), 4)
+	defer hq.Finished()
+
+	addr := pc.LocalAddr().String()
+	_, err = u.call(hq, 10*time.Millisecond, "udp://"+addr, addr, []byte("ping"))
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Upstream defines how an upstream service is called, upstreams which do not
+// have any settings are called with the default client and the generated
+// request body
+type Upstream struct {
+	URI     string
+	Method  string
+	Headers map[string]string
+	// Timeout is applied to gRPC calls, HTTP calls use the timeout of the client
+	Timeout time.Duration
+	// Client used to call HTTP upstreams
+	Client client.HTTP
+	// Retry defines how failed calls are retried, when nil calls are not retried
+	Retry *RetryPolicy
+	// Hedge sends a duplicate call when the upstream is slow, when nil a
+	// single call is made
+	Hedge *HedgePolicy
+	// Optional upstreams do not fail the request when the failure policy is
+	// required
+	Optional bool
+	// CircuitBreaker stops calls while the upstream is failing, when nil
+	// calls are always made
+	CircuitBreaker *CircuitBreaker
+
+	body *template.Template
+}
+
+// RequestData is the data available to the upstream body template
+type RequestData struct {
+	Method  string
+	Path    string
+	Query   url.Values
+	Headers http.Header
+	// Params are the parameters captured from the path by the matching route
+	Params map[string]string
+}
+
+// NewUpstream creates an upstream from the definition
+func NewUpstream(c config.UpstreamCall, httpClient client.HTTP) (*Upstream, error) {
+	u := &Upstream{
+		URI:      c.URI,
+		Method:   c.Method,
+		Headers:  c.Headers,
+		Timeout:  time.Duration(c.Timeout),
+		Client:   httpClient,
+		Optional: c.Optional,
+	}
+
+	if c.Retry != nil {
+		u.Retry = NewRetryPolicy(*c.Retry)
+	}
+
+	if c.Hedge != nil {
+		u.Hedge = NewHedgePolicy(*c.Hedge)
+	}
+
+	if c.Body != "" {
+		t, err := template.New(c.URI).Option("missingkey=zero").Funcs(templateFuncs).Parse(c.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body for upstream %s: %s", c.URI, err)
+		}
+
+		u.body = t
+	}
+
+	return u, nil
+}
+
+// Body returns the request body for the upstream, when the upstream does not
+// define a body the generated body is returned
+func (u *Upstream) Body(d RequestData, generated []byte) ([]byte, error) {
+	if u.body == nil {
+		return generated, nil
+	}
+
+	b := &bytes.Buffer{}
+	if err := u.body.Execute(b, d); err != nil {
+		return nil, fmt.Errorf("unable to render body for upstream %s: %s", u.URI, err)
+	}
+
+	return b.Bytes(), nil
+}
+
+// newRequestData returns the template data for the inbound HTTP request
+func newRequestData(r *http.Request, params map[string]string) RequestData {
+	return RequestData{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.Query(),
+		Headers: r.Header,
+		Params:  params,
+	}
+}
+
+// newGRPCRequestData returns the template data for the inbound gRPC request,
+// the path is the full name of the method and the headers contain the
+// request metadata
+func newGRPCRequestData(ctx context.Context) RequestData {
+	d := RequestData{Headers: http.Header{}}
+	d.Path, _ = grpc.Method(ctx)
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, v := range md {
+		for _, vv := range v {
+			d.Headers.Add(k, vv)
+		}
+	}
+
+	return d
+}
+
+// upstream returns the upstream with the given URI, if the upstream has no
+// settings the default client is used
+func (s Settings) upstream(uri string) *Upstream {
+	if u, ok := s.Upstreams[uri]; ok {
+		return u
+	}
+
+	return &Upstream{URI: uri, Client: s.DefaultClient}
+}
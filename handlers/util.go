@@ -2,35 +2,118 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math/rand"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/config"
 	"github.com/nicholasjackson/fake-service/grpc/api"
 	"github.com/nicholasjackson/fake-service/logging"
 	"github.com/nicholasjackson/fake-service/response"
 	"github.com/nicholasjackson/fake-service/worker"
 	opentracing "github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 const timeFormat = "2006-01-02T15:04:05.000000"
 
-func workerHTTP(ctx opentracing.SpanContext, uri string, defaultClient client.HTTP, pr *http.Request, l *logging.Logger, content []byte) (*response.Response, error) {
-	httpReq, _ := http.NewRequest(http.MethodGet, uri, nil)
+// messageBody returns the message as the body of a response, messages which
+// start with { are JSON and other messages are encoded as a JSON string
+func messageBody(message string) json.RawMessage {
+	if strings.HasPrefix(message, "{") {
+		return json.RawMessage(message)
+	}
+
+	d, _ := json.Marshal(message)
+
+	return json.RawMessage(d)
+}
+
+const payloadCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// randomPayload returns a string of random characters which is size bytes
+func randomPayload(size int) string {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = payloadCharacters[rand.Intn(len(payloadCharacters))]
+	}
+
+	return string(b)
+}
+
+// callUpstream calls the upstream using HTTP or gRPC depending on the URI, h3://
+// upstreams are called using HTTP. Calls are cancelled when ctx is done. Failed calls are retried when the upstream
+// has a retry policy and slow calls are hedged when the upstream has a hedge
+// policy.
+func callUpstream(ctx context.Context, sc opentracing.SpanContext, u *Upstream, pr *http.Request, grpcClients map[string]client.GRPC, l *logging.Logger, content []byte) (*response.Response, error) {
+	call := func(ctx context.Context, sc opentracing.SpanContext) (*response.Response, error) {
+		if strings.HasPrefix(u.URI, "http://") || strings.HasPrefix(u.URI, "https://") || config.IsHTTP3(u.URI) {
+			return workerHTTP(ctx, sc, u, pr, l, content)
+		}
+
+		return workerGRPC(ctx, sc, u, grpcClients, l, content)
+	}
+
+	return callWithRetries(ctx, sc, u, l, func(ctx context.Context, sc opentracing.SpanContext) (*response.Response, error) {
+		return callWithHedging(ctx, sc, u, l, call)
+	})
+}
+
+func workerHTTP(ctx context.Context, sc opentracing.SpanContext, u *Upstream, pr *http.Request, l *logging.Logger, content []byte) (*response.Response, error) {
+	method := u.Method
+	if method == "" {
+		method = http.MethodGet
+		if len(content) > 0 {
+			method = http.MethodPost
+		}
+	}
+
+	var body io.Reader
 	if len(content) > 0 {
-		httpReq, _ = http.NewRequest(http.MethodPost, uri, bytes.NewReader(content))
+		body = bytes.NewReader(content)
+	}
+
+	uri := u.URI
+	httpReq, _ := http.NewRequestWithContext(ctx, method, uri, body)
+
+	// responses are read as JSON, setting Accept stops the header from a
+	// request for server-sent events being appended so upstreams do not
+	// stream events
+	if pr != nil && acceptsEvents(pr) {
+		httpReq.Header.Set("Accept", "application/json")
 	}
 
-	hr := l.CallHTTPUpstream(pr, httpReq, ctx)
+	for k, v := range u.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	hr := l.CallHTTPUpstream(pr, httpReq, sc)
 	defer hr.Finished()
 
-	code, resp, headers, cookies, err := defaultClient.Do(httpReq, pr)
+	done, err := u.CircuitBreaker.Allow()
+	if err != nil {
+		hr.SetMetadata("circuit_breaker", CircuitOpen)
+		hr.SetError(err)
+
+		return &response.Response{URI: uri, Code: -1, Error: err.Error()}, err
+	}
+
+	code, resp, headers, cookies, protocol, err := u.Client.Do(httpReq, pr)
+	done(callResult(ctx, err))
 
 	hr.SetMetadata("response", strconv.Itoa(code))
+	if protocol != "" {
+		hr.SetMetadata("protocol", protocol)
+	}
 	hr.SetError(err)
 
 	r := &response.Response{}
@@ -48,6 +131,7 @@ func workerHTTP(ctx opentracing.SpanContext, uri string, defaultClient client.HT
 
 	// set the local URI for the upstream
 	r.URI = uri
+	r.Protocol = protocol
 	r.Code = code
 	r.Headers = headers
 	r.Cookies = cookies
@@ -59,12 +143,38 @@ func workerHTTP(ctx opentracing.SpanContext, uri string, defaultClient client.HT
 	return r, err
 }
 
-func workerGRPC(ctx opentracing.SpanContext, uri string, grpcClients map[string]client.GRPC, l *logging.Logger, content []byte) (*response.Response, error) {
-	hr, outCtx := l.CallGRCPUpstream(uri, ctx)
+func workerGRPC(ctx context.Context, sc opentracing.SpanContext, u *Upstream, grpcClients map[string]client.GRPC, l *logging.Logger, content []byte) (*response.Response, error) {
+	uri := u.URI
+	hr, spanCtx := l.CallGRCPUpstream(uri, sc)
 	defer hr.Finished()
 
+	// send the trace metadata using the context for the call so that the
+	// call is cancelled with ctx
+	md, _ := metadata.FromOutgoingContext(spanCtx)
+	outCtx := metadata.NewOutgoingContext(ctx, md)
+
+	// send the upstream headers as metadata
+	for k, v := range u.Headers {
+		outCtx = metadata.AppendToOutgoingContext(outCtx, k, v)
+	}
+
+	if u.Timeout > 0 {
+		var cancel context.CancelFunc
+		outCtx, cancel = context.WithTimeout(outCtx, u.Timeout)
+		defer cancel()
+	}
+
+	done, err := u.CircuitBreaker.Allow()
+	if err != nil {
+		hr.SetMetadata("circuit_breaker", CircuitOpen)
+		hr.SetError(err)
+
+		return &response.Response{URI: uri, Type: "gRPC", Code: int(codes.Unavailable), Error: err.Error()}, err
+	}
+
 	c := grpcClients[uri]
 	resp, headers, err := c.Handle(outCtx, &api.Request{Data: content})
+	done(callResult(ctx, err))
 
 	r := &response.Response{}
 	if err != nil {
@@ -109,6 +219,118 @@ func workerGRPC(ctx opentracing.SpanContext, uri string, grpcClients map[string]
 	return r, nil
 }
 
+// callResult returns the result of a call for the circuit breaker, calls which
+// were cancelled because a hedged call completed first or the client
+// disconnected are neither successes nor failures
+func callResult(ctx context.Context, err error) CallResult {
+	switch {
+	case err == nil:
+		return CallSucceeded
+	case ctx.Err() == context.Canceled:
+		return CallCancelled
+	}
+
+	return CallFailed
+}
+
+// callUpstreams calls the upstreams, or the stages of the plan when it is
+// defined, and adds the responses to resp. Failed calls only return an error
+// when the failure policy does not allow them, when calls failed but the
+// request can succeed resp is marked as degraded.
+func (s Settings) callUpstreams(resp *response.Response, uris []string, plan []config.Stage, f worker.WorkFunc) error {
+	results := &upstreamResults{policy: s.FailurePolicy}
+	wf := func(uri string) (*response.Response, error) {
+		r, err := f(uri)
+		return r, results.record(s.upstream(uri).Optional, r, err)
+	}
+
+	var err error
+	if len(plan) > 0 {
+		err = callPlan(resp, newPlan(plan), wf)
+	} else {
+		wp := worker.New(s.WorkerCount, wf)
+		err = wp.Do(uris)
+
+		for _, v := range wp.Responses() {
+			resp.AppendUpstream(v.URI, *v.Response)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err := results.check(); err != nil {
+		return err
+	}
+
+	resp.Degraded = results.degraded()
+
+	return nil
+}
+
+// callPlan runs the stages of the plan, the responses from the upstreams
+// are added to a response for each stage which records the stage timing
+func callPlan(resp *response.Response, p worker.Plan, f worker.WorkFunc) error {
+	results, err := p.Do(f)
+
+	for i, r := range results {
+		sr := response.Response{
+			Name: r.Name,
+			Type: "Stage",
+			Code: http.StatusOK,
+		}
+
+		if r.Skipped {
+			sr.Code = 0
+			sr.Error = "stage skipped, previous stage failed"
+
+			for _, g := range p[i].Groups {
+				for _, uri := range g {
+					sr.AppendUpstream(uri, response.Response{URI: uri, Skipped: true})
+				}
+			}
+		} else {
+			sr.StartTime = r.StartTime.Format(timeFormat)
+			sr.EndTime = r.EndTime.Format(timeFormat)
+			sr.Duration = r.EndTime.Sub(r.StartTime).String()
+		}
+
+		if r.Error != nil {
+			sr.Code = http.StatusInternalServerError
+			sr.Error = r.Error.Error()
+		}
+
+		for _, v := range r.Responses {
+			sr.AppendUpstream(v.URI, *v.Response)
+		}
+
+		resp.AppendUpstream(fmt.Sprintf("stage_%d", i+1), sr)
+	}
+
+	return err
+}
+
+// newPlan converts the stages to a plan of upstream URIs
+func newPlan(stages []config.Stage) worker.Plan {
+	p := worker.Plan{}
+	for i, s := range stages {
+		name := s.Name
+		if name == "" {
+			name = fmt.Sprintf("stage %d", i+1)
+		}
+
+		ws := worker.Stage{Name: name}
+		for _, g := range s.CallGroups() {
+			ws.Groups = append(ws.Groups, config.URIs(g))
+		}
+
+		p = append(p, ws)
+	}
+
+	return p
+}
+
 func processResponses(responses []worker.Done) []byte {
 	respLines := []string{}
 
@@ -0,0 +1,414 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nicholasjackson/fake-service/errors"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// WebSocket modes which decide how messages are sent to the client
+const (
+	// WebSocketEcho sends every message received back to the client
+	WebSocketEcho = "echo"
+	// WebSocketPush sends a message to the client on an interval
+	WebSocketPush = "push"
+	// WebSocketUpstream sends every message received to the upstreams and
+	// sends their responses to the client
+	WebSocketUpstream = "upstream"
+)
+
+// WebSocketSettings define the behaviour of the WebSocket handler
+type WebSocketSettings struct {
+	Mode string
+	// PushInterval is the time between messages in push mode
+	PushInterval time.Duration
+	// MessageSize is the size of the random payload added to pushed messages
+	MessageSize int
+	// Lifetime is the time after which connections are closed, 0 keeps
+	// connections open until the client closes them
+	Lifetime time.Duration
+	// DisconnectAfter closes the connection with DisconnectCode once the
+	// number of messages have been sent, 0 disables the disconnect
+	DisconnectAfter int
+	// DisconnectCode is the close code sent to the client, when 0 the
+	// connection is dropped without sending a close message
+	DisconnectCode int
+	// UpstreamURIs are the ws:// or wss:// URIs messages are sent to in
+	// upstream mode
+	UpstreamURIs []string
+	// Dialer connects to the upstreams
+	Dialer *websocket.Dialer
+}
+
+// WebSocket handles WebSocket connections
+type WebSocket struct {
+	// mutex guards the settings which can be replaced using Update
+	mutex         sync.RWMutex
+	name          string
+	message       string
+	bodyTemplates BodyTemplates
+	websocket     WebSocketSettings
+	log           *logging.Logger
+	upgrader      websocket.Upgrader
+}
+
+// NewWebSocket creates a new WebSocket handler
+func NewWebSocket(name string, s Settings, l *logging.Logger) *WebSocket {
+	ws := &WebSocket{
+		name: name,
+		log:  l,
+		upgrader: websocket.Upgrader{
+			// fake-service is used to test clients from any origin
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+	ws.Update(s)
+
+	return ws
+}
+
+// Update replaces the settings for the handler, connections which are open
+// keep using the previous settings
+func (ws *WebSocket) Update(s Settings) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+
+	ws.message = s.Message
+	ws.bodyTemplates = s.BodyTemplates
+	ws.websocket = s.WebSocket
+}
+
+// settings returns a copy of the current settings
+func (ws *WebSocket) settings() (string, BodyTemplates, WebSocketSettings) {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+
+	return ws.message, ws.bodyTemplates, ws.websocket
+}
+
+// Handle upgrades the request to a WebSocket connection and sends messages to
+// the client until the connection is closed
+func (ws *WebSocket) Handle(rw http.ResponseWriter, r *http.Request) {
+	message, bodyTemplates, s := ws.settings()
+
+	hq := ws.log.HandleWebSocket(r)
+	defer hq.Finished()
+
+	hq.SetMetadata("mode", s.Mode)
+
+	conn, err := ws.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		// the upgrader has returned an error response to the client
+		hq.SetError(err)
+		return
+	}
+	defer conn.Close()
+
+	c := &wsConnection{
+		conn:          conn,
+		name:          ws.name,
+		message:       message,
+		bodyTemplates: bodyTemplates,
+		data:          newRequestData(r, nil),
+		settings:      s,
+		log:           ws.log,
+		hq:            hq,
+		done:          make(chan struct{}),
+	}
+	defer close(c.done)
+
+	err = c.run()
+
+	hq.SetMetadata("response", strconv.Itoa(c.code))
+	if err != nil {
+		hq.SetError(err)
+	}
+}
+
+// wsMessage is a message read from the client or an upstream, uri is empty
+// for messages from the client
+type wsMessage struct {
+	uri         string
+	messageType int
+	data        []byte
+	err         error
+}
+
+// wsUpstream is a connection to an upstream, the span for the upstream is
+// finished when the connection is closed
+type wsUpstream struct {
+	conn *websocket.Conn
+	lp   *logging.LogProcess
+}
+
+// wsConnection is a connection from a client
+type wsConnection struct {
+	conn          *websocket.Conn
+	name          string
+	message       string
+	bodyTemplates BodyTemplates
+	// data is the request which opened the connection, it is used to render
+	// the message
+	data     RequestData
+	settings WebSocketSettings
+	log      *logging.Logger
+	hq       *logging.LogProcess
+
+	// upstreams are the connections to the upstreams keyed by URI
+	upstreams map[string]*wsUpstream
+	// sent is the number of messages sent to the client
+	sent int
+	// code is the close code sent or received
+	code int
+	// done is closed when the connection has been handled
+	done chan struct{}
+}
+
+// run sends messages to the client until the connection is closed, messages
+// are only written by run so that there is a single writer for each
+// connection
+func (c *wsConnection) run() error {
+	messages := make(chan wsMessage)
+	go c.read(c.conn, "", messages)
+
+	if c.settings.Mode == WebSocketUpstream {
+		err := c.dialUpstreams()
+		defer c.closeUpstreams()
+
+		if err != nil {
+			return c.close(websocket.CloseInternalServerErr, err)
+		}
+
+		for uri, u := range c.upstreams {
+			go c.read(u.conn, uri, messages)
+		}
+	}
+
+	var push <-chan time.Time
+	if c.settings.Mode == WebSocketPush {
+		t := time.NewTicker(c.settings.PushInterval)
+		defer t.Stop()
+
+		push = t.C
+	}
+
+	var lifetime <-chan time.Time
+	if c.settings.Lifetime > 0 {
+		t := time.NewTimer(c.settings.Lifetime)
+		defer t.Stop()
+
+		lifetime = t.C
+	}
+
+	for {
+		var err error
+
+		select {
+		case m := <-messages:
+			// the client has closed the connection
+			if m.err != nil && m.uri == "" {
+				return c.clientClosed(m.err)
+			}
+
+			err = c.handleMessage(m)
+		case <-push:
+			err = c.sendResponse(&response.Response{Payload: randomPayload(c.settings.MessageSize)})
+		case <-lifetime:
+			return c.close(websocket.CloseGoingAway, nil)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if c.settings.DisconnectAfter > 0 && c.sent >= c.settings.DisconnectAfter {
+			return c.disconnect()
+		}
+	}
+}
+
+// clientClosed records the close code sent by the client, nil is returned
+// when the client closed the connection normally
+func (c *wsConnection) clientClosed(err error) error {
+	if ce, ok := err.(*websocket.CloseError); ok {
+		c.code = ce.Code
+
+		if websocket.IsCloseError(ce, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// handleMessage handles a message read from the client or an upstream
+func (c *wsConnection) handleMessage(m wsMessage) error {
+	// an upstream has closed the connection
+	if m.err != nil {
+		return c.close(websocket.CloseInternalServerErr, fmt.Errorf("upstream %s closed the connection: %s", m.uri, m.err))
+	}
+
+	c.log.WebSocketMessage(c.hq.Span, m.uri, "received", len(m.data))
+
+	// a response from an upstream
+	if m.uri != "" {
+		return c.sendResponse(&response.Response{
+			UpstreamCalls: map[string]response.Response{m.uri: upstreamMessage(m)},
+		})
+	}
+
+	switch c.settings.Mode {
+	case WebSocketEcho:
+		return c.send(m.messageType, m.data)
+	case WebSocketUpstream:
+		for uri, u := range c.upstreams {
+			if err := u.conn.WriteMessage(m.messageType, m.data); err != nil {
+				return c.close(websocket.CloseInternalServerErr, fmt.Errorf("unable to send message to upstream %s: %s", uri, err))
+			}
+
+			c.log.WebSocketMessage(c.hq.Span, uri, "sent", len(m.data))
+		}
+	}
+
+	return nil
+}
+
+// upstreamMessage returns the response for a message from an upstream, the
+// message is used as the response when it is one
+func upstreamMessage(m wsMessage) response.Response {
+	r := response.Response{}
+	if err := r.FromJSON(m.data); err != nil || r.Name == "" {
+		d, _ := json.Marshal(string(m.data))
+		r = response.Response{Body: d}
+	}
+
+	r.URI = m.uri
+
+	return r
+}
+
+// read sends the messages read from the connection to messages until an
+// error is returned, the error is also sent
+func (c *wsConnection) read(conn *websocket.Conn, uri string, messages chan<- wsMessage) {
+	for {
+		mt, data, err := conn.ReadMessage()
+
+		select {
+		case messages <- wsMessage{uri: uri, messageType: mt, data: data, err: err}:
+		case <-c.done:
+			return
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sendResponse sends the response as a JSON message to the client, the
+// connection is closed when the message can not be rendered
+func (c *wsConnection) sendResponse(r *response.Response) error {
+	r.Name = c.name
+	r.Type = "WebSocket"
+	r.Peer = c.hq.Peer
+	r.Sequence = c.sent + 1
+
+	body, err := c.bodyTemplates.render(c.message, newResponseData(c.data, r))
+	if err != nil {
+		return c.close(websocket.CloseInternalServerErr, err)
+	}
+
+	r.Body = body
+
+	return c.send(websocket.TextMessage, []byte(r.ToJSON()))
+}
+
+// send a message to the client
+func (c *wsConnection) send(messageType int, data []byte) error {
+	if err := c.conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+
+	c.sent++
+	c.log.WebSocketMessage(c.hq.Span, "", "sent", len(data))
+
+	return nil
+}
+
+// close sends a close message to the client, the error is returned so that it
+// is recorded for the connection
+func (c *wsConnection) close(code int, err error) error {
+	c.code = code
+
+	reason := ""
+	if err != nil {
+		reason = err.Error()
+	}
+
+	// the reason must fit in a control frame
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+
+	return err
+}
+
+// disconnect closes the connection with the configured close code, or drops it
+// when the code is 0
+func (c *wsConnection) disconnect() error {
+	c.log.RequestErrorInjected("websocket", c.settings.DisconnectCode)
+
+	if c.settings.DisconnectCode == 0 {
+		c.code = 0
+		return errors.ErrorInjection
+	}
+
+	return c.close(c.settings.DisconnectCode, errors.ErrorInjection)
+}
+
+// dialUpstreams connects to the upstreams, the trace context is sent to the
+// upstreams when connecting
+func (c *wsConnection) dialUpstreams() error {
+	c.upstreams = map[string]*wsUpstream{}
+
+	for _, uri := range c.settings.UpstreamURIs {
+		header := http.Header{}
+		up := c.log.CallWebSocketUpstream(uri, header, c.hq.Span.Context())
+
+		conn, resp, err := c.settings.Dialer.Dial(uri, header)
+		if resp != nil {
+			up.SetMetadata("response", strconv.Itoa(resp.StatusCode))
+		}
+
+		if err != nil {
+			up.SetError(err)
+			up.Finished()
+
+			return fmt.Errorf("unable to connect to upstream %s: %s", uri, err)
+		}
+
+		c.upstreams[uri] = &wsUpstream{conn: conn, lp: up}
+	}
+
+	return nil
+}
+
+// closeUpstreams sends a close message to the upstreams and closes the
+// connections
+func (c *wsConnection) closeUpstreams() {
+	for _, u := range c.upstreams {
+		u.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		u.conn.Close()
+		u.lp.Finished()
+	}
+}
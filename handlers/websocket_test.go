@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupWebSocket(t *testing.T, name string, s WebSocketSettings) string {
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+	s.Dialer = websocket.DefaultDialer
+
+	ts := httptest.NewServer(http.HandlerFunc(NewWebSocket(name, Settings{Message: "hello world", WebSocket: s}, l).Handle))
+	t.Cleanup(ts.Close)
+
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func dialWebSocket(t *testing.T, uri string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial(uri, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	return conn
+}
+
+func readResponse(t *testing.T, conn *websocket.Conn) response.Response {
+	_, d, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	mr := response.Response{}
+	require.NoError(t, mr.FromJSON(d))
+
+	return mr
+}
+
+func TestWebSocketEchoesMessages(t *testing.T) {
+	conn := dialWebSocket(t, setupWebSocket(t, "test", WebSocketSettings{Mode: WebSocketEcho}))
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("ping")))
+
+	mt, d, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	assert.Equal(t, websocket.TextMessage, mt)
+	assert.Equal(t, "ping", string(d))
+}
+
+func TestWebSocketPushesMessages(t *testing.T) {
+	conn := dialWebSocket(t, setupWebSocket(t, "test", WebSocketSettings{Mode: WebSocketPush, PushInterval: time.Millisecond, MessageSize: 16}))
+
+	for i := 1; i <= 3; i++ {
+		mr := readResponse(t, conn)
+
+		assert.Equal(t, "test", mr.Name)
+		assert.Equal(t, "WebSocket", mr.Type)
+		assert.Equal(t, i, mr.Sequence)
+		assert.Len(t, mr.Payload, 16)
+	}
+}
+
+func TestWebSocketIsClosedAfterLifetime(t *testing.T) {
+	conn := dialWebSocket(t, setupWebSocket(t, "test", WebSocketSettings{Mode: WebSocketEcho, Lifetime: 10 * time.Millisecond}))
+
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+}
+
+func TestWebSocketDisconnectsAfterMessages(t *testing.T) {
+	conn := dialWebSocket(t, setupWebSocket(t, "test", WebSocketSettings{
+		Mode:            WebSocketPush,
+		PushInterval:    time.Millisecond,
+		DisconnectAfter: 2,
+		DisconnectCode:  websocket.CloseTryAgainLater,
+	}))
+
+	readResponse(t, conn)
+	readResponse(t, conn)
+
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseTryAgainLater), err)
+}
+
+func TestWebSocketDropsConnectionWhenDisconnectCodeIsZero(t *testing.T) {
+	conn := dialWebSocket(t, setupWebSocket(t, "test", WebSocketSettings{Mode: WebSocketEcho, DisconnectAfter: 1}))
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("ping")))
+
+	_, _, err := conn.ReadMessage()
+	require.NoError(t, err)
+
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseAbnormalClosure), err)
+}
+
+func TestWebSocketSendsMessagesToUpstreams(t *testing.T) {
+	api := setupWebSocket(t, "api", WebSocketSettings{Mode: WebSocketEcho})
+	payments := setupWebSocket(t, "payments", WebSocketSettings{Mode: WebSocketEcho})
+
+	conn := dialWebSocket(t, setupWebSocket(t, "web", WebSocketSettings{Mode: WebSocketUpstream, UpstreamURIs: []string{api, payments}}))
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("ping")))
+
+	received := map[string]string{}
+	for i := 0; i < 2; i++ {
+		mr := readResponse(t, conn)
+		assert.Equal(t, "web", mr.Name)
+
+		for uri, r := range mr.UpstreamCalls {
+			received[uri] = string(r.Body)
+		}
+	}
+
+	assert.Equal(t, map[string]string{api: `"ping"`, payments: `"ping"`}, received)
+}
+
+func TestWebSocketClosesConnectionWhenUpstreamIsUnavailable(t *testing.T) {
+	conn := dialWebSocket(t, setupWebSocket(t, "web", WebSocketSettings{Mode: WebSocketUpstream, UpstreamURIs: []string{"ws://127.0.0.1:1/ws"}}))
+
+	_, _, err := conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseInternalServerErr), err)
+}
+
+func TestWebSocketHandlerReturnsWhenClientCloses(t *testing.T) {
+	// the upstream records when the connection from the handler is closed
+	upstreamClosed := make(chan error, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(rw, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				upstreamClosed <- err
+				return
+			}
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+	ws := NewWebSocket("web", Settings{
+		Message: "hello world",
+		WebSocket: WebSocketSettings{
+			Mode:         WebSocketUpstream,
+			UpstreamURIs: []string{"ws" + strings.TrimPrefix(upstream.URL, "http")},
+			Dialer:       websocket.DefaultDialer,
+		},
+	}, l)
+
+	handled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ws.Handle(rw, r)
+		close(handled)
+	}))
+	t.Cleanup(ts.Close)
+
+	conn := dialWebSocket(t, "ws"+strings.TrimPrefix(ts.URL, "http"))
+
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	require.NoError(t, conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)))
+
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler did not return after the client closed the connection")
+	}
+
+	select {
+	case err := <-upstreamClosed:
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream connection was not closed")
+	}
+}
+
+func TestWebSocketRendersBodyTemplate(t *testing.T) {
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+	ws := NewWebSocket("test", Settings{
+		Message:   `{"name": {{ json (.Query.Get "name") }}}`,
+		WebSocket: WebSocketSettings{Mode: WebSocketPush, PushInterval: time.Millisecond},
+	}, l)
+
+	ts := httptest.NewServer(http.HandlerFunc(ws.Handle))
+	t.Cleanup(ts.Close)
+
+	conn := dialWebSocket(t, "ws"+strings.TrimPrefix(ts.URL, "http")+"?name=nic")
+
+	assert.JSONEq(t, `{"name": "nic"}`, string(readResponse(t, conn).Body))
+}
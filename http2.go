@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/soheilhy/cmux"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// muxListeners returns the listeners for the HTTP and gRPC servers, gRPC
+// requests are HTTP/2 with a gRPC content type and all other requests,
+// including HTTP/2 requests, are served by the HTTP server
+func muxListeners(m cmux.CMux) ([]net.Listener, net.Listener) {
+	// PATCH is not one of the default methods matched by cmux, it is used by
+	// the admin API
+	httpListener := m.Match(cmux.HTTP1Fast(http.MethodPatch))
+	// the settings are sent before matching as gRPC clients wait for them
+	// before sending the headers
+	grpcListener := m.MatchWithWriters(cmux.HTTP2MatchHeaderFieldPrefixSendSettings("content-type", "application/grpc"))
+	http2Listener := &settingsAckListener{m.Match(cmux.Any())}
+
+	return []net.Listener{httpListener, http2Listener}, grpcListener
+}
+
+// withHTTP2 serves HTTP/2 requests using h2c, the connection is wrapped by
+// cmux so the server can not use the protocol negotiated by TLS to serve
+// HTTP/2. Connections using prior knowledge and HTTP/1.1 connections which
+// are upgraded are served for both TLS connections and connections which are
+// not encrypted.
+func withHTTP2(next http.Handler, idleTimeout time.Duration) http.Handler {
+	return h2c.NewHandler(next, &http2.Server{IdleTimeout: idleTimeout})
+}
+
+// settingsAckListener accepts the HTTP/2 connections which were not matched as
+// gRPC, cmux has sent settings to the client when matching the connection so
+// the acknowledgement for them is removed
+type settingsAckListener struct {
+	net.Listener
+}
+
+// Accept implements the net.Listener interface
+func (l *settingsAckListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &settingsAckConn{Conn: c, r: bufio.NewReader(c)}, nil
+}
+
+// settingsAckConn removes the first settings acknowledgement sent by the
+// client, the HTTP/2 server returns a protocol error when it receives an
+// acknowledgement for settings which it did not send
+type settingsAckConn struct {
+	net.Conn
+	r *bufio.Reader
+	// pending is the data read from the connection which has not been returned
+	pending []byte
+	// preface is true once the client preface has been read
+	preface bool
+	// removed is true once the acknowledgement has been removed, or when the
+	// connection is not HTTP/2
+	removed bool
+}
+
+// Read implements the net.Conn interface, frames are read until the
+// acknowledgement has been removed
+func (c *settingsAckConn) Read(b []byte) (int, error) {
+	for len(c.pending) == 0 && !c.removed {
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+
+		return n, nil
+	}
+
+	return c.r.Read(b)
+}
+
+// next reads the client preface or the next frame from the connection
+func (c *settingsAckConn) next() error {
+	if !c.preface {
+		p, err := c.r.Peek(len(http2.ClientPreface))
+		if err != nil || string(p) != http2.ClientPreface {
+			// the connection is not HTTP/2 with prior knowledge
+			c.removed = true
+			return nil
+		}
+
+		c.pending = make([]byte, len(p))
+		c.r.Read(c.pending)
+		c.preface = true
+
+		return nil
+	}
+
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return err
+	}
+
+	// frame header is a 24 bit length, the type, the flags and the stream
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	if http2.FrameType(header[3]) == http2.FrameSettings && http2.Flags(header[4]).Has(http2.FlagSettingsAck) {
+		c.removed = true
+		return nil
+	}
+
+	frame := make([]byte, len(header)+length)
+	copy(frame, header)
+	if _, err := io.ReadFull(c.r, frame[len(header):]); err != nil {
+		return err
+	}
+
+	c.pending = frame
+
+	return nil
+}
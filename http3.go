@@ -0,0 +1,28 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// createHTTP3Server creates the server for HTTP/3 requests, HTTP/3 uses QUIC
+// which is served over UDP and is always encrypted using the TLS config. The
+// handler is set when the HTTP server is created.
+func createHTTP3Server(addr string, tlsConfig *tls.Config) *http3.Server {
+	return &http3.Server{
+		Addr:      addr,
+		TLSConfig: tlsConfig,
+	}
+}
+
+// withAltSvc adds the Alt-Svc header to responses so that clients know they
+// can make requests using HTTP/3
+func withAltSvc(next http.Handler, s *http3.Server) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		s.SetQuicHeaders(rw.Header())
+
+		next.ServeHTTP(rw, r)
+	})
+}
@@ -2,24 +2,34 @@ package logging
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptrace"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/response"
 	"github.com/nicholasjackson/fake-service/tracing"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/opentracing/opentracing-go/log"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 type Logger struct {
 	metrics        Metrics
 	log            hclog.Logger
 	getSpanDetails tracing.SpanDetailsFunc
+
+	// inFlight is the number of requests being handled for each service type
+	mutex    sync.Mutex
+	inFlight map[string]int
 }
 
 func NewLogger(m Metrics, l hclog.Logger, sdf tracing.SpanDetailsFunc) *Logger {
@@ -27,6 +37,7 @@ func NewLogger(m Metrics, l hclog.Logger, sdf tracing.SpanDetailsFunc) *Logger {
 		metrics:        m,
 		log:            l,
 		getSpanDetails: sdf,
+		inFlight:       map[string]int{},
 	}
 }
 
@@ -36,6 +47,8 @@ type LogProcess struct {
 	err      error
 	metadata map[string]string
 	Span     opentracing.Span
+	// Peer is the identity of the client when it presented a certificate
+	Peer *response.PeerCertificate
 }
 
 // SetError for the current operation
@@ -78,6 +91,7 @@ func (l *Logger) ServiceStarted(name, upstreamURIs string, upstreamWorkers int,
 func (l *Logger) HandleHTTPRequest(r *http.Request) *LogProcess {
 	// create the start time
 	st := time.Now()
+	l.requestInFlight("http", 1)
 
 	// attempt to create a span using a parent span defined in http headers
 	var serverSpan opentracing.Span
@@ -99,10 +113,12 @@ func (l *Logger) HandleHTTPRequest(r *http.Request) *LogProcess {
 		ext.RPCServerOption(wireContext))
 	serverSpan.LogFields(log.String("service.type", "http"))
 
+	pc := response.NewPeerCertificate(r.TLS)
+
 	l.log.Info("Handle inbound request",
 		l.logFieldsWithSpanID(
 			serverSpan.Context(),
-			"request", formatRequest(r),
+			append([]interface{}{"request", formatRequest(r)}, peerFields(pc)...)...,
 		)...,
 	)
 
@@ -143,13 +159,16 @@ func (l *Logger) HandleHTTPRequest(r *http.Request) *LogProcess {
 
 			serverSpan.Finish()
 			l.metrics.Timing("handle.request.http", dur, getTags(err, meta))
+			l.requestInFlight("http", -1)
 		},
 		Span: serverSpan,
+		Peer: pc,
 	}
 }
 
 func (l *Logger) HandleGRCPRequest(ctx context.Context) *LogProcess {
 	st := time.Now()
+	l.requestInFlight("grpc", 1)
 
 	// we need to convert the metadata to a httpRequest to extract the span
 	md, _ := metadata.FromIncomingContext(ctx)
@@ -174,11 +193,13 @@ func (l *Logger) HandleGRCPRequest(ctx context.Context) *LogProcess {
 
 	serverSpan.LogFields(log.String("service.type", "grpc"))
 
+	pc := response.NewPeerCertificate(grpcConnectionState(ctx))
+
 	l.log.Info(
 		"Handling request gRPC request",
 		l.logFieldsWithSpanID(
 			serverSpan.Context(),
-			"context", printContext(ctx),
+			append([]interface{}{"context", printContext(ctx)}, peerFields(pc)...)...,
 		)...,
 	)
 
@@ -218,11 +239,330 @@ func (l *Logger) HandleGRCPRequest(ctx context.Context) *LogProcess {
 
 			serverSpan.Finish()
 			l.metrics.Timing("handle.request.grpc", dur, getTags(err, meta))
+			l.requestInFlight("grpc", -1)
+		},
+		Span: serverSpan,
+		Peer: pc,
+	}
+}
+
+// HandleWebSocket creates the span and timing metrics for a WebSocket
+// connection, the span is finished when the connection is closed
+func (l *Logger) HandleWebSocket(r *http.Request) *LogProcess {
+	st := time.Now()
+	l.requestInFlight("websocket", 1)
+
+	wireContext, err := opentracing.GlobalTracer().Extract(
+		opentracing.HTTPHeaders,
+		opentracing.HTTPHeadersCarrier(r.Header),
+	)
+
+	if err != nil {
+		l.log.Debug("Error obtaining context, creating new span", "error", err)
+	}
+
+	serverSpan := opentracing.StartSpan(
+		"handle_websocket",
+		ext.RPCServerOption(wireContext))
+	serverSpan.LogFields(log.String("service.type", "websocket"))
+
+	pc := response.NewPeerCertificate(r.TLS)
+
+	l.log.Info("Handling WebSocket connection",
+		l.logFieldsWithSpanID(
+			serverSpan.Context(),
+			append([]interface{}{"request", formatRequest(r)}, peerFields(pc)...)...,
+		)...,
+	)
+
+	return &LogProcess{
+		finished: func(err error, meta map[string]string) {
+			te := time.Now()
+
+			if err != nil {
+				serverSpan.SetTag("error", true)
+				serverSpan.LogFields(log.Error(err))
+
+				l.log.Error(
+					"Error handling WebSocket connection",
+					l.logFieldsWithSpanID(
+						serverSpan.Context(),
+						"error", err,
+					)...,
+				)
+			}
+
+			// add metadata to the trace and stats
+			for k, v := range meta {
+				serverSpan.SetTag(k, v)
+			}
+
+			dur := te.Sub(st)
+
+			l.log.Info(
+				"Closed WebSocket connection",
+				l.logFieldsWithSpanID(
+					serverSpan.Context(),
+					"duration", dur,
+				)...,
+			)
+
+			serverSpan.Finish()
+			l.metrics.Timing("handle.websocket", dur, getTags(err, meta))
+			l.requestInFlight("websocket", -1)
+		},
+		Span: serverSpan,
+		Peer: pc,
+	}
+}
+
+// WebSocketMessage logs a message sent or received on a WebSocket connection,
+// direction is either sent or received
+func (l *Logger) WebSocketMessage(parentSpan opentracing.Span, uri, direction string, size int) {
+	parentSpan.LogFields(
+		log.String("message.direction", direction),
+		log.Int("message.size", size),
+	)
+
+	l.log.Debug(
+		"WebSocket message",
+		l.logFieldsWithSpanID(
+			parentSpan.Context(),
+			"uri", uri,
+			"direction", direction,
+			"size", size,
+		)...,
+	)
+
+	l.metrics.Increment("websocket.message", []string{fmt.Sprintf("direction:%s", direction)})
+}
+
+// SendEvent logs a server-sent event written to the response
+func (l *Logger) SendEvent(parentSpan opentracing.Span, event string, id int) {
+	parentSpan.LogFields(
+		log.String("event", event),
+		log.Int("event.id", id),
+	)
+
+	l.log.Debug(
+		"Sent event",
+		l.logFieldsWithSpanID(
+			parentSpan.Context(),
+			"event", event,
+			"id", id,
+		)...,
+	)
+
+	l.metrics.Increment("handle.request.sse.event", []string{fmt.Sprintf("event:%s", event)})
+}
+
+// CallWebSocketUpstream creates the span for a connection to an upstream
+// WebSocket service, the span context is added to the headers sent when
+// connecting
+func (l *Logger) CallWebSocketUpstream(uri string, header http.Header, ctx opentracing.SpanContext) *LogProcess {
+	st := time.Now()
+
+	clientSpan := opentracing.StartSpan(
+		"call_upstream",
+		opentracing.ChildOf(ctx),
+	)
+
+	clientSpan.LogFields(log.String("upstream.type", "websocket"))
+
+	ext.SpanKindRPCClient.Set(clientSpan)
+	ext.HTTPUrl.Set(clientSpan, uri)
+
+	opentracing.GlobalTracer().Inject(
+		clientSpan.Context(),
+		opentracing.HTTPHeaders,
+		opentracing.HTTPHeadersCarrier(header))
+
+	l.log.Info(
+		"Calling upstream service",
+		l.logFieldsWithSpanID(
+			ctx,
+			"uri", uri,
+			"type", "WebSocket",
+		)...,
+	)
+
+	return &LogProcess{
+		finished: func(err error, meta map[string]string) {
+			te := time.Now()
+
+			if err != nil {
+				clientSpan.SetTag("error", true)
+				clientSpan.LogFields(log.Error(err))
+
+				l.log.Error(
+					"Error processing upstream request",
+					l.logFieldsWithSpanID(
+						clientSpan.Context(),
+						"error", err,
+					)...,
+				)
+			}
+
+			// add metadata to the trace and stats
+			for k, v := range meta {
+				clientSpan.SetTag(k, v)
+			}
+
+			tags := append(getTags(err, meta), fmt.Sprintf("uri:%s", uri))
+			l.metrics.Timing("upstream.websocket", te.Sub(st), tags)
+			clientSpan.Finish()
+		},
+		Span: clientSpan,
+	}
+}
+
+// HandleTCPConnection creates the span and timing metrics for a connection
+// to the TCP listener, the span is finished when the connection is closed
+func (l *Logger) HandleTCPConnection(remote net.Addr) *LogProcess {
+	return l.handleRaw("tcp", "Handling TCP connection", "Closed TCP connection", remote, 0)
+}
+
+// HandleUDPDatagram creates the span and timing metrics for a datagram
+// received by the UDP listener
+func (l *Logger) HandleUDPDatagram(remote net.Addr, size int) *LogProcess {
+	return l.handleRaw("udp", "Handling UDP datagram", "Finished handling UDP datagram", remote, size)
+}
+
+// handleRaw creates the span and timing metrics for TCP connections and UDP
+// datagrams, network is either tcp or udp
+func (l *Logger) handleRaw(network, started, finished string, remote net.Addr, size int) *LogProcess {
+	st := time.Now()
+	l.requestInFlight(network, 1)
+
+	// raw connections do not carry trace context so a new trace is started
+	serverSpan := opentracing.StartSpan(
+		"handle_"+network,
+		ext.SpanKindRPCServer)
+	serverSpan.LogFields(log.String("service.type", network))
+
+	fields := []interface{}{"remote", remote.String()}
+	if size > 0 {
+		fields = append(fields, "size", size)
+	}
+
+	l.log.Info(started, l.logFieldsWithSpanID(serverSpan.Context(), fields...)...)
+
+	return &LogProcess{
+		finished: func(err error, meta map[string]string) {
+			te := time.Now()
+
+			if err != nil {
+				serverSpan.SetTag("error", true)
+				serverSpan.LogFields(log.Error(err))
+
+				l.log.Error(
+					"Error handling "+strings.ToUpper(network),
+					l.logFieldsWithSpanID(
+						serverSpan.Context(),
+						"error", err,
+					)...,
+				)
+			}
+
+			// add metadata to the trace and stats
+			for k, v := range meta {
+				serverSpan.SetTag(k, v)
+			}
+
+			dur := te.Sub(st)
+
+			l.log.Info(
+				finished,
+				l.logFieldsWithSpanID(
+					serverSpan.Context(),
+					"duration", dur,
+				)...,
+			)
+
+			serverSpan.Finish()
+			l.metrics.Timing("handle."+network, dur, getTags(err, meta))
+			l.requestInFlight(network, -1)
 		},
 		Span: serverSpan,
 	}
 }
 
+// RawMessage logs data sent or received on a TCP connection or as a UDP
+// datagram, direction is either sent or received and uri is empty for the
+// client
+func (l *Logger) RawMessage(parentSpan opentracing.Span, network, uri, direction string, size int) {
+	parentSpan.LogFields(
+		log.String("message.direction", direction),
+		log.Int("message.size", size),
+	)
+
+	l.log.Debug(
+		strings.ToUpper(network)+" message",
+		l.logFieldsWithSpanID(
+			parentSpan.Context(),
+			"uri", uri,
+			"direction", direction,
+			"size", size,
+		)...,
+	)
+
+	l.metrics.Increment(network+".message", []string{fmt.Sprintf("direction:%s", direction)})
+}
+
+// CallRawUpstream creates the span for a connection to an upstream TCP
+// service or a datagram sent to an upstream UDP service
+func (l *Logger) CallRawUpstream(network, uri string, ctx opentracing.SpanContext) *LogProcess {
+	st := time.Now()
+
+	clientSpan := opentracing.StartSpan(
+		"call_upstream",
+		opentracing.ChildOf(ctx),
+	)
+
+	clientSpan.LogFields(log.String("upstream.type", network))
+
+	ext.SpanKindRPCClient.Set(clientSpan)
+	ext.PeerService.Set(clientSpan, uri)
+
+	l.log.Info(
+		"Calling upstream service",
+		l.logFieldsWithSpanID(
+			ctx,
+			"uri", uri,
+			"type", strings.ToUpper(network),
+		)...,
+	)
+
+	return &LogProcess{
+		finished: func(err error, meta map[string]string) {
+			te := time.Now()
+
+			if err != nil {
+				clientSpan.SetTag("error", true)
+				clientSpan.LogFields(log.Error(err))
+
+				l.log.Error(
+					"Error processing upstream request",
+					l.logFieldsWithSpanID(
+						clientSpan.Context(),
+						"error", err,
+					)...,
+				)
+			}
+
+			// add metadata to the trace and stats
+			for k, v := range meta {
+				clientSpan.SetTag(k, v)
+			}
+
+			tags := append(getTags(err, meta), fmt.Sprintf("uri:%s", uri))
+			l.metrics.Timing("upstream."+network, te.Sub(st), tags)
+			clientSpan.Finish()
+		},
+		Span: clientSpan,
+	}
+}
+
 // Logs data about service duration simulation
 func (l *Logger) SleepService(parentSpan opentracing.Span, d time.Duration) *LogProcess {
 	sp := parentSpan.Tracer().StartSpan(
@@ -260,7 +600,8 @@ func (l *Logger) CallHTTPUpstream(parentRequest *http.Request, upstreamRequest *
 	clientSpan.LogFields(log.String("upstream.type", "http"))
 
 	ext.SpanKindRPCClient.Set(clientSpan)
-	ext.HTTPUrl.Set(clientSpan, upstreamRequest.URL.String())
+	uri := upstreamRequest.URL.String()
+	ext.HTTPUrl.Set(clientSpan, uri)
 	ext.HTTPMethod.Set(clientSpan, upstreamRequest.Method)
 
 	// Transmit the span's TraceContext as HTTP headers on our
@@ -322,12 +663,68 @@ func (l *Logger) CallHTTPUpstream(parentRequest *http.Request, upstreamRequest *
 				clientSpan.SetTag(k, v)
 			}
 
-			l.metrics.Timing("upstream.request.http", te.Sub(st), getTags(err, meta))
+			tags := append(getTags(err, meta), fmt.Sprintf("uri:%s", uri))
+			l.metrics.Timing("upstream.request.http", te.Sub(st), tags)
 			clientSpan.Finish()
 		},
+		Span: clientSpan,
 	}
 }
 
+// CallUpstreamWithRetries creates a span for an upstream call which is
+// retried, the span for each attempt is a child of this span
+func (l *Logger) CallUpstreamWithRetries(uri string, ctx opentracing.SpanContext) *LogProcess {
+	st := time.Now()
+
+	sp := opentracing.StartSpan(
+		"call_upstream_with_retries",
+		opentracing.ChildOf(ctx),
+	)
+
+	sp.LogFields(log.String("upstream.uri", uri))
+
+	return &LogProcess{
+		finished: func(err error, meta map[string]string) {
+			te := time.Now()
+
+			if err != nil {
+				sp.SetTag("error", true)
+				sp.LogFields(log.Error(err))
+			}
+
+			// add metadata to the trace and stats
+			for k, v := range meta {
+				sp.SetTag(k, v)
+			}
+
+			l.metrics.Timing("upstream.request.retries", te.Sub(st), getTags(err, meta))
+			sp.Finish()
+		},
+		Span: sp,
+	}
+}
+
+// RetryUpstream logs that a failed upstream call is being retried after
+// the backoff interval
+func (l *Logger) RetryUpstream(parentSpan opentracing.Span, uri string, attempt int, backoff time.Duration) {
+	parentSpan.LogFields(
+		log.Int("retry.attempt", attempt),
+		log.String("retry.backoff", backoff.String()),
+	)
+
+	l.log.Info(
+		"Retrying upstream request",
+		l.logFieldsWithSpanID(
+			parentSpan.Context(),
+			"uri", uri,
+			"attempt", attempt,
+			"backoff", backoff.String(),
+		)...,
+	)
+
+	l.metrics.Increment("upstream.request.retry", []string{fmt.Sprintf("uri:%s", uri)})
+}
+
 // Logs data regarding upstream grpc requests
 // returns a context containing span context for tracing
 func (l *Logger) CallGRCPUpstream(uri string, ctx opentracing.SpanContext) (*LogProcess, context.Context) {
@@ -388,12 +785,111 @@ func (l *Logger) CallGRCPUpstream(uri string, ctx opentracing.SpanContext) (*Log
 				clientSpan.SetTag(k, v)
 			}
 
-			l.metrics.Timing("upstream.request.grpc", te.Sub(st), getTags(err, meta))
+			tags := append(getTags(err, meta), fmt.Sprintf("uri:%s", uri))
+			l.metrics.Timing("upstream.request.grpc", te.Sub(st), tags)
 			clientSpan.Finish()
 		},
+		Span: clientSpan,
 	}, outCtx
 }
 
+// CallUpstreamHedged creates a span for an upstream call which can be hedged,
+// the spans for the original and duplicate calls are children of this span
+func (l *Logger) CallUpstreamHedged(uri string, ctx opentracing.SpanContext) *LogProcess {
+	st := time.Now()
+
+	sp := opentracing.StartSpan(
+		"call_upstream_hedged",
+		opentracing.ChildOf(ctx),
+	)
+
+	sp.LogFields(log.String("upstream.uri", uri))
+
+	return &LogProcess{
+		finished: func(err error, meta map[string]string) {
+			te := time.Now()
+
+			if err != nil {
+				sp.SetTag("error", true)
+				sp.LogFields(log.Error(err))
+			}
+
+			// add metadata to the trace and stats
+			for k, v := range meta {
+				sp.SetTag(k, v)
+			}
+
+			l.metrics.Timing("upstream.request.hedged", te.Sub(st), getTags(err, meta))
+			sp.Finish()
+		},
+		Span: sp,
+	}
+}
+
+// HedgeUpstream logs that a duplicate call is sent to an upstream which has
+// not responded within the delay
+func (l *Logger) HedgeUpstream(parentSpan opentracing.Span, uri string, delay time.Duration) {
+	parentSpan.LogFields(log.String("hedge.delay", delay.String()))
+
+	l.log.Info(
+		"Sending hedged upstream request",
+		l.logFieldsWithSpanID(
+			parentSpan.Context(),
+			"uri", uri,
+			"delay", delay.String(),
+		)...,
+	)
+
+	l.metrics.Increment("upstream.request.hedge", []string{fmt.Sprintf("uri:%s", uri)})
+}
+
+// CircuitBreakerStateChanged logs the change of state for the circuit breaker
+// of an upstream, the metric is 1 while the circuit breaker is open
+func (l *Logger) CircuitBreakerStateChanged(uri, from, to string) {
+	l.log.Warn(
+		"Circuit breaker state changed",
+		"uri", uri,
+		"from", from,
+		"to", to,
+	)
+
+	tags := []string{fmt.Sprintf("uri:%s", uri)}
+	l.metrics.Increment("upstream.circuit_breaker.state_change", append(tags, fmt.Sprintf("state:%s", to)))
+
+	open := 0.0
+	if to == "open" {
+		open = 1
+	}
+
+	l.metrics.Gauge("upstream.circuit_breaker.open", open, tags)
+}
+
+// RequestErrorInjected records an inbound request which returned an
+// injected error
+func (l *Logger) RequestErrorInjected(serviceType string, code int) {
+	l.metrics.Increment("handle.request.error_injected", []string{
+		fmt.Sprintf("type:%s", serviceType),
+		fmt.Sprintf("response:%d", code),
+	})
+}
+
+// RequestRateLimited records an inbound request which was rejected because
+// the service exceeded the rate limit
+func (l *Logger) RequestRateLimited(serviceType string) {
+	l.metrics.Increment("handle.request.rate_limited", []string{fmt.Sprintf("type:%s", serviceType)})
+}
+
+// requestInFlight updates the number of inbound requests which are being
+// handled
+func (l *Logger) requestInFlight(serviceType string, delta int) {
+	l.mutex.Lock()
+	l.inFlight[serviceType] += delta
+	n := l.inFlight[serviceType]
+	l.mutex.Unlock()
+
+	l.metrics.Gauge("handle.request.in_flight", float64(n), []string{fmt.Sprintf("type:%s", serviceType)})
+}
+
 func (l *Logger) CallHealthHTTP() *LogProcess {
 	st := time.Now()
 	l.log.Info("Handling health request")
@@ -406,6 +902,18 @@ func (l *Logger) CallHealthHTTP() *LogProcess {
 	}
 }
 
+func (l *Logger) CallHealthGRPC(service string) *LogProcess {
+	st := time.Now()
+	l.log.Info("Handling gRPC health request", "service", service)
+
+	return &LogProcess{
+		finished: func(err error, meta map[string]string) {
+			te := time.Now()
+			l.metrics.Timing("handle.health.grpc", te.Sub(st), getTags(err, meta))
+		},
+	}
+}
+
 func (l *Logger) CallReadyHTTP() *LogProcess {
 	st := time.Now()
 	l.log.Info("Handling ready request")
@@ -497,6 +1005,36 @@ func (l *Logger) logFieldsWithSpanID(ctx opentracing.SpanContext, fields ...inte
 	return fields
 }
 
+// peerFields returns the log fields for the identity of the client
+func peerFields(p *response.PeerCertificate) []interface{} {
+	if p == nil {
+		return nil
+	}
+
+	return []interface{}{
+		"peer_subject", p.Subject,
+		"peer_sans", strings.Join(p.SANs, ","),
+		"peer_spiffe_id", p.SPIFFEID,
+		"peer_verified", p.Verified,
+	}
+}
+
+// grpcConnectionState returns the TLS state of the connection for the gRPC
+// request, nil is returned when the connection does not use TLS
+func grpcConnectionState(ctx context.Context) *tls.ConnectionState {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	ti, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+
+	return &ti.State
+}
+
 func getTags(err error, meta map[string]string) []string {
 	tags := []string{}
 
@@ -0,0 +1,210 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// OTelMetrics records metrics using OpenTelemetry instruments, the metrics
+// are periodically exported to an OpenTelemetry collector
+type OTelMetrics struct {
+	provider *sdkmetric.MeterProvider
+	meter    metric.Meter
+
+	mutex      sync.Mutex
+	histograms map[string]metric.Float64Histogram
+	counters   map[string]metric.Int64Counter
+	gauges     map[string]*otelGauge
+}
+
+// otelGauge holds the last value set for each set of attributes, the values
+// are read when the metrics are collected
+type otelGauge struct {
+	mutex  sync.Mutex
+	values map[attribute.Distinct]otelGaugeValue
+}
+
+type otelGaugeValue struct {
+	attributes attribute.Set
+	value      float64
+}
+
+// NewOTelMetrics exports metrics to the OpenTelemetry collector at uri using
+// the given protocol
+func NewOTelMetrics(uri, protocol, serviceName string) (*OTelMetrics, error) {
+	e, err := tracing.ParseOTLPEndpoint(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var exporter sdkmetric.Exporter
+
+	switch protocol {
+	case tracing.OTLPProtocolGRPC:
+		opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(e.Host)}
+		if e.Insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+
+		exporter, err = otlpmetricgrpc.New(context.Background(), opts...)
+	case tracing.OTLPProtocolHTTP:
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(e.Host)}
+		if e.Path != "" {
+			opts = append(opts, otlpmetrichttp.WithURLPath(e.Path))
+		}
+
+		if e.Insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+
+		exporter, err = otlpmetrichttp.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %s, expected %s or %s", protocol, tracing.OTLPProtocolGRPC, tracing.OTLPProtocolHTTP)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OTLP metric exporter: %s", err)
+	}
+
+	res, err := tracing.OTLPResource(serviceName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OTLP resource: %s", err)
+	}
+
+	return newOTelMetrics(sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)),
+		sdkmetric.WithResource(res),
+	)), nil
+}
+
+func newOTelMetrics(p *sdkmetric.MeterProvider) *OTelMetrics {
+	return &OTelMetrics{
+		provider:   p,
+		meter:      p.Meter("github.com/nicholasjackson/fake-service"),
+		histograms: map[string]metric.Float64Histogram{},
+		counters:   map[string]metric.Int64Counter{},
+		gauges:     map[string]*otelGauge{},
+	}
+}
+
+// Shutdown exports any pending metrics and stops the exporter
+func (o *OTelMetrics) Shutdown(ctx context.Context) error {
+	return o.provider.Shutdown(ctx)
+}
+
+func (o *OTelMetrics) Timing(name string, duration time.Duration, tags []string) {
+	h, err := o.histogram(name)
+	if err != nil {
+		return
+	}
+
+	h.Record(context.Background(), duration.Seconds(), metric.WithAttributes(otelAttributes(tags)...))
+}
+
+func (o *OTelMetrics) Increment(name string, tags []string) {
+	c, err := o.counter(name)
+	if err != nil {
+		return
+	}
+
+	c.Add(context.Background(), 1, metric.WithAttributes(otelAttributes(tags)...))
+}
+
+func (o *OTelMetrics) Gauge(name string, value float64, tags []string) {
+	g, err := o.gauge(name)
+	if err != nil {
+		return
+	}
+
+	s := attribute.NewSet(otelAttributes(tags)...)
+
+	g.mutex.Lock()
+	g.values[s.Equivalent()] = otelGaugeValue{s, value}
+	g.mutex.Unlock()
+}
+
+func (o *OTelMetrics) histogram(name string) (metric.Float64Histogram, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if h, ok := o.histograms[name]; ok {
+		return h, nil
+	}
+
+	h, err := o.meter.Float64Histogram(name, metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+
+	o.histograms[name] = h
+
+	return h, nil
+}
+
+func (o *OTelMetrics) counter(name string) (metric.Int64Counter, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if c, ok := o.counters[name]; ok {
+		return c, nil
+	}
+
+	c, err := o.meter.Int64Counter(name)
+	if err != nil {
+		return nil, err
+	}
+
+	o.counters[name] = c
+
+	return c, nil
+}
+
+func (o *OTelMetrics) gauge(name string) (*otelGauge, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if g, ok := o.gauges[name]; ok {
+		return g, nil
+	}
+
+	g := &otelGauge{values: map[attribute.Distinct]otelGaugeValue{}}
+
+	_, err := o.meter.Float64ObservableGauge(name, metric.WithFloat64Callback(
+		func(_ context.Context, ob metric.Float64Observer) error {
+			g.mutex.Lock()
+			defer g.mutex.Unlock()
+
+			for _, v := range g.values {
+				ob.Observe(v.value, metric.WithAttributeSet(v.attributes))
+			}
+
+			return nil
+		},
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	o.gauges[name] = g
+
+	return g, nil
+}
+
+// otelAttributes converts tags in the form key:value to attributes
+func otelAttributes(tags []string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{}
+	for k, v := range parseTags(tags) {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+
+	return attrs
+}
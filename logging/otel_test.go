@@ -0,0 +1,77 @@
+package logging
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func setupOTelMetrics(t *testing.T) (*OTelMetrics, *sdkmetric.ManualReader) {
+	r := sdkmetric.NewManualReader()
+	return newOTelMetrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(r))), r
+}
+
+func collectOTelMetrics(t *testing.T, r *sdkmetric.ManualReader) map[string]metricdata.Aggregation {
+	rm := metricdata.ResourceMetrics{}
+	require.NoError(t, r.Collect(context.Background(), &rm))
+
+	m := map[string]metricdata.Aggregation{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, d := range sm.Metrics {
+			m[d.Name] = d.Data
+		}
+	}
+
+	return m
+}
+
+func TestOTelRecordsTimingsAsHistograms(t *testing.T) {
+	o, r := setupOTelMetrics(t)
+
+	o.Timing("upstream.request.http", 100*time.Millisecond, []string{"uri:http://api:9090", "response:200"})
+
+	m := collectOTelMetrics(t, r)
+	h, ok := m["upstream.request.http"].(metricdata.Histogram[float64])
+	require.True(t, ok)
+	require.Len(t, h.DataPoints, 1)
+
+	assert.Equal(t, uint64(1), h.DataPoints[0].Count)
+	assert.InDelta(t, 0.1, h.DataPoints[0].Sum, 0.001)
+
+	uri, _ := h.DataPoints[0].Attributes.Value(attribute.Key("uri"))
+	assert.Equal(t, "http://api:9090", uri.AsString())
+}
+
+func TestOTelRecordsIncrementsAsCounters(t *testing.T) {
+	o, r := setupOTelMetrics(t)
+
+	o.Increment("service.started", nil)
+	o.Increment("service.started", nil)
+
+	m := collectOTelMetrics(t, r)
+	c, ok := m["service.started"].(metricdata.Sum[int64])
+	require.True(t, ok)
+	require.Len(t, c.DataPoints, 1)
+
+	assert.Equal(t, int64(2), c.DataPoints[0].Value)
+}
+
+func TestOTelRecordsLastGaugeValue(t *testing.T) {
+	o, r := setupOTelMetrics(t)
+
+	o.Gauge("handle.request.in_flight", 3, []string{"type:http"})
+	o.Gauge("handle.request.in_flight", 2, []string{"type:http"})
+
+	m := collectOTelMetrics(t, r)
+	g, ok := m["handle.request.in_flight"].(metricdata.Gauge[float64])
+	require.True(t, ok)
+	require.Len(t, g.DataPoints, 1)
+
+	assert.Equal(t, 2.0, g.DataPoints[0].Value)
+}
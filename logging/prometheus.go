@@ -0,0 +1,242 @@
+package logging
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const prometheusNamespace = "fake_service"
+
+// labels used for metrics which do not have a dedicated collector, tags
+// with other keys are ignored
+var prometheusLabels = []string{"uri", "state", "error"}
+
+// PrometheusMetrics records metrics in a Prometheus registry, the metrics
+// are exposed using the handler returned from Handler
+type PrometheusMetrics struct {
+	registry   *prometheus.Registry
+	registerer prometheus.Registerer
+
+	requestDuration  *prometheus.HistogramVec
+	requests         *prometheus.CounterVec
+	upstreamDuration *prometheus.HistogramVec
+	injectedErrors   *prometheus.CounterVec
+	rateLimited      *prometheus.CounterVec
+	inFlight         *prometheus.GaugeVec
+
+	// collectors for any other metric are created when first used
+	mutex      sync.Mutex
+	histograms map[string]*prometheus.HistogramVec
+	counters   map[string]*prometheus.CounterVec
+	gauges     map[string]*prometheus.GaugeVec
+}
+
+// NewPrometheusMetrics creates the Prometheus metrics for the service, all
+// metrics are labeled with the service name
+func NewPrometheusMetrics(serviceName string) *PrometheusMetrics {
+	r := prometheus.NewRegistry()
+	r.MustRegister(collectors.NewGoCollector())
+	r.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	p := &PrometheusMetrics{
+		registry:   r,
+		registerer: prometheus.WrapRegistererWith(prometheus.Labels{"service": serviceName}, r),
+		histograms: map[string]*prometheus.HistogramVec{},
+		counters:   map[string]*prometheus.CounterVec{},
+		gauges:     map[string]*prometheus.GaugeVec{},
+	}
+
+	p.requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: prometheusNamespace,
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to handle inbound requests",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type", "code"})
+
+	p.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "requests_total",
+		Help:      "Number of inbound requests handled",
+	}, []string{"type", "code"})
+
+	p.upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: prometheusNamespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Time taken for calls to upstream services",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type", "uri", "code"})
+
+	p.injectedErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "injected_errors_total",
+		Help:      "Number of inbound requests which returned an injected error",
+	}, []string{"type", "code"})
+
+	p.rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Number of inbound requests rejected by the rate limit",
+	}, []string{"type"})
+
+	p.inFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "requests_in_flight",
+		Help:      "Number of inbound requests currently being handled",
+	}, []string{"type"})
+
+	p.registerer.MustRegister(
+		p.requestDuration,
+		p.requests,
+		p.upstreamDuration,
+		p.injectedErrors,
+		p.rateLimited,
+		p.inFlight,
+	)
+
+	return p
+}
+
+// Handler returns a http.Handler which serves the metrics in the Prometheus
+// exposition format
+func (p *PrometheusMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+func (p *PrometheusMetrics) Timing(name string, duration time.Duration, tags []string) {
+	t := parseTags(tags)
+
+	switch name {
+	case "handle.request.http", "handle.request.grpc":
+		st := strings.TrimPrefix(name, "handle.request.")
+		p.requestDuration.WithLabelValues(st, t["response"]).Observe(duration.Seconds())
+		p.requests.WithLabelValues(st, t["response"]).Inc()
+	case "upstream.request.http", "upstream.request.grpc":
+		code := t["response"]
+		if code == "" {
+			code = t["ResponseCode"]
+		}
+
+		st := strings.TrimPrefix(name, "upstream.request.")
+		p.upstreamDuration.WithLabelValues(st, t["uri"], code).Observe(duration.Seconds())
+	default:
+		p.histogram(name).With(labelValues(t)).Observe(duration.Seconds())
+	}
+}
+
+func (p *PrometheusMetrics) Increment(name string, tags []string) {
+	t := parseTags(tags)
+
+	switch name {
+	case "handle.request.error_injected":
+		p.injectedErrors.WithLabelValues(t["type"], t["response"]).Inc()
+	case "handle.request.rate_limited":
+		p.rateLimited.WithLabelValues(t["type"]).Inc()
+	default:
+		p.counter(name).With(labelValues(t)).Inc()
+	}
+}
+
+func (p *PrometheusMetrics) Gauge(name string, value float64, tags []string) {
+	t := parseTags(tags)
+
+	switch name {
+	case "handle.request.in_flight":
+		p.inFlight.WithLabelValues(t["type"]).Set(value)
+	default:
+		p.gauge(name).With(labelValues(t)).Set(value)
+	}
+}
+
+func (p *PrometheusMetrics) histogram(name string) *prometheus.HistogramVec {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if h, ok := p.histograms[name]; ok {
+		return h
+	}
+
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: prometheusNamespace,
+		Name:      prometheusName(name) + "_seconds",
+		Help:      "Duration of " + name,
+		Buckets:   prometheus.DefBuckets,
+	}, prometheusLabels)
+
+	p.registerer.MustRegister(h)
+	p.histograms[name] = h
+
+	return h
+}
+
+func (p *PrometheusMetrics) counter(name string) *prometheus.CounterVec {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if c, ok := p.counters[name]; ok {
+		return c
+	}
+
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      prometheusName(name) + "_total",
+		Help:      "Count of " + name,
+	}, prometheusLabels)
+
+	p.registerer.MustRegister(c)
+	p.counters[name] = c
+
+	return c
+}
+
+func (p *PrometheusMetrics) gauge(name string) *prometheus.GaugeVec {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if g, ok := p.gauges[name]; ok {
+		return g
+	}
+
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      prometheusName(name),
+		Help:      "Value of " + name,
+	}, prometheusLabels)
+
+	p.registerer.MustRegister(g)
+	p.gauges[name] = g
+
+	return g
+}
+
+// prometheusName converts a StatsD style metric name to a Prometheus name
+func prometheusName(name string) string {
+	return strings.NewReplacer(".", "_", "-", "_").Replace(name)
+}
+
+// parseTags converts tags in the form key:value to a map
+func parseTags(tags []string) map[string]string {
+	t := map[string]string{}
+	for _, tag := range tags {
+		if k, v, ok := strings.Cut(tag, ":"); ok {
+			t[k] = v
+		}
+	}
+
+	return t
+}
+
+// labelValues returns the values for the generic labels from the tags
+func labelValues(t map[string]string) prometheus.Labels {
+	l := prometheus.Labels{}
+	for _, k := range prometheusLabels {
+		l[k] = t[k]
+	}
+
+	return l
+}
@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrapePrometheus(t *testing.T, p *PrometheusMetrics) string {
+	rr := httptest.NewRecorder()
+	p.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	require.Equal(t, 200, rr.Code)
+
+	b, err := io.ReadAll(rr.Body)
+	require.NoError(t, err)
+
+	return string(b)
+}
+
+func TestPrometheusRecordsRequests(t *testing.T) {
+	p := NewPrometheusMetrics("web")
+
+	p.Timing("handle.request.http", 10*time.Millisecond, []string{"response:200"})
+	p.Timing("handle.request.http", 10*time.Millisecond, []string{"response:500", "error:true"})
+
+	out := scrapePrometheus(t, p)
+	assert.Contains(t, out, `fake_service_requests_total{code="200",service="web",type="http"} 1`)
+	assert.Contains(t, out, `fake_service_requests_total{code="500",service="web",type="http"} 1`)
+	assert.Contains(t, out, `fake_service_request_duration_seconds_count{code="200",service="web",type="http"} 1`)
+}
+
+func TestPrometheusRecordsUpstreamCallsByURI(t *testing.T) {
+	p := NewPrometheusMetrics("web")
+
+	p.Timing("upstream.request.http", 10*time.Millisecond, []string{"response:200", "uri:http://api:9090"})
+	p.Timing("upstream.request.grpc", 10*time.Millisecond, []string{"ResponseCode:0", "uri:grpc://payments:9090"})
+
+	out := scrapePrometheus(t, p)
+	assert.Contains(t, out, `fake_service_upstream_request_duration_seconds_count{code="200",service="web",type="http",uri="http://api:9090"} 1`)
+	assert.Contains(t, out, `fake_service_upstream_request_duration_seconds_count{code="0",service="web",type="grpc",uri="grpc://payments:9090"} 1`)
+}
+
+func TestPrometheusRecordsInjectedErrorsAndRateLimits(t *testing.T) {
+	p := NewPrometheusMetrics("web")
+	l := NewLogger(p, nil, nil)
+
+	l.RequestErrorInjected("http", 503)
+	l.RequestRateLimited("grpc")
+	l.requestInFlight("http", 1)
+	l.requestInFlight("http", 1)
+	l.requestInFlight("http", -1)
+
+	out := scrapePrometheus(t, p)
+	assert.Contains(t, out, `fake_service_injected_errors_total{code="503",service="web",type="http"} 1`)
+	assert.Contains(t, out, `fake_service_rate_limited_requests_total{service="web",type="grpc"} 1`)
+	assert.Contains(t, out, `fake_service_requests_in_flight{service="web",type="http"} 1`)
+}
+
+func TestPrometheusRecordsOtherMetrics(t *testing.T) {
+	p := NewPrometheusMetrics("web")
+
+	p.Increment("upstream.request.retry", []string{"uri:http://api:9090"})
+	p.Gauge("upstream.circuit_breaker.open", 1, []string{"uri:http://api:9090"})
+
+	out := scrapePrometheus(t, p)
+	assert.Contains(t, out, `fake_service_upstream_request_retry_total{error="",service="web",state="",uri="http://api:9090"} 1`)
+	assert.Contains(t, out, `fake_service_upstream_circuit_breaker_open{error="",service="web",state="",uri="http://api:9090"} 1`)
+}
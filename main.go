@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
 	"crypto/tls"
 	"embed"
 	"fmt"
@@ -13,6 +12,7 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"reflect"
 	"runtime"
 	"strings"
 	"syscall"
@@ -21,35 +21,53 @@ import (
 	"github.com/hashicorp/go-hclog"
 	"github.com/nicholasjackson/env"
 	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/config"
 	"github.com/nicholasjackson/fake-service/errors"
 	"github.com/nicholasjackson/fake-service/grpc/api"
 	"github.com/nicholasjackson/fake-service/handlers"
 	"github.com/nicholasjackson/fake-service/load"
 	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/routes"
 	"github.com/nicholasjackson/fake-service/timing"
 	"github.com/nicholasjackson/fake-service/tracing"
+	"github.com/openzipkin/zipkin-go/reporter"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 
 	cors "github.com/gorilla/handlers"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/quic-go/quic-go/http3"
 	"github.com/soheilhy/cmux"
 	//"net/http/pprof"
 )
 
-var upstreamURIs = env.String("UPSTREAM_URIS", false, "", "Comma separated URIs of the upstream services to call")
+var configFile = env.String("CONFIG_FILE", false, "", "Location of a YAML or HCL file containing the service configuration, values set using environment variables override values in the file")
+var configWatchInterval = env.Duration("CONFIG_WATCH_INTERVAL", false, 5*time.Second, "Interval to check CONFIG_FILE, ROUTES_FILE and the TLS certificates for changes, changes are applied without restarting the service, set to 0 to disable")
+
+var upstreamURIs = env.String("UPSTREAM_URIS", false, "", "Comma separated URIs of the upstream services to call, or a JSON list of upstreams with call settings")
 var upstreamAllowInsecure = env.Bool("UPSTREAM_ALLOW_INSECURE", false, false, "Allow calls to upstream servers, ignoring TLS certificate validation")
+var upstreamTLSCA = env.String("UPSTREAM_TLS_CA_LOCATION", false, "", "Location of PEM encoded CA bundle used to verify grpcs:// upstreams, when not set the system roots are used")
+var upstreamTLSCertificate = env.String("UPSTREAM_TLS_CERT_LOCATION", false, "", "Location of PEM encoded x.509 certificate presented to grpcs:// upstreams for mutual TLS")
+var upstreamTLSKey = env.String("UPSTREAM_TLS_KEY_LOCATION", false, "", "Location of PEM encoded private key for UPSTREAM_TLS_CERT_LOCATION")
+var upstreamPlan = env.String("UPSTREAM_PLAN", false, "", "JSON list of stages defining the order upstreams are called in, the upstreams in each stage are called in parallel after the previous stage completes, can not be used with UPSTREAM_URIS")
+var upstreamFailurePolicy = env.String("UPSTREAM_FAILURE_POLICY", false, "required", "Policy which decides if failed upstream calls fail the request, any, required (optional upstreams can fail), or quorum")
+var upstreamQuorum = env.Int("UPSTREAM_QUORUM", false, 0, "Number of upstream calls which must succeed when UPSTREAM_FAILURE_POLICY is quorum")
+var upstreamDegradedCode = env.Int("UPSTREAM_DEGRADED_CODE", false, 200, "Response code returned when upstream calls failed but the failure policy allowed the request to succeed")
 var upstreamWorkers = env.Int("UPSTREAM_WORKERS", false, 1, "Number of parallel workers for calling upstreams, defualt is 1 which is sequential operation")
 
 var upstreamRequestBody = env.String("UPSTREAM_REQUEST_BODY", false, "", "Request body to send to send with upstream requests, NOTE: UPSTREAM_REQUEST_SIZE and UPSTREAM_REQUEST_VARIANCE are ignored if this is set")
 var upstreamRequestSize = env.Int("UPSTREAM_REQUEST_SIZE", false, 0, "Size of the randomly generated request body to send with upstream requests")
 var upstreamRequestVariance = env.Int("UPSTREAM_REQUEST_VARIANCE", false, 0, "Percentage variance of the randomly generated request body")
 
-var message = env.String("MESSAGE", false, "Hello World", "Message to be returned from service")
+var message = env.String("MESSAGE", false, "Hello World", "Message to be returned from service, the message is rendered as a Go template for every request")
 var name = env.String("NAME", false, "Service", "Name of the service")
 
+var routesFile = env.String("ROUTES_FILE", false, "", "Location of a YAML file defining the message, timing, errors and upstreams for individual paths, requests which do not match a route use the default configuration")
+
 var listenAddress = env.String("LISTEN_ADDR", false, "0.0.0.0:9090", "IP address and port to bind service to")
 
 var allowedOrigins = env.String("ALLOWED_ORIGINS", false, "*", "Comma separated list of allowed origins for CORS requests")
@@ -62,11 +80,13 @@ var serverReadTimeout = env.Duration("HTTP_SERVER_READ_TIMEOUT", false, time.Dur
 var serverReadHeaderTimeout = env.Duration("HTTP_SERVER_READHEADER_TIMEOUT", false, time.Duration(0*time.Second), "Maximum duration for reading the HTTP headers, if zero read timeout is used.")
 var serverWriteTimeout = env.Duration("HTTP_SERVER_WRITE_TIMEOUT", false, time.Duration(10*time.Second), "Maximum duration for writing HTTP body, if zero no write timeout is used.")
 var serverIdleTimeout = env.Duration("HTTP_SERVER_IDLE_TIMEOUT", false, time.Duration(30*time.Second), "Maximum duration to wait for next request when HTTP Keep alives are used.")
+var serverHTTP3ListenAddress = env.String("HTTP_SERVER_HTTP3_LISTEN_ADDR", false, "", "UDP address to serve HTTP/3 on, e.g. 0.0.0.0:9090, requires TLS. HTTP/3 is advertised to clients using the Alt-Svc header")
 
 // Upstream client configuration
 var upstreamClientKeepAlives = env.Bool("HTTP_CLIENT_KEEP_ALIVES", false, false, "Enable HTTP connection keep alives for upstream calls.")
 var upstreamAppendRequest = env.Bool("HTTP_CLIENT_APPEND_REQUEST", false, true, "When true the path, querystring, and any headers sent to the service will be appended to any upstream calls")
 var upstreamRequestTimeout = env.Duration("HTTP_CLIENT_REQUEST_TIMEOUT", false, 30*time.Second, "Max time to wait before timeout for upstream requests, default 30s")
+var upstreamProtocol = env.String("HTTP_CLIENT_PROTOCOL", false, "http1", "Protocol used to call HTTP upstreams, http2 negotiates HTTP/2 using ALPN for https:// upstreams and uses h2c for http:// upstreams [http1, http2]")
 
 // Service timing
 var timing50Percentile = env.Duration("TIMING_50_PERCENTILE", false, time.Duration(0*time.Millisecond), "Median duration for a request")
@@ -85,6 +105,36 @@ var errorDelay = env.Duration("ERROR_DELAY", false, 0*time.Second, "Error delay
 var rateLimitRPS = env.Float64("RATE_LIMIT", false, 0.0, "Rate in req/second after which service will return an error code")
 var rateLimitCode = env.Int("RATE_LIMIT_CODE", false, 503, "Code to return when service call is rate limited")
 
+// gRPC streaming methods
+var grpcStreamMessages = env.Int("GRPC_STREAM_MESSAGES", false, 10, "Number of responses sent by the gRPC ServerStream method")
+var grpcStreamInterval = env.Duration("GRPC_STREAM_INTERVAL", false, 1*time.Second, "Delay before each response is sent by the gRPC ServerStream and BidirectionalStream methods")
+var grpcStreamMessageSize = env.Int("GRPC_STREAM_MESSAGE_SIZE", false, 0, "Size in bytes of the random data added to each streamed response")
+var grpcStreamErrorAfter = env.Int("GRPC_STREAM_ERROR_AFTER", false, 0, "Number of messages sent or received after which gRPC streams are ended with GRPC_STREAM_ERROR_CODE, 0 disables the error")
+var grpcStreamErrorCode = env.String("GRPC_STREAM_ERROR_CODE", false, "UNAVAILABLE", "gRPC status code used to end streams when GRPC_STREAM_ERROR_AFTER is set, e.g. UNAVAILABLE, DEADLINE_EXCEEDED")
+
+// WebSocket handler at /ws
+var websocketMode = env.String("WEBSOCKET_MODE", false, "echo", "Behaviour of the WebSocket handler at /ws, echo returns messages to the client, push sends messages on an interval and upstream sends messages to WEBSOCKET_UPSTREAM_URIS [echo, push, upstream]")
+var websocketPushInterval = env.Duration("WEBSOCKET_PUSH_INTERVAL", false, 1*time.Second, "Interval between messages sent to the client when WEBSOCKET_MODE is push")
+var websocketMessageSize = env.Int("WEBSOCKET_MESSAGE_SIZE", false, 0, "Size in bytes of the random payload added to messages sent when WEBSOCKET_MODE is push")
+var websocketLifetime = env.Duration("WEBSOCKET_LIFETIME", false, 0, "Time after which WebSocket connections are closed, 0 keeps connections open until the client closes them")
+var websocketDisconnectAfter = env.Int("WEBSOCKET_DISCONNECT_AFTER", false, 0, "Number of messages sent after which WebSocket connections are closed with WEBSOCKET_DISCONNECT_CODE, 0 disables the disconnect")
+var websocketDisconnectCode = env.Int("WEBSOCKET_DISCONNECT_CODE", false, 1011, "Close code sent when a WebSocket connection is disconnected, 0 drops the connection without a close message")
+var websocketUpstreamURIs = env.String("WEBSOCKET_UPSTREAM_URIS", false, "", "Comma separated ws:// or wss:// URIs of the WebSocket services messages are sent to when WEBSOCKET_MODE is upstream, e.g. ws://api:9090/ws")
+
+// server-sent events
+var sseMode = env.String("SSE_MODE", false, "accept", "When requests are answered with a stream of server-sent events, accept streams events when the request Accept header contains text/event-stream [disabled, accept, always]")
+var sseEvents = env.Int("SSE_EVENTS", false, 10, "Number of message events sent when streaming server-sent events")
+var sseInterval = env.Duration("SSE_INTERVAL", false, 1*time.Second, "Delay before each message event is sent when streaming server-sent events")
+var ssePayloadSize = env.Int("SSE_PAYLOAD_SIZE", false, 0, "Size in bytes of the random payload added to each message event")
+var sseUpstreamEvents = env.Bool("SSE_UPSTREAM_EVENTS", false, false, "Send an event with the response from each upstream as its call completes when streaming server-sent events")
+
+// raw TCP and UDP listeners
+var rawTCPListenAddress = env.String("RAW_TCP_LISTEN_ADDR", false, "", "TCP address the raw TCP listener is started on, e.g. 0.0.0.0:9091, not set disables the listener")
+var rawUDPListenAddress = env.String("RAW_UDP_LISTEN_ADDR", false, "", "UDP address the raw UDP listener is started on, e.g. 0.0.0.0:9091, not set disables the listener")
+var rawMode = env.String("RAW_MODE", false, "echo", "Behaviour of the raw TCP and UDP listeners, echo returns the data to the client, payload responds with RAW_PAYLOAD and upstream forwards the data to RAW_UPSTREAM_URIS [echo, payload, upstream]")
+var rawPayload = env.String("RAW_PAYLOAD", false, "", "Data sent in response to the data received when RAW_MODE is payload, when not set MESSAGE is sent")
+var rawUpstreamURIs = env.String("RAW_UPSTREAM_URIS", false, "", "Comma separated tcp:// or udp:// URIs data is forwarded to when RAW_MODE is upstream, TCP connections are forwarded to the tcp:// URIs and UDP datagrams to the udp:// URIs, e.g. tcp://db:9091")
+
 // load generation
 var loadCPUAllocated = env.Int("LOAD_CPU_ALLOCATED", false, 0, "MHz of CPU allocated to the service, when specified, load percentage is a percentage of CPU allocated")
 var loadCPUClockSpeed = env.Int("LOAD_CPU_CLOCK_SPEED", false, 1000, "MHz of a Single logical core, default 1000Mhz")
@@ -96,12 +146,21 @@ var loadMemoryVariance = env.Int("LOAD_MEMORY_VARIANCE", false, 0, "Percentage v
 
 // metrics / tracing / logging
 var zipkinEndpoint = env.String("TRACING_ZIPKIN", false, "", "Location of Zipkin tracing collector")
+var tracingCollector = env.Bool("TRACING_COLLECTOR", false, false, "Store traces in memory and serve the Zipkin API at /api/v2 so that traces can be viewed in the UI")
+var tracingCollectorMaxTraces = env.Int("TRACING_COLLECTOR_MAX_TRACES", false, 1000, "Number of traces kept by the built-in trace collector")
 
 var datadogTracingEndpointHost = env.String("TRACING_DATADOG_HOST", false, "", "Hostname or IP for Datadog tracing collector")
 var datadogTracingEndpointPort = env.String("TRACING_DATADOG_PORT", false, "8126", "Port for Datadog tracing collector")
+var otlpTracingEndpoint = env.String("TRACING_OTLP_ENDPOINT", false, "", "URI of the OpenTelemetry collector for traces, e.g. http://localhost:4317")
+var otlpTracingProtocol = env.String("TRACING_OTLP_PROTOCOL", false, "grpc", "Protocol used to send traces to the OpenTelemetry collector. [grpc|http]")
+var tracingPropagationExtract = env.String("TRACING_PROPAGATION_EXTRACT", false, "", "Comma separated formats used to read the trace context from requests, the first found is used. [tracecontext|baggage|b3|b3multi|datadog|jaeger]")
+var tracingPropagationInject = env.String("TRACING_PROPAGATION_INJECT", false, "", "Comma separated formats used to send the trace context to upstreams. [tracecontext|baggage|b3|b3multi|datadog|jaeger]")
 var datadogMetricsEndpointHost = env.String("METRICS_DATADOG_HOST", false, "", "Hostname or IP for Datadog metrics collector")
 var datadogMetricsEndpointPort = env.String("METRICS_DATADOG_PORT", false, "8125", "Port for Datadog metrics collector")
 var datadogMetricsEnvironment = env.String("METRICS_DATADOG_ENVIRONMENT", false, "production", "Environment tag for Datadog metrics collector")
+var prometheusMetrics = env.Bool("METRICS_PROMETHEUS", false, false, "Expose Prometheus metrics at the path /metrics")
+var otlpMetricsEndpoint = env.String("METRICS_OTLP_ENDPOINT", false, "", "URI of the OpenTelemetry collector for metrics, e.g. http://localhost:4317")
+var otlpMetricsProtocol = env.String("METRICS_OTLP_PROTOCOL", false, "grpc", "Protocol used to send metrics to the OpenTelemetry collector. [grpc|http]")
 
 var logFormat = env.String("LOG_FORMAT", false, "text", "Log file format. [text|json]")
 var logLevel = env.String("LOG_LEVEL", false, "info", "Log level for output. [info|debug|trace|warn|error]")
@@ -110,8 +169,13 @@ var logOutput = env.String("LOG_OUTPUT", false, "stdout", "Location to write log
 // TLS Certs
 var tlsCertificate = env.String("TLS_CERT_LOCATION", false, "", "Location of PEM encoded x.509 certificate for securing server")
 var tlsKey = env.String("TLS_KEY_LOCATION", false, "", "Location of PEM encoded private key for securing server")
+var tlsCA = env.String("TLS_CA_LOCATION", false, "", "Location of PEM encoded CA bundle used to verify client certificates")
+var tlsSelfSigned = env.Bool("TLS_SELF_SIGNED", false, false, "Generate a self signed certificate for securing server, the CA is returned from the path /tls/ca.pem")
+var tlsSelfSignedHosts = env.String("TLS_SELF_SIGNED_HOSTS", false, "", "Comma separated names and IP addresses added to the self signed certificate, the service name, hostname and local addresses are always added")
+var tlsClientAuth = env.String("TLS_CLIENT_AUTH", false, "none", "Client certificate verification for the HTTP and gRPC server, request and require do not verify the certificate [none, request, require, verify]")
 
 var healthResponseCode = env.Int("HEALTH_CHECK_RESPONSE_CODE", false, 200, "Response code returned from the HTTP health check at /health")
+var healthGRPCServices = env.String("HEALTH_CHECK_GRPC_SERVICES", false, "", "Comma separated list of service=STATUS setting the status returned by the gRPC health service, e.g. FakeService=NOT_SERVING")
 
 var readySuccessResponseCode = env.Int("READY_CHECK_RESPONSE_SUCCESS_CODE", false, 200, "Response code returned from the HTTP readiness handler `/ready` after the response delay has elapsed")
 var readyFailureResponseCode = env.Int("READY_CHECK_RESPONSE_FAILURE_CODE", false, 503, "Response code returned from the HTTP readiness handler `/ready` before the response delay has elapsed, this simulates the response code a service would return while starting")
@@ -126,26 +190,112 @@ var version = "dev"
 func main() {
 	env.Parse()
 
+	// record the variables set in the environment, these take precedence over
+	// any values in the config file
+	environment := environmentVariables()
+
+	// if a config file has been specified, set any values which have not
+	// been set in the environment and parse the environment again
+	fileEnvironment, err := applyConfigFile(*configFile, environment, nil)
+	if err != nil {
+		log.Fatalf("Unable to load config file:\n%s", err)
+	}
+
 	var sdf tracing.SpanDetailsFunc
 
+	// propagation is the format the tracer uses for the trace context
+	var propagation []string
+
 	// do we need to setup tracing
-	if *zipkinEndpoint != "" {
-		tracing.NewOpenTracingClient(*zipkinEndpoint, *name, *listenAddress)
+	// the built-in collector receives the spans from the OpenTelemetry tracer
+	// when traces are exported using OTLP, otherwise from the Zipkin tracer
+	var spanStore *tracing.SpanStore
+	reporters := []reporter.Reporter{}
+	processors := []sdktrace.SpanProcessor{}
+
+	if *tracingCollector {
+		spanStore = tracing.NewSpanStore(*tracingCollectorMaxTraces)
+
+		if *otlpTracingEndpoint != "" {
+			processors = append(processors, spanStore)
+		} else {
+			reporters = append(reporters, spanStore)
+		}
+	}
+
+	if *zipkinEndpoint != "" || len(reporters) > 0 {
+		tracing.NewOpenTracingClient(*zipkinEndpoint, *name, *listenAddress, reporters...)
 		sdf = tracing.GetZipkinSpanDetails
+		propagation = []string{tracing.PropagationB3Multi}
 	}
 
 	if *datadogTracingEndpointHost != "" {
 		hostname := fmt.Sprintf("%s:%s", *datadogTracingEndpointHost, *datadogTracingEndpointPort)
 		tracing.NewDataDogClient(hostname, *name)
 		sdf = tracing.GetDataDogSpanDetails
+		propagation = []string{tracing.PropagationDatadog}
+	}
+
+	// shutdown functions flush the OpenTelemetry exporters on exit
+	shutdown := []func(context.Context) error{}
+
+	if *otlpTracingEndpoint != "" {
+		sd, err := tracing.NewOTelClient(*otlpTracingEndpoint, *otlpTracingProtocol, *name, processors...)
+		if err != nil {
+			log.Fatalf("Unable to configure OpenTelemetry tracing: %s", err)
+		}
+
+		shutdown = append(shutdown, sd)
+		sdf = tracing.GetOTelSpanDetails
+		propagation = []string{tracing.PropagationTraceContext, tracing.PropagationBaggage}
+	}
+
+	// translate the trace context when the formats differ from the tracer
+	if len(propagation) > 0 {
+		err := tracing.SetPropagation(
+			propagation,
+			strings.Split(*tracingPropagationExtract, ","),
+			strings.Split(*tracingPropagationInject, ","),
+		)
+
+		if err != nil {
+			log.Fatalf("Unable to configure trace propagation: %s", err)
+		}
 	}
 
 	// do we need to setup metrics
 	var metrics logging.Metrics = &logging.NullMetrics{}
+	sinks := logging.MultiMetrics{}
 
 	if *datadogMetricsEndpointHost != "" {
 		hostname := fmt.Sprintf("%s:%s", *datadogMetricsEndpointHost, *datadogMetricsEndpointPort)
-		metrics = logging.NewStatsDMetrics(*name, *datadogMetricsEnvironment, hostname)
+		sinks = append(sinks, logging.NewStatsDMetrics(*name, *datadogMetricsEnvironment, hostname))
+	}
+
+	// the Prometheus metrics are served by the HTTP server
+	var metricsHandler http.Handler
+	if *prometheusMetrics {
+		pm := logging.NewPrometheusMetrics(*name)
+		metricsHandler = pm.Handler()
+		sinks = append(sinks, pm)
+	}
+
+	if *otlpMetricsEndpoint != "" {
+		om, err := logging.NewOTelMetrics(*otlpMetricsEndpoint, *otlpMetricsProtocol, *name)
+		if err != nil {
+			log.Fatalf("Unable to configure OpenTelemetry metrics: %s", err)
+		}
+
+		shutdown = append(shutdown, om.Shutdown)
+		sinks = append(sinks, om)
+	}
+
+	switch len(sinks) {
+	case 0:
+	case 1:
+		metrics = sinks[0]
+	default:
+		metrics = sinks
 	}
 
 	lo := hclog.DefaultOptions
@@ -173,58 +323,18 @@ func main() {
 	logger := logging.NewLogger(metrics, hclog.New(lo), sdf)
 	logger.Log().Info("Using seed", "seed", *seed)
 
-	requestDuration := timing.NewRequestDuration(
-		*timing50Percentile,
-		*timing90Percentile,
-		*timing99Percentile,
-		*timingVariance,
-	)
-
-	// create the error injector
-	errorInjector := errors.NewInjector(
-		logger.Log().Named("error_injector"),
-		*errorRate,
-		*errorCode,
-		*errorType,
-		*errorDelay,
-		*rateLimitRPS,
-		*rateLimitCode,
-	)
-
-	// create the load generator
-	// get the total CPU amount
-	// If original CPU percent is 10, however the service has only been allocated 10% of the available CPU then percent should be 1 as it is total of avaiable
-	// Allocated Percentage = Allocated / (Max * Cores) * Percentage
-	// 100 / (1000 * 10) * 10 = 1
-
-	if *loadCPUCores == -1 {
-		*loadCPUCores = runtime.NumCPU()
-	}
-
-	if *loadCPUAllocated != 0 {
-		*loadCPUPercentage = float64(*loadCPUAllocated) / (float64(*loadCPUClockSpeed) * float64(*loadCPUCores)) * float64(*loadCPUPercentage)
+	// create the settings for the request handlers, these can be replaced
+	// while the service is running
+	cfg, err := environmentConfig()
+	if err != nil {
+		logger.Log().Error("Invalid configuration", "error", err)
+		os.Exit(1)
 	}
 
-	// create a generator that will be used to create memory and CPU load per request
-	generator := load.NewGenerator(*loadCPUCores, *loadCPUPercentage, *loadMemoryAllocated, *loadMemoryVariance, logger.Log().Named("load_generator"))
-	requestGenerator := load.NewRequestGenerator(*upstreamRequestBody, *upstreamRequestSize, *upstreamRequestVariance, int64(*seed))
-
-	// create the httpClient
-	defaultClient := client.NewHTTP(*upstreamClientKeepAlives, *upstreamAppendRequest, *upstreamRequestTimeout, *upstreamAllowInsecure)
-
-	// build the map of gRPCClients
-	grpcClients := make(map[string]client.GRPC)
-	for _, u := range tidyURIs(*upstreamURIs) {
-		//strip the grpc:// from the uri
-		u2 := strings.TrimPrefix(u, "grpc://")
-
-		c, err := client.NewGRPC(u2, *upstreamRequestTimeout)
-		if err != nil {
-			logger.Log().Error("Error creating GRPC client", "error", err)
-			os.Exit(1)
-		}
-
-		grpcClients[u] = c
+	settings, err := createSettings(logger, cfg, nil, nil)
+	if err != nil {
+		logger.Log().Error("Unable to create service settings", "error", err)
+		os.Exit(1)
 	}
 
 	// setup the listener
@@ -235,64 +345,183 @@ func main() {
 	}
 
 	// if we are using TLS wrap the listener in a TLS listener
-	if *tlsCertificate != "" && *tlsKey != "" {
+	var certs *certificates
+	if *tlsSelfSigned {
+		logger.Log().Info("Generating self signed certificate")
+
+		certs, err = newSelfSignedCertificates(selfSignedHosts(*name, tidyURIs(*tlsSelfSignedHosts)), *tlsCA)
+	} else if *tlsCertificate != "" && *tlsKey != "" {
+		certs, err = loadCertificates(*tlsCertificate, *tlsKey, *tlsCA)
+	}
+
+	if err != nil {
+		logger.Log().Error("Error loading certificates", "error", err)
+		os.Exit(1)
+	}
+
+	var tlsConfig *tls.Config
+	if certs != nil {
 		logger.Log().Info("Enabling TLS for HTTP endpoint")
 
-		var certificate tls.Certificate
-		certificate, err = tls.LoadX509KeyPair(*tlsCertificate, *tlsKey)
+		tlsConfig, err = createTLSConfig(certs, *tlsClientAuth)
 		if err != nil {
 			logger.Log().Error("Error loading certificates", "error", err)
 			os.Exit(1)
 		}
 
-		config := &tls.Config{
-			Certificates: []tls.Certificate{certificate},
-			Rand:         rand.Reader,
+		// rotated certificates are used for new connections
+		if *configWatchInterval > 0 {
+			certs.watch(*configWatchInterval, logger)
 		}
 
 		// Create TLS listener.
-		l = tls.NewListener(l, config)
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	// HTTP/3 uses QUIC which always encrypts the connection
+	if *serverHTTP3ListenAddress != "" && tlsConfig == nil {
+		logger.Log().Error("HTTP/3 requires TLS, set TLS_CERT_LOCATION and TLS_KEY_LOCATION or TLS_SELF_SIGNED", "address", *serverHTTP3ListenAddress)
+		os.Exit(1)
 	}
 
 	// create a cmux
 	// cmux allows us to have a grpc and a http server listening on the same port
 	m := cmux.New(l)
-	httpListener := m.Match(cmux.HTTP1Fast())
-	grpcListener := m.Match(cmux.Any())
+	httpListeners, grpcListener := muxListeners(m)
 
 	// create the http handlers
-	hh := handlers.NewHealth(logger, *healthResponseCode)
+	hh := handlers.NewHealth(logger, cfg.Health.ResponseCode)
+
+	grpcServices, err := config.ParseGRPCHealth(cfg.Health.GRPCServices)
+	if err != nil {
+		logger.Log().Error("Invalid gRPC health services", "error", err)
+		os.Exit(1)
+	}
+
+	hh.SetGRPCServices(grpcServices)
+
 	rh := handlers.NewReady(logger, *readySuccessResponseCode, *readyFailureResponseCode, *readyResponseDelay)
 	rq := handlers.NewRequest(
 		*name,
-		*message,
-		requestDuration,
-		tidyURIs(*upstreamURIs),
-		*upstreamWorkers,
-		defaultClient,
-		grpcClients,
-		errorInjector,
-		generator,
+		settings.Message,
+		settings.Duration,
+		settings.UpstreamURIs,
+		settings.Upstreams,
+		settings.Plan,
+		settings.FailurePolicy,
+		settings.WorkerCount,
+		settings.DefaultClient,
+		settings.GRPCClients,
+		settings.ErrorInjector,
+		settings.LoadGenerator,
 		logger,
-		requestGenerator,
+		settings.RequestGenerator,
 		*readyRootPathWaitTillReady,
 		rh,
+		settings.Routes,
+		settings.SSE,
 	)
-	cq := handlers.NewConfig(logger, errorInjector, hh)
 
-	grpcServer := createGRPCServer(logger, requestDuration, errorInjector, generator, grpcClients, defaultClient, requestGenerator, *readyRootPathWaitTillReady, rh)
-	httpServer := createHTTPServer(hh, rh, rq, cq, logger)
+	wsh := handlers.NewWebSocket(*name, *settings, logger)
+	tcph := handlers.NewTCP(*settings, logger)
+	udph := handlers.NewUDP(*settings, logger)
+
+	grpcServer, fakeServer := createGRPCServer(logger, settings, *readyRootPathWaitTillReady, hh, rh)
+
+	// reload the settings when the config files change, a SIGHUP is received,
+	// or the configuration is modified using the admin API
+	rl := &reloader{
+		logger:          logger,
+		environment:     environment,
+		fileEnvironment: fileEnvironment,
+		grpcClients:     settings.GRPCClients,
+		circuitBreakers: settings.CircuitBreakers,
+		targets:         []settingsUpdater{rq, wsh, tcph, udph, fakeServer},
+		health:          hh,
+		current:         *cfg,
+	}
+
+	ah := handlers.NewAdmin(logger, rl, rl)
+
+	// add the handler for the built-in trace collector
+	var th *handlers.Traces
+	if spanStore != nil {
+		th = handlers.NewTraces(logger, spanStore)
+	}
+
+	var http3Server *http3.Server
+	if *serverHTTP3ListenAddress != "" {
+		http3Server = createHTTP3Server(*serverHTTP3ListenAddress, tlsConfig)
+	}
+
+	httpServer := createHTTPServer(hh, rh, rq, wsh, ah, th, metricsHandler, certs, http3Server, logger)
+
+	if *configWatchInterval > 0 {
+		rl.watch(*configWatchInterval)
+	}
 
 	// start the http/s server
-	go func() {
-		var err error
-		err = httpServer.Serve(httpListener)
+	for _, hl := range httpListeners {
+		go func(hl net.Listener) {
+			err := httpServer.Serve(hl)
+
+			// the listeners are closed by cmux when the gRPC server stops
+			if err != nil && err != http.ErrServerClosed && err != cmux.ErrServerClosed {
+				logger.Log().Error("Error starting http server", "error", err)
+				os.Exit(1)
+			}
+		}(hl)
+	}
 
-		if err != nil && err != http.ErrServerClosed {
-			logger.Log().Error("Error starting http server", "error", err)
+	// start the http/3 server
+	if http3Server != nil {
+		logger.Log().Info("Starting HTTP/3 server", "address", *serverHTTP3ListenAddress)
+
+		go func() {
+			err := http3Server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				logger.Log().Error("Error starting HTTP/3 server", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// start the raw TCP and UDP listeners
+	var tcpListener net.Listener
+	if *rawTCPListenAddress != "" {
+		tcpListener, err = net.Listen("tcp", *rawTCPListenAddress)
+		if err != nil {
+			logger.Log().Error("Unable to listen at", "address", *rawTCPListenAddress, "error", err)
+			os.Exit(1)
+		}
+
+		logger.Log().Info("Starting raw TCP listener", "address", *rawTCPListenAddress, "mode", settings.Raw.Mode)
+
+		go func() {
+			if err := tcph.Serve(tcpListener); err != nil {
+				logger.Log().Error("Error starting raw TCP listener", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	var udpConn net.PacketConn
+	if *rawUDPListenAddress != "" {
+		udpConn, err = net.ListenPacket("udp", *rawUDPListenAddress)
+		if err != nil {
+			logger.Log().Error("Unable to listen at", "address", *rawUDPListenAddress, "error", err)
 			os.Exit(1)
 		}
-	}()
+
+		logger.Log().Info("Starting raw UDP listener", "address", *rawUDPListenAddress, "mode", settings.Raw.Mode)
+
+		go func() {
+			if err := udph.Serve(udpConn); err != nil {
+				logger.Log().Error("Error starting raw UDP listener", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
 	// start the grpc server
 	go func() {
@@ -308,9 +537,20 @@ func main() {
 
 	logger.ServiceStarted(*name, *upstreamURIs, *upstreamWorkers, *listenAddress)
 
+	// trap SIGHUP and reload the configuration
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			logger.Log().Info("Received SIGHUP, reloading configuration")
+			rl.reload()
+		}
+	}()
+
 	// trap sigterm or interupt and gracefully shutdown the server
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	signal.Notify(c, os.Interrupt, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 
 	// Block until a signal is received.
 	sig := <-c
@@ -329,6 +569,23 @@ func main() {
 	defer cancel()
 	httpServer.Shutdown(ctx)
 
+	if http3Server != nil {
+		http3Server.Close()
+	}
+
+	// connections which are open are closed when the process exits
+	if tcpListener != nil {
+		tcpListener.Close()
+	}
+
+	if udpConn != nil {
+		udpConn.Close()
+	}
+
+	for _, sd := range shutdown {
+		sd(ctx)
+	}
+
 	m.Close()
 }
 
@@ -347,7 +604,12 @@ func createHTTPServer(
 	hh *handlers.Health,
 	rh *handlers.Ready,
 	rq http.Handler,
-	con *handlers.Config,
+	wsh *handlers.WebSocket,
+	ah *handlers.Admin,
+	th *handlers.Traces,
+	mh http.Handler,
+	certs *certificates,
+	http3Server *http3.Server,
 	logger *logging.Logger,
 ) *http.Server {
 	mux := http.NewServeMux()
@@ -360,8 +622,33 @@ func createHTTPServer(
 	mux.HandleFunc("/health", hh.Handle)
 	mux.HandleFunc("/ready", rh.Handle)
 
-	// Add the config handler that allows modification of config values dynamically
-	mux.HandleFunc("/config/", con.Handle)
+	// Add the WebSocket handler
+	mux.HandleFunc("/ws", wsh.Handle)
+
+	// Add the admin handler that allows modification of config values dynamically
+	mux.HandleFunc("/admin/config", ah.Handle)
+	mux.HandleFunc("/admin/circuit_breakers", ah.HandleCircuitBreakers)
+
+	// Add the Zipkin API for the built-in trace collector
+	if th != nil {
+		logger.Log().Info("Adding handler for trace collector", "path", "/api/v2/")
+		mux.HandleFunc("/api/v2/spans", th.HandleSpans)
+		mux.HandleFunc("/api/v2/traces", th.HandleTraces)
+		mux.HandleFunc("/api/v2/trace/", th.HandleTrace)
+		mux.HandleFunc("/api/v2/services", th.HandleServices)
+	}
+
+	// Add the Prometheus metrics handler
+	if mh != nil {
+		logger.Log().Info("Adding handler for Prometheus metrics", "path", "/metrics")
+		mux.Handle("/metrics", mh)
+	}
+
+	// Add the CA for the generated certificate so that clients can verify it
+	if certs != nil && certs.selfSignedCA != nil {
+		logger.Log().Info("Adding handler for self signed CA", "path", "/tls/ca.pem")
+		mux.HandleFunc("/tls/ca.pem", certs.HandleCA)
+	}
 
 	// uncomment to enable pprof
 	//mux.HandleFunc("/debug/pprof/", pprof.Index)
@@ -388,6 +675,14 @@ func createHTTPServer(
 
 	logger.Log().Info("Settings CORS options", "allow_creds", *allowCredentials, "allow_headers", *allowedHeaders, "allow_origins", *allowedOrigins)
 	ch := cors.CORS(corsOptions...)
+	handler := withTLSState(ch(mux))
+
+	// the HTTP/3 server uses the same handlers, responses from this server
+	// advertise it
+	if http3Server != nil {
+		http3Server.Handler = handler
+		handler = withAltSvc(handler, http3Server)
+	}
 
 	server := &http.Server{
 		Addr:              *listenAddress,
@@ -395,7 +690,8 @@ func createHTTPServer(
 		ReadHeaderTimeout: *serverReadHeaderTimeout,
 		WriteTimeout:      *serverWriteTimeout,
 		IdleTimeout:       *serverIdleTimeout,
-		Handler:           ch(mux),
+		Handler:           withHTTP2(handler, *serverIdleTimeout),
+		ConnContext:       tlsConnContext,
 		ErrorLog:          logger.Log().StandardLogger(&hclog.StandardLoggerOptions{InferLevels: true}),
 	}
 
@@ -406,15 +702,11 @@ func createHTTPServer(
 
 func createGRPCServer(
 	logger *logging.Logger,
-	rd *timing.RequestDuration,
-	errorInjector *errors.Injector,
-	generator *load.Generator,
-	grpcClients map[string]client.GRPC,
-	defaultClient client.HTTP,
-	requestGenerator load.RequestGenerator,
+	settings *handlers.Settings,
 	waitForReadyCheck bool,
+	healthHandler *handlers.Health,
 	readyHandler *handlers.Ready,
-) *grpc.Server {
+) (*grpc.Server, *handlers.FakeServer) {
 
 	serverOptions := []grpc.ServerOption{}
 
@@ -423,16 +715,12 @@ func createGRPCServer(
 		serverOptions = append(serverOptions, grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionAge: 5 * time.Second}))
 	}
 
-	//if *tlsCertificate != "" && *tlsKey != "" {
-	//	creds, err := credentials.NewServerTLSFromFile(*tlsCertificate, *tlsKey)
-	//	if err != nil {
-	//		logger.Log().Error("Unable to load TLS certificate for gRPC server", "error", err)
-	//		os.Exit(1)
-	//	}
-	//	serverOptions = append(serverOptions, grpc.Creds(creds))
-	//}
+	// the connection has already been decrypted by the TLS listener which is
+	// shared with the HTTP server, the credentials only expose the state of
+	// the connection so that the client certificate can be read
+	serverOptions = append(serverOptions, grpc.Creds(muxTLSCredentials{}))
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(serverOptions...)
 
 	// register the reflection service which allows clients to determine the methods
 	// for this gRPC service
@@ -440,23 +728,370 @@ func createGRPCServer(
 
 	fakeServer := handlers.NewFakeServer(
 		*name,
-		*message,
-		rd,
-		tidyURIs(*upstreamURIs),
-		*upstreamWorkers,
-		defaultClient,
-		grpcClients,
-		errorInjector,
-		generator,
+		settings.Message,
+		settings.Duration,
+		settings.UpstreamURIs,
+		settings.Upstreams,
+		settings.Plan,
+		settings.FailurePolicy,
+		settings.WorkerCount,
+		settings.DefaultClient,
+		settings.GRPCClients,
+		settings.ErrorInjector,
+		settings.LoadGenerator,
 		logger,
-		requestGenerator,
+		settings.RequestGenerator,
+		settings.Stream,
 		waitForReadyCheck, // hard code to false until we
 		readyHandler,
 	)
 
 	api.RegisterFakeServiceServer(grpcServer, fakeServer)
 
-	return grpcServer
+	// register the health service, it reports the status of the services
+	// registered above and itself
+	services := []string{grpc_health_v1.Health_ServiceDesc.ServiceName}
+	for s := range grpcServer.GetServiceInfo() {
+		services = append(services, s)
+	}
+
+	grpc_health_v1.RegisterHealthServer(grpcServer, handlers.NewHealthGRPC(logger, healthHandler, readyHandler, services))
+
+	return grpcServer, fakeServer
+}
+
+// createSettings creates the settings for the request handlers from the
+// current configuration, existing gRPC clients and circuit breakers are
+// reused when the upstream has not changed
+func createSettings(logger *logging.Logger, c *config.Config, grpcClients map[string]client.GRPC, circuitBreakers map[string]*handlers.CircuitBreaker) (*handlers.Settings, error) {
+	requestDuration := timing.NewRequestDuration(
+		time.Duration(c.Timing.Percentile50),
+		time.Duration(c.Timing.Percentile90),
+		time.Duration(c.Timing.Percentile99),
+		c.Timing.Variance,
+	)
+
+	// create the error injector
+	errorInjector := errors.NewInjector(
+		logger.Log().Named("error_injector"),
+		c.Errors.Rate,
+		c.Errors.Code,
+		c.Errors.Type,
+		time.Duration(c.Errors.Delay),
+		c.RateLimit.RPS,
+		c.RateLimit.Code,
+	)
+
+	// create the load generator
+	// get the total CPU amount
+	// If original CPU percent is 10, however the service has only been allocated 10% of the available CPU then percent should be 1 as it is total of avaiable
+	// Allocated Percentage = Allocated / (Max * Cores) * Percentage
+	// 100 / (1000 * 10) * 10 = 1
+	cpuCores := c.Load.CPUCores
+	if cpuCores == -1 {
+		cpuCores = runtime.NumCPU()
+	}
+
+	cpuPercentage := c.Load.CPUPercentage
+	if c.Load.CPUAllocated != 0 {
+		cpuPercentage = float64(c.Load.CPUAllocated) / (float64(c.Load.CPUClockSpeed) * float64(cpuCores)) * float64(cpuPercentage)
+	}
+
+	// create a generator that will be used to create memory and CPU load per request
+	generator := load.NewGenerator(cpuCores, cpuPercentage, c.Load.MemoryPerRequest, c.Load.MemoryVariance, logger.Log().Named("load_generator"))
+	requestGenerator := load.NewRequestGenerator(c.Upstream.RequestBody, c.Upstream.RequestSize, c.Upstream.RequestVariance, int64(c.Seed))
+
+	// load the route table, requests that do not match a route fall back to
+	// the default configuration
+	var routeTable *routes.Table
+	if c.RoutesFile != "" {
+		var err error
+		routeTable, err = routes.Load(c.RoutesFile, logger.Log().Named("routes"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to load routes: %s", err)
+		}
+
+		logger.Log().Info("Loaded routes", "file", c.RoutesFile, "count", len(routeTable.Routes()))
+	}
+
+	// the messages are rendered as templates for every request
+	messages := []string{c.Message}
+	for _, rt := range routeTable.Routes() {
+		messages = append(messages, rt.Message)
+	}
+
+	bodyTemplates, err := handlers.NewBodyTemplates(messages...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message: %s", err)
+	}
+
+	// create the httpClient
+	defaultClient := client.NewHTTP(c.HTTPClient.KeepAlives, c.HTTPClient.AppendRequest, time.Duration(c.HTTPClient.RequestTimeout), c.Upstream.AllowInsecure, nil, c.HTTPClient.Protocol)
+
+	if len(c.Upstream.URIs) > 0 && len(c.Upstream.Plan) > 0 {
+		return nil, fmt.Errorf("upstream plan can not be used with upstream URIs")
+	}
+
+	if c.Upstream.FailurePolicy == handlers.FailOnQuorum && c.Upstream.Quorum < 1 {
+		return nil, fmt.Errorf("upstream quorum must be set when the failure policy is quorum")
+	}
+
+	failurePolicy := handlers.FailurePolicy{
+		Type:         c.Upstream.FailurePolicy,
+		Quorum:       c.Upstream.Quorum,
+		DegradedCode: c.Upstream.DegradedCode,
+	}
+
+	calls := append([]config.UpstreamCall{}, c.Upstream.URIs...)
+	calls = append(calls, config.PlanCalls(c.Upstream.Plan)...)
+	calls = append(calls, routeTable.Upstreams()...)
+
+	upstreams, err := createUpstreams(logger, c, calls, defaultClient, circuitBreakers)
+	if err != nil {
+		return nil, err
+	}
+
+	breakers := map[string]*handlers.CircuitBreaker{}
+	for uri, u := range upstreams {
+		if u.CircuitBreaker != nil {
+			breakers[uri] = u.CircuitBreaker
+		}
+	}
+
+	// build the map of gRPCClients, an existing client is reused when its
+	// TLS settings have not changed
+	clients := make(map[string]client.GRPC)
+	for _, uc := range calls {
+		u := uc.URI
+		if _, ok := clients[u]; ok || !config.IsGRPC(u) {
+			continue
+		}
+
+		tlsOptions := upstreamTLSOptions(c, uc)
+
+		if gc, ok := grpcClients[u]; ok {
+			gi, isImpl := gc.(*client.GRPCImpl)
+			if !isImpl || reflect.DeepEqual(gi.TLSOptions(), tlsOptions) {
+				clients[u] = gc
+				continue
+			}
+		}
+
+		//strip the grpc:// or grpcs:// from the uri
+		u2 := strings.TrimPrefix(strings.TrimPrefix(u, "grpc://"), "grpcs://")
+
+		gc, err := client.NewGRPC(u2, time.Duration(c.HTTPClient.RequestTimeout), tlsOptions)
+		if err != nil {
+			return nil, fmt.Errorf("error creating GRPC client for %s: %s", u, err)
+		}
+
+		clients[u] = gc
+	}
+
+	streamErrorCode, err := config.ParseGRPCCode(c.GRPCStream.ErrorCode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gRPC stream error code: %s", err)
+	}
+
+	if c.WebSocket.Mode == handlers.WebSocketPush && c.WebSocket.PushInterval <= 0 {
+		return nil, fmt.Errorf("websocket push interval must be greater than 0 when the mode is push")
+	}
+
+	if c.WebSocket.Mode == handlers.WebSocketUpstream && len(c.WebSocket.UpstreamURIs) == 0 {
+		return nil, fmt.Errorf("websocket upstream URIs must be set when the mode is upstream")
+	}
+
+	if c.Raw.Mode == handlers.RawUpstream {
+		if err := rawUpstreamsDefined(c.Raw); err != nil {
+			return nil, err
+		}
+	}
+
+	payload := c.Raw.Payload
+	if payload == "" {
+		payload = c.Message
+	}
+
+	websocketDialer, err := client.NewWebSocketDialer(time.Duration(c.HTTPClient.RequestTimeout), client.TLSOptions{
+		CALocation:    c.Upstream.TLSCALocation,
+		CertLocation:  c.Upstream.TLSCertLocation,
+		KeyLocation:   c.Upstream.TLSKeyLocation,
+		AllowInsecure: c.Upstream.AllowInsecure,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating WebSocket client: %s", err)
+	}
+
+	return &handlers.Settings{
+		Message:          c.Message,
+		BodyTemplates:    bodyTemplates,
+		Duration:         requestDuration,
+		UpstreamURIs:     config.URIs(c.Upstream.URIs),
+		Upstreams:        upstreams,
+		Plan:             c.Upstream.Plan,
+		FailurePolicy:    failurePolicy,
+		WorkerCount:      c.Upstream.Workers,
+		DefaultClient:    defaultClient,
+		GRPCClients:      clients,
+		CircuitBreakers:  breakers,
+		ErrorInjector:    errorInjector,
+		LoadGenerator:    generator,
+		RequestGenerator: requestGenerator,
+		Routes:           routeTable,
+		Stream: handlers.StreamSettings{
+			Messages:    c.GRPCStream.Messages,
+			Interval:    time.Duration(c.GRPCStream.Interval),
+			MessageSize: c.GRPCStream.MessageSize,
+			ErrorAfter:  c.GRPCStream.ErrorAfter,
+			ErrorCode:   streamErrorCode,
+		},
+		WebSocket: handlers.WebSocketSettings{
+			Mode:            c.WebSocket.Mode,
+			PushInterval:    time.Duration(c.WebSocket.PushInterval),
+			MessageSize:     c.WebSocket.MessageSize,
+			Lifetime:        time.Duration(c.WebSocket.Lifetime),
+			DisconnectAfter: c.WebSocket.DisconnectAfter,
+			DisconnectCode:  c.WebSocket.DisconnectCode,
+			UpstreamURIs:    c.WebSocket.UpstreamURIs,
+			Dialer:          websocketDialer,
+		},
+		SSE: handlers.SSESettings{
+			Mode:           c.SSE.Mode,
+			Events:         c.SSE.Events,
+			Interval:       time.Duration(c.SSE.Interval),
+			PayloadSize:    c.SSE.PayloadSize,
+			UpstreamEvents: c.SSE.UpstreamEvents,
+		},
+		Raw: handlers.RawSettings{
+			Mode:         c.Raw.Mode,
+			Payload:      []byte(payload),
+			UpstreamURIs: c.Raw.UpstreamURIs,
+			Timeout:      time.Duration(c.HTTPClient.RequestTimeout),
+		},
+	}, nil
+}
+
+// rawUpstreamsDefined checks that each of the raw listeners which are enabled
+// has upstreams for its network
+func rawUpstreamsDefined(r config.Raw) error {
+	listeners := map[string]string{"tcp": r.TCPListenAddr, "udp": r.UDPListenAddr}
+
+	for _, network := range []string{"tcp", "udp"} {
+		if listeners[network] == "" {
+			continue
+		}
+
+		found := false
+		for _, u := range r.UpstreamURIs {
+			if strings.HasPrefix(u, network+"://") {
+				found = true
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("raw upstream URIs must contain a %s:// URI when the mode is upstream", network)
+		}
+	}
+
+	return nil
+}
+
+// createUpstreams creates the settings for the upstreams which define how they
+// are called, h3:// upstreams and upstreams which change the timeout, expected
+// codes, appending of the request or protocol use their own HTTP client. The
+// existing circuit breaker for an upstream is reused when its policy has not
+// changed.
+func createUpstreams(logger *logging.Logger, c *config.Config, calls []config.UpstreamCall, defaultClient client.HTTP, circuitBreakers map[string]*handlers.CircuitBreaker) (map[string]*handlers.Upstream, error) {
+	defined := map[string]config.UpstreamCall{}
+	upstreams := map[string]*handlers.Upstream{}
+
+	for _, uc := range calls {
+		// upstreams are referenced by URI, the same URI can only be called
+		// with a single set of settings
+		if d, ok := defined[uc.URI]; ok {
+			if !reflect.DeepEqual(d, uc) {
+				return nil, fmt.Errorf("upstream %s is defined more than once with different settings", uc.URI)
+			}
+
+			continue
+		}
+
+		defined[uc.URI] = uc
+
+		httpClient := defaultClient
+		if uc.Timeout > 0 || len(uc.ExpectedCodes) > 0 || uc.AppendRequest != nil || uc.Protocol != "" || config.IsHTTP3(uc.URI) {
+			timeout := c.HTTPClient.RequestTimeout
+			if uc.Timeout > 0 {
+				timeout = uc.Timeout
+			}
+
+			appendRequest := c.HTTPClient.AppendRequest
+			if uc.AppendRequest != nil {
+				appendRequest = *uc.AppendRequest
+			}
+
+			protocol := c.HTTPClient.Protocol
+			if uc.Protocol != "" {
+				protocol = uc.Protocol
+			}
+
+			// h3:// upstreams are always called using HTTP/3
+			if config.IsHTTP3(uc.URI) {
+				protocol = client.HTTP3
+			}
+
+			httpClient = client.NewHTTP(c.HTTPClient.KeepAlives, appendRequest, time.Duration(timeout), c.Upstream.AllowInsecure, uc.ExpectedCodes, protocol)
+		}
+
+		u, err := handlers.NewUpstream(uc, httpClient)
+		if err != nil {
+			return nil, err
+		}
+
+		if uc.CircuitBreaker != nil {
+			cb, ok := circuitBreakers[uc.URI]
+			if !ok || cb.Policy() != *uc.CircuitBreaker {
+				cb = handlers.NewCircuitBreaker(uc.URI, *uc.CircuitBreaker, logger)
+			}
+
+			u.CircuitBreaker = cb
+		}
+
+		upstreams[uc.URI] = u
+	}
+
+	return upstreams, nil
+}
+
+// upstreamTLSOptions returns the TLS options for a gRPC upstream, the
+// settings in the upstream tls block override the UPSTREAM_TLS settings. nil
+// is returned for grpc:// upstreams which are not encrypted.
+func upstreamTLSOptions(c *config.Config, uc config.UpstreamCall) *client.TLSOptions {
+	if !strings.HasPrefix(uc.URI, "grpcs://") {
+		return nil
+	}
+
+	o := &client.TLSOptions{
+		CALocation:    c.Upstream.TLSCALocation,
+		CertLocation:  c.Upstream.TLSCertLocation,
+		KeyLocation:   c.Upstream.TLSKeyLocation,
+		AllowInsecure: c.Upstream.AllowInsecure,
+	}
+
+	if t := uc.TLS; t != nil {
+		if t.CALocation != "" {
+			o.CALocation = t.CALocation
+		}
+
+		if t.CertLocation != "" {
+			o.CertLocation = t.CertLocation
+			o.KeyLocation = t.KeyLocation
+		}
+
+		o.ServerName = t.ServerName
+	}
+
+	return o
 }
 
 // tidyURIs splits the upstream URIs passed by environment variable and returns
@@ -1,9 +1,42 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/grpc/api"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/soheilhy/cmux"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 )
 
 func TestSanitisesURIParameters(t *testing.T) {
@@ -15,3 +48,399 @@ func TestSanitisesURIParameters(t *testing.T) {
 	assert.Equal(t, "http://abc.com", out[0])
 	assert.Equal(t, "https://123.com", out[1])
 }
+
+type testGRPCServer struct {
+	api.UnimplementedFakeServiceServer
+}
+
+// Handle returns the SPIFFE ID of the client
+func (s *testGRPCServer) Handle(ctx context.Context, r *api.Request) (*api.Response, error) {
+	p, _ := peer.FromContext(ctx)
+	ti, _ := p.AuthInfo.(credentials.TLSInfo)
+
+	if pc := response.NewPeerCertificate(&ti.State); pc != nil {
+		return &api.Response{Message: pc.SPIFFEID}, nil
+	}
+
+	return &api.Response{}, nil
+}
+
+// writeTestCert creates a certificate signed by the parent, or a self signed
+// CA when parent is nil, and writes the PEM encoded cert and key to dir
+func writeTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		URIs:         []*url.URL{{Scheme: "spiffe", Host: "cluster.local", Path: "/ns/default/sa/" + name}},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+
+	kd, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	require.NoError(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kd}), 0600)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return cert, key
+}
+
+// setupServer starts HTTP and gRPC servers which share the listener, the
+// HTTP server returns the SPIFFE ID of the client certificate
+func setupServer(t *testing.T, l net.Listener) {
+	m := cmux.New(l)
+	httpListeners, grpcListener := muxListeners(m)
+
+	gs := grpc.NewServer(grpc.Creds(muxTLSCredentials{}))
+	api.RegisterFakeServiceServer(gs, &testGRPCServer{})
+
+	hs := &http.Server{
+		ConnContext: tlsConnContext,
+		Handler: withHTTP2(withTLSState(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if pc := response.NewPeerCertificate(r.TLS); pc != nil {
+				rw.Write([]byte(pc.SPIFFEID))
+			}
+		})), 0),
+	}
+
+	go gs.Serve(grpcListener)
+	for _, hl := range httpListeners {
+		go hs.Serve(hl)
+	}
+	go m.Serve()
+
+	t.Cleanup(func() {
+		hs.Close()
+		gs.Stop()
+		l.Close()
+	})
+}
+
+// setupMTLSServer starts HTTP and gRPC servers which share a TLS listener
+// and returns the address and the directory containing the certificates
+func setupMTLSServer(t *testing.T, clientAuth string) (string, string) {
+	dir := t.TempDir()
+	ca, caKey := writeTestCert(t, dir, "ca", nil, nil)
+	writeTestCert(t, dir, "api.mesh", ca, caKey)
+	writeTestCert(t, dir, "web", ca, caKey)
+
+	certs, err := loadCertificates(filepath.Join(dir, "api.mesh.pem"), filepath.Join(dir, "api.mesh-key.pem"), filepath.Join(dir, "ca.pem"))
+	require.NoError(t, err)
+
+	c, err := createTLSConfig(certs, clientAuth)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	setupServer(t, tls.NewListener(l, c))
+
+	return l.Addr().String(), dir
+}
+
+func TestGRPCClientCallsMTLSServer(t *testing.T) {
+	addr, dir := setupMTLSServer(t, "verify")
+
+	gc, err := client.NewGRPC(addr, time.Second, &client.TLSOptions{
+		CALocation:   filepath.Join(dir, "ca.pem"),
+		CertLocation: filepath.Join(dir, "web.pem"),
+		KeyLocation:  filepath.Join(dir, "web-key.pem"),
+		ServerName:   "api.mesh",
+	})
+	require.NoError(t, err)
+
+	resp, _, err := gc.Handle(context.Background(), &api.Request{})
+	require.NoError(t, err)
+
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/web", resp.Message)
+}
+
+func TestGRPCClientWithoutCertificateIsRejected(t *testing.T) {
+	addr, dir := setupMTLSServer(t, "verify")
+
+	gc, err := client.NewGRPC(addr, time.Second, &client.TLSOptions{
+		CALocation: filepath.Join(dir, "ca.pem"),
+		ServerName: "api.mesh",
+	})
+	require.NoError(t, err)
+
+	_, _, err = gc.Handle(context.Background(), &api.Request{})
+	assert.Error(t, err)
+}
+
+func TestGRPCClientVerifiesServerName(t *testing.T) {
+	addr, dir := setupMTLSServer(t, "verify")
+
+	gc, err := client.NewGRPC(addr, time.Second, &client.TLSOptions{
+		CALocation:   filepath.Join(dir, "ca.pem"),
+		CertLocation: filepath.Join(dir, "web.pem"),
+		KeyLocation:  filepath.Join(dir, "web-key.pem"),
+	})
+	require.NoError(t, err)
+
+	_, _, err = gc.Handle(context.Background(), &api.Request{})
+	assert.Error(t, err)
+}
+
+func TestHTTPRequestHasClientCertificate(t *testing.T) {
+	addr, dir := setupMTLSServer(t, "require")
+
+	c, err := client.NewTLSConfig(client.TLSOptions{
+		CALocation:   filepath.Join(dir, "ca.pem"),
+		CertLocation: filepath.Join(dir, "web.pem"),
+		KeyLocation:  filepath.Join(dir, "web-key.pem"),
+		ServerName:   "api.mesh",
+	})
+	require.NoError(t, err)
+
+	hc := &http.Client{Transport: &http.Transport{TLSClientConfig: c}}
+	resp, err := hc.Get("https://" + addr + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	d, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/web", string(d))
+}
+
+func TestHTTP2IsNegotiatedForTLS(t *testing.T) {
+	addr, dir := setupMTLSServer(t, "require")
+
+	c, err := client.NewTLSConfig(client.TLSOptions{
+		CALocation:   filepath.Join(dir, "ca.pem"),
+		CertLocation: filepath.Join(dir, "web.pem"),
+		KeyLocation:  filepath.Join(dir, "web-key.pem"),
+		ServerName:   "api.mesh",
+	})
+	require.NoError(t, err)
+
+	hc := &http.Client{Transport: &http.Transport{TLSClientConfig: c, ForceAttemptHTTP2: true}}
+	resp, err := hc.Get("https://" + addr + "/")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	d, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "HTTP/2.0", resp.Proto)
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/web", string(d))
+}
+
+func TestH2CRequestIsServed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	setupServer(t, l)
+
+	hc := client.NewHTTP(false, false, time.Second, false, nil, client.HTTP2)
+	r, _ := http.NewRequest(http.MethodGet, "http://"+l.Addr().String()+"/", nil)
+
+	code, _, _, _, protocol, err := hc.Do(r, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "HTTP/2.0", protocol)
+}
+
+func TestH2CConnectionIsReused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	setupServer(t, l)
+
+	hc := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	// the settings acknowledgements are received by the server after the
+	// first request, the connection is closed if they are not accepted
+	reused := false
+	for i := 0; i < 2; i++ {
+		ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+			GotConn: func(ci httptrace.GotConnInfo) { reused = ci.Reused },
+		})
+
+		r, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+l.Addr().String()+"/", nil)
+
+		resp, err := hc.Do(r)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	assert.True(t, reused)
+}
+
+func TestGRPCIsServedAlongsideH2C(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	setupServer(t, l)
+
+	gc, err := client.NewGRPC(l.Addr().String(), time.Second, nil)
+	require.NoError(t, err)
+
+	_, _, err = gc.Handle(context.Background(), &api.Request{})
+	assert.NoError(t, err)
+}
+
+func TestCreateTLSConfigRequiresCAToVerifyClients(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "api", nil, nil)
+
+	certs, err := loadCertificates(filepath.Join(dir, "api.pem"), filepath.Join(dir, "api-key.pem"), "")
+	require.NoError(t, err)
+
+	_, err = createTLSConfig(certs, "verify")
+	assert.Error(t, err)
+}
+
+func TestCreateTLSConfigSetsClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "api", nil, nil)
+
+	certs, err := loadCertificates(filepath.Join(dir, "api.pem"), filepath.Join(dir, "api-key.pem"), "")
+	require.NoError(t, err)
+
+	c, err := createTLSConfig(certs, "require")
+	require.NoError(t, err)
+
+	assert.Equal(t, tls.RequireAnyClientCert, c.ClientAuth)
+}
+
+func TestCertificatesAreReloadedWhenFilesChange(t *testing.T) {
+	dir := t.TempDir()
+	first, _ := writeTestCert(t, dir, "api", nil, nil)
+
+	certs, err := loadCertificates(filepath.Join(dir, "api.pem"), filepath.Join(dir, "api-key.pem"), "")
+	require.NoError(t, err)
+
+	stop := certs.watch(10*time.Millisecond, logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil))
+	defer stop()
+
+	// ensure the modification time changes
+	time.Sleep(20 * time.Millisecond)
+	second, _ := writeTestCert(t, dir, "api", nil, nil)
+	require.NotEqual(t, first.SerialNumber, second.SerialNumber)
+
+	assert.Eventually(t, func() bool {
+		cert, _ := certs.current()
+		return bytes.Equal(cert.Certificate[0], second.Raw)
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCertificatesAreKeptWhenReloadFails(t *testing.T) {
+	dir := t.TempDir()
+	first, _ := writeTestCert(t, dir, "api", nil, nil)
+
+	certs, err := loadCertificates(filepath.Join(dir, "api.pem"), filepath.Join(dir, "api-key.pem"), "")
+	require.NoError(t, err)
+
+	os.WriteFile(filepath.Join(dir, "api-key.pem"), []byte("invalid"), 0600)
+	assert.Error(t, certs.load())
+
+	cert, _ := certs.current()
+	assert.Equal(t, first.Raw, cert.Certificate[0])
+}
+
+func TestSelfSignedCertificateIsVerifiedUsingCA(t *testing.T) {
+	certs, err := newSelfSignedCertificates(selfSignedHosts("web", []string{"web.default.svc"}), "")
+	require.NoError(t, err)
+
+	rr := httptest.NewRecorder()
+	certs.HandleCA(rr, httptest.NewRequest(http.MethodGet, "/tls/ca.pem", nil))
+
+	roots := x509.NewCertPool()
+	require.True(t, roots.AppendCertsFromPEM(rr.Body.Bytes()))
+
+	cert, _ := certs.current()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+
+	for _, name := range []string{"web", "web.default.svc", "localhost", "127.0.0.1"} {
+		_, err = leaf.Verify(x509.VerifyOptions{DNSName: name, Roots: roots})
+		assert.NoError(t, err, name)
+	}
+}
+
+// setupHTTP3Server starts a HTTP/3 server using a self signed certificate, the
+// handler returns the protocol of the request
+func setupHTTP3Server(t *testing.T) *http3.Server {
+	certs, err := newSelfSignedCertificates(selfSignedHosts("api", nil), "")
+	require.NoError(t, err)
+
+	c, err := createTLSConfig(certs, "none")
+	require.NoError(t, err)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := createHTTP3Server(pc.LocalAddr().String(), c)
+	s.Handler = http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(r.Proto))
+	})
+
+	go s.Serve(pc)
+	t.Cleanup(func() {
+		s.Close()
+		pc.Close()
+	})
+
+	return s
+}
+
+func TestHTTP3RequestIsServed(t *testing.T) {
+	s := setupHTTP3Server(t)
+
+	hc := client.NewHTTP(false, false, time.Second, true, nil, client.HTTP3)
+	r, _ := http.NewRequest(http.MethodGet, "h3://"+s.Addr+"/", nil)
+
+	code, _, _, _, protocol, err := hc.Do(r, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "HTTP/3.0", protocol)
+}
+
+func TestResponsesAdvertiseHTTP3(t *testing.T) {
+	s := setupHTTP3Server(t)
+	_, port, _ := net.SplitHostPort(s.Addr)
+
+	h := withAltSvc(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}), s)
+
+	// the header is only set once the server is listening
+	require.Eventually(t, func() bool {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		return strings.Contains(rr.Header().Get("Alt-Svc"), fmt.Sprintf(`h3=":%s"`, port))
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/env"
+	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/handlers"
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// settingsUpdater is implemented by handlers which can have their settings
+// replaced while the service is running
+type settingsUpdater interface {
+	Update(s handlers.Settings)
+}
+
+// reloader holds the effective configuration for the service and recreates
+// the settings for the request handlers when the config file changes or the
+// configuration is modified using the admin API
+type reloader struct {
+	logger *logging.Logger
+	// environment contains the variables which were set before the config
+	// file was loaded
+	environment map[string]bool
+	// fileEnvironment contains the variables which were set from the config file
+	fileEnvironment map[string]string
+	grpcClients     map[string]client.GRPC
+	circuitBreakers map[string]*handlers.CircuitBreaker
+	targets         []settingsUpdater
+	health          *handlers.Health
+	current         config.Config
+	// overrides contains the fields which have been changed using the admin
+	// API, the values are kept when the configuration is reloaded
+	overrides map[string]bool
+	mutex     sync.Mutex
+}
+
+// reload the config file and update the settings for the handlers, if the
+// new configuration is invalid the current settings are not changed
+func (r *reloader) reload() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	fe, err := applyConfigFile(*configFile, r.environment, r.fileEnvironment)
+	if err != nil {
+		r.logger.Log().Error("Unable to reload config file, keeping current configuration", "error", err)
+		return
+	}
+
+	r.fileEnvironment = fe
+
+	c, err := environmentConfig()
+	if err != nil {
+		r.logger.Log().Error("Unable to reload configuration, keeping current configuration", "error", err)
+		return
+	}
+
+	if fields := c.ResetRestartValues(&r.current); len(fields) > 0 {
+		r.logger.Log().Warn("Ignoring changes to values which require a restart", "fields", strings.Join(fields, ","))
+	}
+
+	if fields := c.KeepValues(&r.current, r.overrides); len(fields) > 0 {
+		r.logger.Log().Warn("Keeping values changed using the admin API", "fields", strings.Join(fields, ","))
+	}
+
+	err = r.apply(*c)
+	if err != nil {
+		r.logger.Log().Error("Unable to reload configuration, keeping current configuration", "error", err)
+		return
+	}
+
+	r.logger.Log().Info("Reloaded configuration", "upstreamURIs", strings.Join(config.URIs(c.Upstream.URIs), ","))
+}
+
+// Config returns the effective configuration
+func (r *reloader) Config() config.Config {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.current
+}
+
+// Update validates the changes to the configuration and updates the settings
+// for the handlers, the changed values are kept when the configuration is
+// reloaded
+func (r *reloader) Update(c config.Config) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if errs := c.ValidateChanges(&r.current); len(errs) > 0 {
+		return errs
+	}
+
+	changed := c.ChangedValues(&r.current)
+
+	err := r.apply(c)
+	if err != nil {
+		return err
+	}
+
+	if r.overrides == nil {
+		r.overrides = map[string]bool{}
+	}
+
+	for _, f := range changed {
+		r.overrides[f] = true
+	}
+
+	return nil
+}
+
+// CircuitBreakers returns the circuit breakers for the upstreams
+func (r *reloader) CircuitBreakers() map[string]*handlers.CircuitBreaker {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.circuitBreakers
+}
+
+// apply creates the settings from the configuration and updates the
+// handlers, the caller must hold the mutex
+func (r *reloader) apply(c config.Config) error {
+	s, err := createSettings(r.logger, &c, r.grpcClients, r.circuitBreakers)
+	if err != nil {
+		return err
+	}
+
+	grpcServices, err := config.ParseGRPCHealth(c.Health.GRPCServices)
+	if err != nil {
+		return err
+	}
+
+	r.grpcClients = s.GRPCClients
+	r.circuitBreakers = s.CircuitBreakers
+
+	for _, t := range r.targets {
+		t.Update(*s)
+	}
+
+	r.health.SetStatusCode(c.Health.ResponseCode)
+	r.health.SetGRPCServices(grpcServices)
+	r.current = c
+
+	return nil
+}
+
+// watch the config and route files for changes and reload when they change
+func (r *reloader) watch(interval time.Duration) {
+	config.Watch(interval, r.files, func() {
+		r.logger.Log().Info("Configuration files changed, reloading configuration")
+		r.reload()
+	})
+}
+
+// files returns the location of the files which contain configuration
+func (r *reloader) files() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return []string{*configFile, r.current.RoutesFile}
+}
+
+// applyConfigFile sets the environment variables for the values defined in
+// the config file and parses the environment. Variables which are in the
+// original environment are not changed, variables which were previously set
+// from the file and have since been removed are unset.
+func applyConfigFile(file string, environment map[string]bool, previous map[string]string) (map[string]string, error) {
+	if file == "" {
+		env.Parse()
+		return nil, nil
+	}
+
+	f, err := config.Load(file)
+	if err != nil {
+		return nil, err
+	}
+
+	current := map[string]string{}
+	for k, v := range f.Environment() {
+		if environment[k] {
+			continue
+		}
+
+		current[k] = v
+		os.Setenv(k, v)
+	}
+
+	for k := range previous {
+		if _, ok := current[k]; !ok {
+			os.Unsetenv(k)
+		}
+	}
+
+	env.Parse()
+
+	return current, nil
+}
+
+// environmentVariables returns the names of the variables which have a value
+// in the environment
+func environmentVariables() map[string]bool {
+	vars := map[string]bool{}
+	for _, e := range os.Environ() {
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) == 2 && parts[1] != "" {
+			vars[parts[0]] = true
+		}
+	}
+
+	return vars
+}
+
+// environmentConfig returns the configuration defined by the environment
+// variables
+func environmentConfig() (*config.Config, error) {
+	upstreams, err := config.ParseUpstreamCalls(*upstreamURIs)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := config.ParsePlan(*upstreamPlan)
+	if err != nil {
+		return nil, err
+	}
+
+	return &config.Config{
+		Name:       *name,
+		Message:    *message,
+		ListenAddr: *listenAddress,
+		RoutesFile: *routesFile,
+		UIPath:     *uiPath,
+		Seed:       *seed,
+		Upstream: config.Upstream{
+			URIs:            upstreams,
+			Plan:            plan,
+			Workers:         *upstreamWorkers,
+			FailurePolicy:   *upstreamFailurePolicy,
+			Quorum:          *upstreamQuorum,
+			DegradedCode:    *upstreamDegradedCode,
+			AllowInsecure:   *upstreamAllowInsecure,
+			RequestBody:     *upstreamRequestBody,
+			RequestSize:     *upstreamRequestSize,
+			RequestVariance: *upstreamRequestVariance,
+			TLSCALocation:   *upstreamTLSCA,
+			TLSCertLocation: *upstreamTLSCertificate,
+			TLSKeyLocation:  *upstreamTLSKey,
+		},
+		HTTPClient: config.HTTPClient{
+			KeepAlives:     *upstreamClientKeepAlives,
+			AppendRequest:  *upstreamAppendRequest,
+			RequestTimeout: config.Duration(*upstreamRequestTimeout),
+			Protocol:       *upstreamProtocol,
+		},
+		HTTPServer: config.HTTPServer{
+			KeepAlives:        *serverKeepAlives,
+			ReadTimeout:       config.Duration(*serverReadTimeout),
+			ReadHeaderTimeout: config.Duration(*serverReadHeaderTimeout),
+			WriteTimeout:      config.Duration(*serverWriteTimeout),
+			IdleTimeout:       config.Duration(*serverIdleTimeout),
+			HTTP3ListenAddr:   *serverHTTP3ListenAddress,
+		},
+		CORS: config.CORS{
+			AllowedOrigins:   tidyURIs(*allowedOrigins),
+			AllowedHeaders:   tidyURIs(*allowedHeaders),
+			AllowCredentials: *allowCredentials,
+		},
+		Timing: config.Timing{
+			Percentile50: config.Duration(*timing50Percentile),
+			Percentile90: config.Duration(*timing90Percentile),
+			Percentile99: config.Duration(*timing99Percentile),
+			Variance:     *timingVariance,
+		},
+		Errors: config.Errors{
+			Rate:  *errorRate,
+			Type:  *errorType,
+			Code:  *errorCode,
+			Delay: config.Duration(*errorDelay),
+		},
+		RateLimit: config.RateLimit{
+			RPS:  *rateLimitRPS,
+			Code: *rateLimitCode,
+		},
+		GRPCStream: config.GRPCStream{
+			Messages:    *grpcStreamMessages,
+			Interval:    config.Duration(*grpcStreamInterval),
+			MessageSize: *grpcStreamMessageSize,
+			ErrorAfter:  *grpcStreamErrorAfter,
+			ErrorCode:   *grpcStreamErrorCode,
+		},
+		WebSocket: config.WebSocket{
+			Mode:            *websocketMode,
+			PushInterval:    config.Duration(*websocketPushInterval),
+			MessageSize:     *websocketMessageSize,
+			Lifetime:        config.Duration(*websocketLifetime),
+			DisconnectAfter: *websocketDisconnectAfter,
+			DisconnectCode:  *websocketDisconnectCode,
+			UpstreamURIs:    tidyURIs(*websocketUpstreamURIs),
+		},
+		SSE: config.SSE{
+			Mode:           *sseMode,
+			Events:         *sseEvents,
+			Interval:       config.Duration(*sseInterval),
+			PayloadSize:    *ssePayloadSize,
+			UpstreamEvents: *sseUpstreamEvents,
+		},
+		Raw: config.Raw{
+			TCPListenAddr: *rawTCPListenAddress,
+			UDPListenAddr: *rawUDPListenAddress,
+			Mode:          *rawMode,
+			Payload:       *rawPayload,
+			UpstreamURIs:  tidyURIs(*rawUpstreamURIs),
+		},
+		Load: config.LoadGeneration{
+			CPUAllocated:     *loadCPUAllocated,
+			CPUClockSpeed:    *loadCPUClockSpeed,
+			CPUCores:         *loadCPUCores,
+			CPUPercentage:    *loadCPUPercentage,
+			MemoryPerRequest: *loadMemoryAllocated,
+			MemoryVariance:   *loadMemoryVariance,
+		},
+		Tracing: config.Tracing{
+			Zipkin:       *zipkinEndpoint,
+			DatadogHost:  *datadogTracingEndpointHost,
+			DatadogPort:  *datadogTracingEndpointPort,
+			OTLPEndpoint: *otlpTracingEndpoint,
+			OTLPProtocol: *otlpTracingProtocol,
+
+			PropagationExtract: *tracingPropagationExtract,
+			PropagationInject:  *tracingPropagationInject,
+
+			Collector:          *tracingCollector,
+			CollectorMaxTraces: *tracingCollectorMaxTraces,
+		},
+		Metrics: config.Metrics{
+			DatadogHost:        *datadogMetricsEndpointHost,
+			DatadogPort:        *datadogMetricsEndpointPort,
+			DatadogEnvironment: *datadogMetricsEnvironment,
+			Prometheus:         *prometheusMetrics,
+			OTLPEndpoint:       *otlpMetricsEndpoint,
+			OTLPProtocol:       *otlpMetricsProtocol,
+		},
+		Logging: config.Logging{
+			Format: *logFormat,
+			Level:  *logLevel,
+			Output: *logOutput,
+		},
+		TLS: config.TLS{
+			CertLocation: *tlsCertificate,
+			KeyLocation:  *tlsKey,
+			CALocation:   *tlsCA,
+			ClientAuth:   *tlsClientAuth,
+
+			SelfSigned:      *tlsSelfSigned,
+			SelfSignedHosts: *tlsSelfSignedHosts,
+		},
+		Health: config.Health{
+			ResponseCode: *healthResponseCode,
+			GRPCServices: tidyURIs(*healthGRPCServices),
+		},
+		Ready: config.Ready{
+			SuccessCode:           *readySuccessResponseCode,
+			FailureCode:           *readyFailureResponseCode,
+			RootPathWaitTillReady: *readyRootPathWaitTillReady,
+			Delay:                 config.Duration(*readyResponseDelay),
+		},
+	}, nil
+}
@@ -0,0 +1,52 @@
+package response
+
+import (
+	"crypto/tls"
+)
+
+// PeerCertificate is the identity of a client taken from the certificate it
+// presented when connecting using mutual TLS
+type PeerCertificate struct {
+	Subject string `json:"subject"`
+	// SANs are the subject alternative names, DNS names, IP addresses,
+	// email addresses and URIs
+	SANs []string `json:"sans,omitempty"`
+	// SPIFFEID is the first URI SAN with the scheme spiffe
+	SPIFFEID string `json:"spiffe_id,omitempty"`
+	// Verified is true when the certificate was verified using the CA
+	// bundle, with the client auth modes request and require the
+	// certificate is not verified
+	Verified bool `json:"verified"`
+}
+
+// NewPeerCertificate returns the identity from the certificate presented by
+// the client, nil is returned when the connection does not use TLS or the
+// client did not present a certificate
+func NewPeerCertificate(cs *tls.ConnectionState) *PeerCertificate {
+	if cs == nil || len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+
+	c := cs.PeerCertificates[0]
+	p := &PeerCertificate{
+		Subject:  c.Subject.String(),
+		SANs:     append([]string{}, c.DNSNames...),
+		Verified: len(cs.VerifiedChains) > 0,
+	}
+
+	for _, ip := range c.IPAddresses {
+		p.SANs = append(p.SANs, ip.String())
+	}
+
+	p.SANs = append(p.SANs, c.EmailAddresses...)
+
+	for _, u := range c.URIs {
+		if u.Scheme == "spiffe" && p.SPIFFEID == "" {
+			p.SPIFFEID = u.String()
+		}
+
+		p.SANs = append(p.SANs, u.String())
+	}
+
+	return p
+}
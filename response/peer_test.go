@@ -0,0 +1,36 @@
+package response
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerCertificateIsNilWithoutCertificate(t *testing.T) {
+	assert.Nil(t, NewPeerCertificate(nil))
+	assert.Nil(t, NewPeerCertificate(&tls.ConnectionState{}))
+}
+
+func TestPeerCertificateContainsIdentity(t *testing.T) {
+	c := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "web", Organization: []string{"mesh"}},
+		DNSNames:    []string{"web.default.svc"},
+		IPAddresses: []net.IP{net.ParseIP("10.5.0.2")},
+		URIs: []*url.URL{
+			{Scheme: "https", Host: "web.local"},
+			{Scheme: "spiffe", Host: "cluster.local", Path: "/ns/default/sa/web"},
+		},
+	}
+
+	p := NewPeerCertificate(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{c}})
+
+	assert.Equal(t, "CN=web,O=mesh", p.Subject)
+	assert.Equal(t, []string{"web.default.svc", "10.5.0.2", "https://web.local", "spiffe://cluster.local/ns/default/sa/web"}, p.SANs)
+	assert.Equal(t, "spiffe://cluster.local/ns/default/sa/web", p.SPIFFEID)
+	assert.False(t, p.Verified)
+}
@@ -10,19 +10,41 @@ type Response struct {
 	Name          string              `json:"name,omitempty"`
 	URI           string              `json:"uri,omitempty"` // Called URI by downstream
 	Type          string              `json:"type,omitempty"`
+	Protocol      string              `json:"protocol,omitempty"` // Negotiated HTTP protocol, e.g. HTTP/2.0
 	IPAddresses   []string            `json:"ip_addresses,omitempty"`
 	Path          []string            `json:"path,omitempty"` // Path received by upstream
 	StartTime     string              `json:"start_time,omitempty"`
 	EndTime       string              `json:"end_time,omitempty"`
 	Duration      string              `json:"duration,omitempty"`
 	Headers       map[string]string   `json:"headers,omitempty"`
+	Peer          *PeerCertificate    `json:"peer,omitempty"` // Identity of the client from its TLS certificate
 	Cookies       map[string]string   `json:"cookies,omitempty"`
 	Body          json.RawMessage     `json:"body,omitempty"`
+	Payload       string              `json:"payload,omitempty"`           // Random data sized by the message size settings
+	Sequence      int                 `json:"sequence,omitempty"`          // Position of the message in a stream
+	Received      int                 `json:"messages_received,omitempty"` // Messages read from a client stream
 	UpstreamCalls map[string]Response `json:"upstream_calls,omitempty"`
+	Attempts      []Attempt           `json:"attempts,omitempty"` // Calls made when failed calls are retried
+	Optional      bool                `json:"optional,omitempty"` // Upstream failure does not fail the request
+	Skipped       bool                `json:"skipped,omitempty"`  // Upstream was not called
+	Degraded      bool                `json:"degraded,omitempty"` // Upstream calls failed but the request succeeded
 	Code          int                 `json:"code"`
 	Error         string              `json:"error,omitempty"`
 }
 
+// Attempt records a single call to an upstream
+type Attempt struct {
+	Attempt   int    `json:"attempt"`
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+	Duration  string `json:"duration,omitempty"`
+	Backoff   string `json:"backoff,omitempty"`   // Time waited before the attempt was made
+	Hedge     bool   `json:"hedge,omitempty"`     // Duplicate call sent because the upstream was slow
+	Cancelled bool   `json:"cancelled,omitempty"` // Call cancelled because another call completed first
+	Code      int    `json:"code"`
+	Error     string `json:"error,omitempty"`
+}
+
 // ToJSON converts the response to a JSON string
 func (r *Response) ToJSON() string {
 	buffer := new(bytes.Buffer)
@@ -0,0 +1,252 @@
+package routes
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/config"
+	"github.com/nicholasjackson/fake-service/errors"
+	"github.com/nicholasjackson/fake-service/timing"
+	"gopkg.in/yaml.v3"
+)
+
+// File defines the structure of a route table file
+type File struct {
+	Routes []Definition `yaml:"routes"`
+}
+
+// Definition defines a single route as it is written in the route table file
+type Definition struct {
+	// Method is the HTTP method the route matches, an empty value or * matches all methods
+	Method string `yaml:"method"`
+	// Path is the pattern the route matches, e.g. /users/{id} or /files/{path...}
+	Path string `yaml:"path"`
+	// Message to return from the route
+	Message string `yaml:"message"`
+	// Code is the status code returned when the request succeeds, default 200
+	Code   int              `yaml:"code"`
+	Timing TimingDefinition `yaml:"timing"`
+	Errors ErrorDefinition  `yaml:"errors"`
+	// UpstreamURIs are the upstreams called by the route, each upstream is
+	// either a URI or a block of values defining how it is called
+	UpstreamURIs []config.UpstreamCall `yaml:"upstream_uris"`
+	// UpstreamPlan arranges the upstreams called by the route into stages,
+	// it can not be used with UpstreamURIs
+	UpstreamPlan []config.Stage `yaml:"upstream_plan"`
+}
+
+// TimingDefinition defines the request duration for a route
+type TimingDefinition struct {
+	Percentile50 time.Duration `yaml:"p50"`
+	Percentile90 time.Duration `yaml:"p90"`
+	Percentile99 time.Duration `yaml:"p99"`
+	Variance     int           `yaml:"variance"`
+}
+
+// ErrorDefinition defines the error injection for a route
+type ErrorDefinition struct {
+	Rate          float64       `yaml:"rate"`
+	Type          string        `yaml:"type"`
+	Code          int           `yaml:"code"`
+	Delay         time.Duration `yaml:"delay"`
+	RateLimit     float64       `yaml:"rate_limit"`
+	RateLimitCode int           `yaml:"rate_limit_code"`
+}
+
+// Route is a compiled route which can be matched against an inbound request
+type Route struct {
+	Method        string
+	Path          string
+	Message       string
+	Code          int
+	Duration      *timing.RequestDuration
+	ErrorInjector *errors.Injector
+	UpstreamURIs  []string
+	Upstreams     []config.UpstreamCall
+	Plan          []config.Stage
+
+	segments []string
+}
+
+// Table is an ordered list of routes, the first route which matches a request
+// is used
+type Table struct {
+	routes []*Route
+}
+
+// Load reads the route table from the given file
+func Load(file string, l hclog.Logger) (*Table, error) {
+	d, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read route file %s: %s", file, err)
+	}
+
+	f := File{}
+	dec := yaml.NewDecoder(bytes.NewReader(d))
+	dec.KnownFields(true)
+
+	err = dec.Decode(&f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse route file %s: %s", file, err)
+	}
+
+	return New(f.Routes, l)
+}
+
+// New creates a route table from the given definitions
+func New(defs []Definition, l hclog.Logger) (*Table, error) {
+	t := &Table{}
+
+	for i, d := range defs {
+		if !strings.HasPrefix(d.Path, "/") {
+			return nil, fmt.Errorf("route %d: path %q must start with /", i, d.Path)
+		}
+
+		if len(d.UpstreamURIs) > 0 && len(d.UpstreamPlan) > 0 {
+			return nil, fmt.Errorf("route %d: upstream_plan can not be used with upstream_uris", i)
+		}
+
+		if d.Code == 0 {
+			d.Code = http.StatusOK
+		}
+
+		if d.Errors.Type == "" {
+			d.Errors.Type = "http_error"
+		}
+
+		if d.Errors.Code == 0 {
+			d.Errors.Code = http.StatusInternalServerError
+		}
+
+		if d.Errors.RateLimitCode == 0 {
+			d.Errors.RateLimitCode = http.StatusServiceUnavailable
+		}
+
+		method := strings.ToUpper(d.Method)
+		if method == "" {
+			method = "*"
+		}
+
+		t.routes = append(t.routes, &Route{
+			Method:  method,
+			Path:    d.Path,
+			Message: d.Message,
+			Code:    d.Code,
+			Duration: timing.NewRequestDuration(
+				d.Timing.Percentile50,
+				d.Timing.Percentile90,
+				d.Timing.Percentile99,
+				d.Timing.Variance,
+			),
+			ErrorInjector: errors.NewInjector(
+				l.Named(fmt.Sprintf("%s %s", method, d.Path)),
+				d.Errors.Rate,
+				d.Errors.Code,
+				d.Errors.Type,
+				d.Errors.Delay,
+				d.Errors.RateLimit,
+				d.Errors.RateLimitCode,
+			),
+			UpstreamURIs: config.URIs(d.UpstreamURIs),
+			Upstreams:    d.UpstreamURIs,
+			Plan:         d.UpstreamPlan,
+			segments:     splitPath(d.Path),
+		})
+	}
+
+	return t, nil
+}
+
+// Routes returns the routes in the table
+func (t *Table) Routes() []*Route {
+	if t == nil {
+		return nil
+	}
+
+	return t.routes
+}
+
+// UpstreamURIs returns all the upstream URIs referenced by the routes
+func (t *Table) UpstreamURIs() []string {
+	uris := []string{}
+	for _, r := range t.Routes() {
+		uris = append(uris, r.UpstreamURIs...)
+		uris = append(uris, config.URIs(config.PlanCalls(r.Plan))...)
+	}
+
+	return uris
+}
+
+// Upstreams returns all the upstreams referenced by the routes
+func (t *Table) Upstreams() []config.UpstreamCall {
+	calls := []config.UpstreamCall{}
+	for _, r := range t.Routes() {
+		calls = append(calls, r.Upstreams...)
+		calls = append(calls, config.PlanCalls(r.Plan)...)
+	}
+
+	return calls
+}
+
+// Match returns the first route which matches the method and path of the
+// request along with any parameters captured from the path, if no route
+// matches nil is returned
+func (t *Table) Match(r *http.Request) (*Route, map[string]string) {
+	for _, rt := range t.Routes() {
+		if rt.Method != "*" && rt.Method != r.Method {
+			continue
+		}
+
+		if params, ok := rt.match(splitPath(r.URL.Path)); ok {
+			return rt, params
+		}
+	}
+
+	return nil, nil
+}
+
+// match the path segments against the route pattern
+func (rt *Route) match(segments []string) (map[string]string, bool) {
+	params := map[string]string{}
+
+	for i, s := range rt.segments {
+		// wildcard captures the remainder of the path
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "...}") {
+			params[strings.TrimSuffix(s[1:], "...}")] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+
+		if i >= len(segments) {
+			return nil, false
+		}
+
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			params[s[1:len(s)-1]] = segments[i]
+			continue
+		}
+
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+
+	return params, true
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return []string{}
+	}
+
+	return strings.Split(p, "/")
+}
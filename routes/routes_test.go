@@ -0,0 +1,116 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTable(t *testing.T) *Table {
+	rt, err := New([]Definition{
+		{Method: "GET", Path: "/users/{id}", Message: "user"},
+		{Method: "POST", Path: "/orders", Message: "order", Code: http.StatusCreated},
+		{Path: "/files/{path...}", Message: "file"},
+	}, hclog.NewNullLogger())
+
+	require.NoError(t, err)
+
+	return rt
+}
+
+func TestMatchesRouteWithParameters(t *testing.T) {
+	rt := setupTable(t)
+
+	r, params := rt.Match(httptest.NewRequest(http.MethodGet, "/users/123", nil))
+
+	require.NotNil(t, r)
+	assert.Equal(t, "user", r.Message)
+	assert.Equal(t, http.StatusOK, r.Code)
+	assert.Equal(t, "123", params["id"])
+}
+
+func TestMatchesRouteWithMethod(t *testing.T) {
+	rt := setupTable(t)
+
+	r, _ := rt.Match(httptest.NewRequest(http.MethodPost, "/orders", nil))
+	require.NotNil(t, r)
+	assert.Equal(t, http.StatusCreated, r.Code)
+
+	r, _ = rt.Match(httptest.NewRequest(http.MethodGet, "/orders", nil))
+	assert.Nil(t, r)
+}
+
+func TestMatchesRouteWithWildcard(t *testing.T) {
+	rt := setupTable(t)
+
+	r, params := rt.Match(httptest.NewRequest(http.MethodDelete, "/files/a/b/c.txt", nil))
+
+	require.NotNil(t, r)
+	assert.Equal(t, "file", r.Message)
+	assert.Equal(t, "a/b/c.txt", params["path"])
+}
+
+func TestDoesNotMatchPartialPath(t *testing.T) {
+	rt := setupTable(t)
+
+	r, _ := rt.Match(httptest.NewRequest(http.MethodGet, "/users/123/orders", nil))
+	assert.Nil(t, r)
+
+	r, _ = rt.Match(httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.Nil(t, r)
+}
+
+func TestNilTableDoesNotMatch(t *testing.T) {
+	var rt *Table
+
+	r, _ := rt.Match(httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Nil(t, r)
+}
+
+func TestLoadsRoutesFromFile(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "routes.yaml")
+	os.WriteFile(f, []byte(`
+routes:
+  - method: get
+    path: /users/{id}
+    message: '{"id": 1}'
+    timing:
+      p50: 10ms
+    errors:
+      rate: 0.5
+    upstream_uris:
+      - http://localhost:9091
+`), 0644)
+
+	rt, err := Load(f, hclog.NewNullLogger())
+	require.NoError(t, err)
+
+	require.Len(t, rt.Routes(), 1)
+	assert.Equal(t, "GET", rt.Routes()[0].Method)
+	assert.Equal(t, 10*time.Millisecond, rt.Routes()[0].Duration.Calculate())
+	assert.Equal(t, []string{"http://localhost:9091"}, rt.UpstreamURIs())
+}
+
+func TestLoadReturnsErrorForUnknownFields(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "routes.yaml")
+	os.WriteFile(f, []byte(`
+routes:
+  - path: /users
+    mesage: typo
+`), 0644)
+
+	_, err := Load(f, hclog.NewNullLogger())
+	assert.Error(t, err)
+}
+
+func TestNewReturnsErrorForInvalidPath(t *testing.T) {
+	_, err := New([]Definition{{Path: "users"}}, hclog.NewNullLogger())
+	assert.Error(t, err)
+}
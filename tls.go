@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc/credentials"
+)
+
+// clientAuthTypes are the values for TLS_CLIENT_AUTH, request asks for a
+// certificate, require fails when the client does not present one and verify
+// also checks it was signed by the CA bundle
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":    tls.NoClientCert,
+	"request": tls.RequestClientCert,
+	"require": tls.RequireAnyClientCert,
+	"verify":  tls.RequireAndVerifyClientCert,
+}
+
+// createTLSConfig creates the config for the TLS listener, the certificates
+// are read for every handshake so that they can be replaced. When clientAuth
+// is verify clients must present a certificate signed by the CA bundle.
+func createTLSConfig(certs *certificates, clientAuth string) (*tls.Config, error) {
+	config := &tls.Config{
+		Rand: rand.Reader,
+		// HTTP/2 is preferred, it is required by gRPC clients and served by
+		// the HTTP server for other clients
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+
+	if clientAuth != "" {
+		ca, ok := clientAuthTypes[clientAuth]
+		if !ok {
+			return nil, fmt.Errorf("unknown client auth %q", clientAuth)
+		}
+
+		if ca == tls.RequireAndVerifyClientCert && certs.caLocation == "" {
+			return nil, fmt.Errorf("TLS_CA_LOCATION must be set to verify client certificates")
+		}
+
+		config.ClientAuth = ca
+	}
+
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cert, clientCAs := certs.current()
+
+		c := config.Clone()
+		c.GetConfigForClient = nil
+		c.Certificates = []tls.Certificate{*cert}
+		c.ClientCAs = clientCAs
+
+		return c, nil
+	}
+
+	return config, nil
+}
+
+// tlsConn returns the TLS connection wrapped by cmux, nil is returned when the
+// listener does not use TLS
+func tlsConn(c net.Conn) *tls.Conn {
+	if sc, ok := c.(*settingsAckConn); ok {
+		c = sc.Conn
+	}
+
+	if mc, ok := c.(*cmux.MuxConn); ok {
+		c = mc.Conn
+	}
+
+	tc, _ := c.(*tls.Conn)
+
+	return tc
+}
+
+type tlsConnKey struct{}
+
+// tlsConnContext adds the TLS connection to the context for the HTTP
+// connection, it is used as the http.Server ConnContext
+func tlsConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc := tlsConn(c); tc != nil {
+		return context.WithValue(ctx, tlsConnKey{}, tc)
+	}
+
+	return ctx
+}
+
+// withTLSState sets the TLS state for requests, the HTTP server does not set
+// it as the connection is wrapped by cmux
+func withTLSState(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if tc, ok := r.Context().Value(tlsConnKey{}).(*tls.Conn); ok && r.TLS == nil {
+			cs := tc.ConnectionState()
+			r.TLS = &cs
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// muxTLSCredentials are the gRPC credentials for connections which have been
+// decrypted by the TLS listener, no handshake is performed and the state of
+// the existing connection is returned so that it can be read from the peer
+type muxTLSCredentials struct{}
+
+func (muxTLSCredentials) ServerHandshake(c net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tc := tlsConn(c)
+	if tc == nil {
+		return c, nil, nil
+	}
+
+	return c, credentials.TLSInfo{
+		State:          tc.ConnectionState(),
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}, nil
+}
+
+func (muxTLSCredentials) ClientHandshake(ctx context.Context, authority string, c net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, fmt.Errorf("client handshake is not supported")
+}
+
+func (muxTLSCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (m muxTLSCredentials) Clone() credentials.TransportCredentials {
+	return m
+}
+
+func (muxTLSCredentials) OverrideServerName(string) error {
+	return nil
+}
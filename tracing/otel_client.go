@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/opentracing/opentracing-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Protocols used to send data to an OpenTelemetry collector
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http"
+)
+
+// OTLPEndpoint is the location of an OpenTelemetry collector
+type OTLPEndpoint struct {
+	// Host and port of the collector
+	Host string
+	// Path the data is sent to, only used by the http protocol
+	Path     string
+	Insecure bool
+}
+
+// ParseOTLPEndpoint parses the URI of an OpenTelemetry collector, the scheme
+// must be http or https, when it is http TLS is not used
+func ParseOTLPEndpoint(uri string) (OTLPEndpoint, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return OTLPEndpoint{}, fmt.Errorf("invalid OTLP endpoint %s: %s", uri, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return OTLPEndpoint{}, fmt.Errorf("invalid OTLP endpoint %s, the scheme must be http or https", uri)
+	}
+
+	return OTLPEndpoint{
+		Host:     u.Host,
+		Path:     u.Path,
+		Insecure: u.Scheme == "http",
+	}, nil
+}
+
+// OTLPResource returns the resource which identifies the service in the
+// exported traces and metrics, further attributes can be added using the
+// OTEL_RESOURCE_ATTRIBUTES environment variable
+func OTLPResource(name string) (*resource.Resource, error) {
+	return resource.New(
+		context.Background(),
+		resource.WithAttributes(attribute.String("service.name", name)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+}
+
+// NewOTelClient exports traces to the OpenTelemetry collector at uri using
+// the given protocol. The spans created with the OpenTracing API are
+// converted to OpenTelemetry spans, the spans are also sent to any additional
+// processors. The returned function sends any pending spans and must be called
+// before the service exits.
+func NewOTelClient(uri, protocol, name string, processors ...sdktrace.SpanProcessor) (func(context.Context) error, error) {
+	e, err := ParseOTLPEndpoint(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	var client otlptrace.Client
+
+	switch protocol {
+	case OTLPProtocolGRPC:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(e.Host)}
+		if e.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+
+		client = otlptracegrpc.NewClient(opts...)
+	case OTLPProtocolHTTP:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(e.Host)}
+		if e.Path != "" {
+			opts = append(opts, otlptracehttp.WithURLPath(e.Path))
+		}
+
+		if e.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		client = otlptracehttp.NewClient(opts...)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %s, expected %s or %s", protocol, OTLPProtocolGRPC, OTLPProtocolHTTP)
+	}
+
+	exporter, err := otlptrace.New(context.Background(), client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OTLP trace exporter: %s", err)
+	}
+
+	res, err := OTLPResource(name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create OTLP resource: %s", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	}
+
+	for _, p := range processors {
+		opts = append(opts, sdktrace.WithSpanProcessor(p))
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+
+	// trace context is propagated using the W3C headers
+	propagator := propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+	bridge, wrapper := otbridge.NewTracerPair(tp.Tracer("github.com/nicholasjackson/fake-service"))
+	bridge.SetTextMapPropagator(propagator)
+
+	otel.SetTracerProvider(wrapper)
+	otel.SetTextMapPropagator(propagator)
+	opentracing.SetGlobalTracer(bridge)
+
+	return tp.Shutdown, nil
+}
+
+// GetOTelSpanDetails returns the ids of a span created by the OpenTelemetry
+// tracer
+func GetOTelSpanDetails(ctx opentracing.SpanContext) *SpanDetails {
+	// the span context of the bridge embeds the OpenTelemetry span context
+	if s, ok := ctx.(interface {
+		TraceID() trace.TraceID
+		SpanID() trace.SpanID
+	}); ok {
+		return &SpanDetails{
+			SpanID:  s.SpanID().String(),
+			TraceID: s.TraceID().String(),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	otbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestParseOTLPEndpointWithHTTPIsInsecure(t *testing.T) {
+	e, err := ParseOTLPEndpoint("http://collector:4317")
+	require.NoError(t, err)
+
+	assert.Equal(t, "collector:4317", e.Host)
+	assert.True(t, e.Insecure)
+}
+
+func TestParseOTLPEndpointWithHTTPSUsesTLSAndPath(t *testing.T) {
+	e, err := ParseOTLPEndpoint("https://collector:4318/custom/v1/traces")
+	require.NoError(t, err)
+
+	assert.Equal(t, "collector:4318", e.Host)
+	assert.Equal(t, "/custom/v1/traces", e.Path)
+	assert.False(t, e.Insecure)
+}
+
+func TestParseOTLPEndpointWithoutSchemeReturnsError(t *testing.T) {
+	_, err := ParseOTLPEndpoint("collector:4317")
+	assert.Error(t, err)
+}
+
+func TestNewOTelClientWithUnknownProtocolReturnsError(t *testing.T) {
+	_, err := NewOTelClient("http://collector:4317", "thrift", "web")
+	assert.Error(t, err)
+}
+
+func TestGetOTelSpanDetailsReturnsIDsForBridgeSpans(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	bridge, _ := otbridge.NewTracerPair(tp.Tracer("test"))
+
+	s := bridge.StartSpan("handle_request")
+	defer s.Finish()
+
+	sd := GetOTelSpanDetails(s.Context())
+	require.NotNil(t, sd)
+
+	assert.Len(t, sd.TraceID, 32)
+	assert.Len(t, sd.SpanID, 16)
+}
+
+func TestGetOTelSpanDetailsReturnsNilForOtherSpans(t *testing.T) {
+	s := opentracing.NoopTracer{}.StartSpan("handle_request")
+
+	assert.Nil(t, GetOTelSpanDetails(s.Context()))
+}
@@ -0,0 +1,516 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// Formats used to propagate the trace context in request headers
+const (
+	PropagationTraceContext = "tracecontext"
+	PropagationBaggage      = "baggage"
+	PropagationB3           = "b3"
+	PropagationB3Multi      = "b3multi"
+	PropagationDatadog      = "datadog"
+	PropagationJaeger       = "jaeger"
+)
+
+// TraceContext is the trace context carried by the propagation formats
+type TraceContext struct {
+	// TraceID is 32 lower case hex characters, 64 bit trace ids are padded
+	// with zeros
+	TraceID string
+	// SpanID is 16 lower case hex characters
+	SpanID  string
+	Sampled bool
+	Baggage map[string]string
+}
+
+// Propagator reads and writes the trace context using a propagation format
+type Propagator interface {
+	// Extract returns the trace context from the headers, false is returned
+	// when the headers do not contain a trace id. Baggage is returned even
+	// when there is no trace id.
+	Extract(h http.Header) (TraceContext, bool)
+	// Inject writes the trace context to the headers
+	Inject(tc TraceContext, h http.Header)
+}
+
+// NewPropagators returns the propagators for the given formats
+func NewPropagators(formats []string) ([]Propagator, error) {
+	ps := []Propagator{}
+
+	for _, f := range formats {
+		switch strings.TrimSpace(f) {
+		case PropagationTraceContext:
+			ps = append(ps, traceContextPropagator{})
+		case PropagationBaggage:
+			ps = append(ps, baggagePropagator{})
+		case PropagationB3:
+			ps = append(ps, b3Propagator{})
+		case PropagationB3Multi:
+			ps = append(ps, b3MultiPropagator{})
+		case PropagationDatadog:
+			ps = append(ps, datadogPropagator{})
+		case PropagationJaeger:
+			ps = append(ps, jaegerPropagator{})
+		case "":
+		default:
+			return nil, fmt.Errorf(
+				"unknown propagation format %s, expected one of %s",
+				f,
+				strings.Join([]string{
+					PropagationTraceContext,
+					PropagationBaggage,
+					PropagationB3,
+					PropagationB3Multi,
+					PropagationDatadog,
+					PropagationJaeger,
+				}, "|"),
+			)
+		}
+	}
+
+	return ps, nil
+}
+
+// propagationTracer wraps a tracer and translates the trace context between
+// the configured formats and the format used by the tracer
+type propagationTracer struct {
+	opentracing.Tracer
+	native  []Propagator
+	extract []Propagator
+	inject  []Propagator
+}
+
+// SetPropagation replaces the global tracer with one which extracts the
+// trace context from the first of the extract formats found in the request
+// and injects it into upstream requests using all of the inject formats.
+// native are the formats used by the global tracer, when extract or inject
+// are empty the native formats are used.
+func SetPropagation(native, extract, inject []string) error {
+	n, err := NewPropagators(native)
+	if err != nil {
+		return err
+	}
+
+	e, err := NewPropagators(extract)
+	if err != nil {
+		return err
+	}
+
+	i, err := NewPropagators(inject)
+	if err != nil {
+		return err
+	}
+
+	if len(e) == 0 && len(i) == 0 {
+		return nil
+	}
+
+	if len(e) == 0 {
+		e = n
+	}
+
+	if len(i) == 0 {
+		i = n
+	}
+
+	opentracing.SetGlobalTracer(&propagationTracer{
+		Tracer:  opentracing.GlobalTracer(),
+		native:  n,
+		extract: e,
+		inject:  i,
+	})
+
+	return nil
+}
+
+// Inject writes the span context using the inject formats
+func (t *propagationTracer) Inject(sc opentracing.SpanContext, format interface{}, carrier interface{}) error {
+	w, ok := carrier.(opentracing.TextMapWriter)
+	if !ok || (format != opentracing.HTTPHeaders && format != opentracing.TextMap) {
+		return t.Tracer.Inject(sc, format, carrier)
+	}
+
+	h := http.Header{}
+	err := t.Tracer.Inject(sc, opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+	if err != nil {
+		return err
+	}
+
+	tc, ok := extractTraceContext(t.native, h)
+	if !ok {
+		return opentracing.ErrInvalidSpanContext
+	}
+
+	out := http.Header{}
+	for _, p := range t.inject {
+		p.Inject(tc, out)
+	}
+
+	for k := range out {
+		w.Set(k, out.Get(k))
+	}
+
+	return nil
+}
+
+// Extract reads the span context using the extract formats
+func (t *propagationTracer) Extract(format interface{}, carrier interface{}) (opentracing.SpanContext, error) {
+	r, ok := carrier.(opentracing.TextMapReader)
+	if !ok || (format != opentracing.HTTPHeaders && format != opentracing.TextMap) {
+		return t.Tracer.Extract(format, carrier)
+	}
+
+	in := http.Header{}
+	r.ForeachKey(func(k, v string) error {
+		in.Add(k, v)
+		return nil
+	})
+
+	tc, ok := extractTraceContext(t.extract, in)
+	if !ok {
+		return nil, opentracing.ErrSpanContextNotFound
+	}
+
+	h := http.Header{}
+	for _, p := range t.native {
+		p.Inject(tc, h)
+	}
+
+	return t.Tracer.Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+}
+
+// extractTraceContext returns the trace context from the first propagator
+// which finds a trace id, baggage is merged from all propagators
+func extractTraceContext(ps []Propagator, h http.Header) (TraceContext, bool) {
+	tc := TraceContext{}
+	found := false
+	baggage := map[string]string{}
+
+	for _, p := range ps {
+		c, ok := p.Extract(h)
+		for k, v := range c.Baggage {
+			baggage[k] = v
+		}
+
+		if ok && !found {
+			tc = c
+			found = true
+		}
+	}
+
+	tc.Baggage = baggage
+
+	return tc, found
+}
+
+// traceContextPropagator implements the W3C trace context traceparent header
+type traceContextPropagator struct{}
+
+func (traceContextPropagator) Extract(h http.Header) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h.Get("traceparent")), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return TraceContext{}, false
+	}
+
+	traceID, ok := parseHexID(parts[1], 32)
+	if !ok {
+		return TraceContext{}, false
+	}
+
+	spanID, ok := parseHexID(parts[2], 16)
+	if !ok {
+		return TraceContext{}, false
+	}
+
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || len(parts[3]) != 2 {
+		return TraceContext{}, false
+	}
+
+	return TraceContext{TraceID: traceID, SpanID: spanID, Sampled: flags&1 == 1}, true
+}
+
+func (traceContextPropagator) Inject(tc TraceContext, h http.Header) {
+	flags := "00"
+	if tc.Sampled {
+		flags = "01"
+	}
+
+	h.Set("traceparent", fmt.Sprintf("00-%s-%s-%s", tc.TraceID, tc.SpanID, flags))
+}
+
+// baggagePropagator implements the W3C baggage header, it only carries
+// baggage and never returns a trace id
+type baggagePropagator struct{}
+
+func (baggagePropagator) Extract(h http.Header) (TraceContext, bool) {
+	b := map[string]string{}
+
+	for _, v := range h.Values("baggage") {
+		for _, m := range strings.Split(v, ",") {
+			// remove any properties from the member
+			m, _, _ = strings.Cut(m, ";")
+
+			k, v, ok := strings.Cut(m, "=")
+			if !ok {
+				continue
+			}
+
+			value, err := url.PathUnescape(strings.TrimSpace(v))
+			if err != nil {
+				continue
+			}
+
+			b[strings.TrimSpace(k)] = value
+		}
+	}
+
+	return TraceContext{Baggage: b}, false
+}
+
+func (baggagePropagator) Inject(tc TraceContext, h http.Header) {
+	if len(tc.Baggage) == 0 {
+		return
+	}
+
+	members := []string{}
+	for k, v := range tc.Baggage {
+		members = append(members, fmt.Sprintf("%s=%s", k, url.PathEscape(v)))
+	}
+
+	h.Set("baggage", strings.Join(members, ","))
+}
+
+// b3Propagator implements the Zipkin B3 single header
+type b3Propagator struct{}
+
+func (b3Propagator) Extract(h http.Header) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h.Get("b3")), "-")
+	if len(parts) < 2 {
+		return TraceContext{}, false
+	}
+
+	traceID, ok := parseHexID(parts[0], 32)
+	if !ok {
+		return TraceContext{}, false
+	}
+
+	spanID, ok := parseHexID(parts[1], 16)
+	if !ok {
+		return TraceContext{}, false
+	}
+
+	sampled := true
+	if len(parts) > 2 {
+		sampled = parts[2] == "1" || parts[2] == "d"
+	}
+
+	return TraceContext{TraceID: traceID, SpanID: spanID, Sampled: sampled}, true
+}
+
+func (b3Propagator) Inject(tc TraceContext, h http.Header) {
+	h.Set("b3", fmt.Sprintf("%s-%s-%s", tc.TraceID, tc.SpanID, b3Sampled(tc.Sampled)))
+}
+
+// b3MultiPropagator implements the Zipkin B3 X-B3-* headers
+type b3MultiPropagator struct{}
+
+func (b3MultiPropagator) Extract(h http.Header) (TraceContext, bool) {
+	traceID, ok := parseHexID(h.Get("X-B3-TraceId"), 32)
+	if !ok {
+		return TraceContext{}, false
+	}
+
+	spanID, ok := parseHexID(h.Get("X-B3-SpanId"), 16)
+	if !ok {
+		return TraceContext{}, false
+	}
+
+	sampled := true
+	if s := h.Get("X-B3-Sampled"); s != "" {
+		sampled = s == "1" || strings.EqualFold(s, "true")
+	}
+
+	if h.Get("X-B3-Flags") == "1" {
+		sampled = true
+	}
+
+	return TraceContext{TraceID: traceID, SpanID: spanID, Sampled: sampled}, true
+}
+
+func (b3MultiPropagator) Inject(tc TraceContext, h http.Header) {
+	h.Set("X-B3-TraceId", tc.TraceID)
+	h.Set("X-B3-SpanId", tc.SpanID)
+	h.Set("X-B3-Sampled", b3Sampled(tc.Sampled))
+}
+
+// datadogPropagator implements the Datadog x-datadog-* headers, the ids
+// are 64 bit decimal numbers and the upper 64 bits of the trace id are sent
+// in the _dd.p.tid tag
+type datadogPropagator struct{}
+
+const datadogBaggagePrefix = "ot-baggage-"
+
+func (datadogPropagator) Extract(h http.Header) (TraceContext, bool) {
+	b := prefixedBaggage(h, datadogBaggagePrefix)
+
+	traceID, err := strconv.ParseUint(h.Get("x-datadog-trace-id"), 10, 64)
+	if err != nil || traceID == 0 {
+		return TraceContext{Baggage: b}, false
+	}
+
+	spanID, err := strconv.ParseUint(h.Get("x-datadog-parent-id"), 10, 64)
+	if err != nil || spanID == 0 {
+		return TraceContext{Baggage: b}, false
+	}
+
+	upper := "0000000000000000"
+	for _, tag := range strings.Split(h.Get("x-datadog-tags"), ",") {
+		if k, v, ok := strings.Cut(tag, "="); ok && k == "_dd.p.tid" {
+			if id, ok := parseHexID(v, 16); ok {
+				upper = id
+			}
+		}
+	}
+
+	sampled := true
+	if p := h.Get("x-datadog-sampling-priority"); p != "" {
+		priority, err := strconv.Atoi(p)
+		sampled = err != nil || priority > 0
+	}
+
+	return TraceContext{
+		TraceID: upper + fmt.Sprintf("%016x", traceID),
+		SpanID:  fmt.Sprintf("%016x", spanID),
+		Sampled: sampled,
+		Baggage: b,
+	}, true
+}
+
+func (datadogPropagator) Inject(tc TraceContext, h http.Header) {
+	traceID, _ := strconv.ParseUint(tc.TraceID[16:], 16, 64)
+	spanID, _ := strconv.ParseUint(tc.SpanID, 16, 64)
+
+	h.Set("x-datadog-trace-id", strconv.FormatUint(traceID, 10))
+	h.Set("x-datadog-parent-id", strconv.FormatUint(spanID, 10))
+
+	if upper := tc.TraceID[:16]; upper != "0000000000000000" {
+		h.Set("x-datadog-tags", "_dd.p.tid="+upper)
+	}
+
+	priority := "0"
+	if tc.Sampled {
+		priority = "1"
+	}
+
+	h.Set("x-datadog-sampling-priority", priority)
+
+	for k, v := range tc.Baggage {
+		h.Set(datadogBaggagePrefix+k, v)
+	}
+}
+
+// jaegerPropagator implements the Jaeger uber-trace-id header
+type jaegerPropagator struct{}
+
+const jaegerBaggagePrefix = "uberctx-"
+
+func (jaegerPropagator) Extract(h http.Header) (TraceContext, bool) {
+	b := prefixedBaggage(h, jaegerBaggagePrefix)
+	for k, v := range b {
+		if uv, err := url.QueryUnescape(v); err == nil {
+			b[k] = uv
+		}
+	}
+
+	v, err := url.QueryUnescape(h.Get("uber-trace-id"))
+	if err != nil {
+		return TraceContext{Baggage: b}, false
+	}
+
+	parts := strings.Split(v, ":")
+	if len(parts) != 4 {
+		return TraceContext{Baggage: b}, false
+	}
+
+	traceID, ok := parseHexID(parts[0], 32)
+	if !ok {
+		return TraceContext{Baggage: b}, false
+	}
+
+	spanID, ok := parseHexID(parts[1], 16)
+	if !ok {
+		return TraceContext{Baggage: b}, false
+	}
+
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return TraceContext{Baggage: b}, false
+	}
+
+	return TraceContext{TraceID: traceID, SpanID: spanID, Sampled: flags&1 == 1, Baggage: b}, true
+}
+
+func (jaegerPropagator) Inject(tc TraceContext, h http.Header) {
+	flags := "0"
+	if tc.Sampled {
+		flags = "1"
+	}
+
+	h.Set("uber-trace-id", fmt.Sprintf("%s:%s:0:%s", tc.TraceID, tc.SpanID, flags))
+
+	for k, v := range tc.Baggage {
+		h.Set(jaegerBaggagePrefix+k, url.QueryEscape(v))
+	}
+}
+
+// parseHexID validates a hex encoded id and left pads it with zeros to the
+// given length, ids which are all zeros are invalid
+func parseHexID(id string, length int) (string, bool) {
+	id = strings.ToLower(strings.TrimSpace(id))
+	if id == "" || len(id) > length {
+		return "", false
+	}
+
+	if strings.Trim(id, "0") == "" {
+		return "", false
+	}
+
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return "", false
+		}
+	}
+
+	return strings.Repeat("0", length-len(id)) + id, true
+}
+
+func b3Sampled(sampled bool) string {
+	if sampled {
+		return "1"
+	}
+
+	return "0"
+}
+
+// prefixedBaggage returns the baggage from the headers with the prefix
+func prefixedBaggage(h http.Header, prefix string) map[string]string {
+	b := map[string]string{}
+
+	for k := range h {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, prefix) {
+			b[strings.TrimPrefix(lk, prefix)] = h.Get(k)
+		}
+	}
+
+	return b
+}
@@ -0,0 +1,137 @@
+package tracing
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	otbridge "go.opentelemetry.io/otel/bridge/opentracing"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var testTraceContext = TraceContext{
+	TraceID: "0af7651916cd43dd8448eb211c80319c",
+	SpanID:  "b7ad6b7169203331",
+	Sampled: true,
+	Baggage: map[string]string{},
+}
+
+func setupPropagation(t *testing.T, extract, inject []string) {
+	tp := sdktrace.NewTracerProvider()
+	bridge, _ := otbridge.NewTracerPair(tp.Tracer("test"))
+	bridge.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	opentracing.SetGlobalTracer(bridge)
+	t.Cleanup(func() {
+		opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+	})
+
+	err := SetPropagation([]string{PropagationTraceContext, PropagationBaggage}, extract, inject)
+	require.NoError(t, err)
+}
+
+func TestPropagatorsRoundTripTraceContext(t *testing.T) {
+	for _, f := range []string{
+		PropagationTraceContext,
+		PropagationB3,
+		PropagationB3Multi,
+		PropagationDatadog,
+		PropagationJaeger,
+	} {
+		t.Run(f, func(t *testing.T) {
+			ps, err := NewPropagators([]string{f})
+			require.NoError(t, err)
+
+			h := http.Header{}
+			ps[0].Inject(testTraceContext, h)
+
+			tc, ok := ps[0].Extract(h)
+			require.True(t, ok)
+
+			assert.Equal(t, testTraceContext.TraceID, tc.TraceID)
+			assert.Equal(t, testTraceContext.SpanID, tc.SpanID)
+			assert.True(t, tc.Sampled)
+		})
+	}
+}
+
+func TestNewPropagatorsWithUnknownFormatReturnsError(t *testing.T) {
+	_, err := NewPropagators([]string{"tracecontext", "xray"})
+	assert.Error(t, err)
+}
+
+func TestB3MultiPads64BitTraceID(t *testing.T) {
+	h := http.Header{}
+	h.Set("X-B3-TraceId", "8448eb211c80319c")
+	h.Set("X-B3-SpanId", "b7ad6b7169203331")
+
+	tc, ok := b3MultiPropagator{}.Extract(h)
+	require.True(t, ok)
+
+	assert.Equal(t, "00000000000000008448eb211c80319c", tc.TraceID)
+	assert.True(t, tc.Sampled)
+}
+
+func TestDatadogUsesDecimalIDs(t *testing.T) {
+	h := http.Header{}
+	datadogPropagator{}.Inject(testTraceContext, h)
+
+	assert.Equal(t, "9532127138774266268", h.Get("x-datadog-trace-id"))
+	assert.Equal(t, "13235353014750950193", h.Get("x-datadog-parent-id"))
+	assert.Equal(t, "_dd.p.tid=0af7651916cd43dd", h.Get("x-datadog-tags"))
+}
+
+func TestBaggageRoundTrip(t *testing.T) {
+	h := http.Header{}
+	baggagePropagator{}.Inject(TraceContext{Baggage: map[string]string{"user": "nic jackson"}}, h)
+
+	tc, ok := baggagePropagator{}.Extract(h)
+	assert.False(t, ok)
+	assert.Equal(t, "nic jackson", tc.Baggage["user"])
+}
+
+func TestPropagationExtractsConfiguredFormat(t *testing.T) {
+	setupPropagation(t, []string{PropagationB3}, nil)
+
+	h := http.Header{}
+	h.Set("b3", "0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-1")
+
+	sc, err := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+	require.NoError(t, err)
+
+	sd := GetOTelSpanDetails(sc)
+	require.NotNil(t, sd)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", sd.TraceID)
+}
+
+func TestPropagationIgnoresFormatsNotConfigured(t *testing.T) {
+	setupPropagation(t, []string{PropagationB3}, nil)
+
+	h := http.Header{}
+	h.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+
+	_, err := opentracing.GlobalTracer().Extract(opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+	assert.ErrorIs(t, err, opentracing.ErrSpanContextNotFound)
+}
+
+func TestPropagationInjectsAllConfiguredFormats(t *testing.T) {
+	setupPropagation(t, nil, []string{PropagationTraceContext, PropagationB3Multi, PropagationJaeger})
+
+	s := opentracing.StartSpan("call_upstream")
+	defer s.Finish()
+
+	sd := GetOTelSpanDetails(s.Context())
+	require.NotNil(t, sd)
+
+	h := http.Header{}
+	err := opentracing.GlobalTracer().Inject(s.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(h))
+	require.NoError(t, err)
+
+	assert.Contains(t, h.Get("traceparent"), sd.TraceID)
+	assert.Equal(t, sd.TraceID, h.Get("X-B3-TraceId"))
+	assert.Equal(t, sd.SpanID, h.Get("X-B3-SpanId"))
+	assert.Contains(t, h.Get("uber-trace-id"), sd.TraceID+":"+sd.SpanID)
+}
@@ -0,0 +1,202 @@
+package tracing
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+	"go.opentelemetry.io/otel/exporters/zipkin"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// DefaultMaxTraces is the number of traces kept by a SpanStore when no
+// limit is set
+const DefaultMaxTraces = 1000
+
+// SpanStore keeps the most recent traces in memory, spans are grouped by
+// trace id and when the store is full the oldest trace is removed. SpanStore
+// implements the Zipkin reporter and the OpenTelemetry span processor so that
+// it can receive the spans created by the service with either tracer.
+type SpanStore struct {
+	mutex     sync.RWMutex
+	maxTraces int
+	traces    map[model.TraceID][]model.SpanModel
+	// order is the trace ids in the order they were first received
+	order []model.TraceID
+}
+
+// TraceQuery filters the traces returned by a SpanStore
+type TraceQuery struct {
+	// ServiceName only returns traces containing a span from the service
+	ServiceName string
+	// Limit is the maximum number of traces to return
+	Limit int
+}
+
+var _ reporter.Reporter = &SpanStore{}
+var _ sdktrace.SpanProcessor = &SpanStore{}
+
+// NewSpanStore creates a store which keeps up to maxTraces traces
+func NewSpanStore(maxTraces int) *SpanStore {
+	if maxTraces < 1 {
+		maxTraces = DefaultMaxTraces
+	}
+
+	return &SpanStore{
+		maxTraces: maxTraces,
+		traces:    map[model.TraceID][]model.SpanModel{},
+	}
+}
+
+// Send adds the span to the store
+func (s *SpanStore) Send(span model.SpanModel) {
+	s.Add([]model.SpanModel{span})
+}
+
+// Close implements the Zipkin reporter interface
+func (s *SpanStore) Close() error {
+	return nil
+}
+
+// OnStart implements the OpenTelemetry span processor interface, spans are
+// only added once they have ended
+func (s *SpanStore) OnStart(ctx context.Context, span sdktrace.ReadWriteSpan) {}
+
+// OnEnd adds the OpenTelemetry span to the store
+func (s *SpanStore) OnEnd(span sdktrace.ReadOnlySpan) {
+	s.Add(zipkin.SpanModels([]sdktrace.ReadOnlySpan{span}))
+}
+
+// Shutdown implements the OpenTelemetry span processor interface
+func (s *SpanStore) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// ForceFlush implements the OpenTelemetry span processor interface
+func (s *SpanStore) ForceFlush(ctx context.Context) error {
+	return nil
+}
+
+// Add spans to the store
+func (s *SpanStore) Add(spans []model.SpanModel) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, span := range spans {
+		id := span.TraceID
+
+		if _, ok := s.traces[id]; !ok {
+			s.order = append(s.order, id)
+		}
+
+		s.traces[id] = append(s.traces[id], span)
+	}
+
+	// remove the oldest traces
+	for len(s.order) > s.maxTraces {
+		delete(s.traces, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Trace returns the spans for the trace ordered by start time, false is
+// returned when the trace does not exist
+func (s *SpanStore) Trace(id model.TraceID) ([]model.SpanModel, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	spans, ok := s.traces[id]
+	if !ok {
+		return nil, false
+	}
+
+	return sortSpans(spans), true
+}
+
+// Traces returns the traces matching the query, the most recent trace is
+// returned first
+func (s *SpanStore) Traces(q TraceQuery) [][]model.SpanModel {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	traces := [][]model.SpanModel{}
+
+	for i := len(s.order) - 1; i >= 0; i-- {
+		if q.Limit > 0 && len(traces) >= q.Limit {
+			break
+		}
+
+		spans := s.traces[s.order[i]]
+		if q.ServiceName != "" && !hasService(spans, q.ServiceName) {
+			continue
+		}
+
+		traces = append(traces, sortSpans(spans))
+	}
+
+	return traces
+}
+
+// Services returns the names of the services which have sent spans
+func (s *SpanStore) Services() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := map[string]bool{}
+	for _, spans := range s.traces {
+		for _, span := range spans {
+			if span.LocalEndpoint != nil && span.LocalEndpoint.ServiceName != "" {
+				names[span.LocalEndpoint.ServiceName] = true
+			}
+		}
+	}
+
+	services := []string{}
+	for n := range names {
+		services = append(services, n)
+	}
+
+	sort.Strings(services)
+
+	return services
+}
+
+func hasService(spans []model.SpanModel, name string) bool {
+	for _, span := range spans {
+		if span.LocalEndpoint != nil && strings.EqualFold(span.LocalEndpoint.ServiceName, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortSpans returns a copy of the spans ordered by start time
+func sortSpans(spans []model.SpanModel) []model.SpanModel {
+	sorted := append([]model.SpanModel{}, spans...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	return sorted
+}
+
+// multiReporter sends spans to each of the reporters
+type multiReporter []reporter.Reporter
+
+func (m multiReporter) Send(span model.SpanModel) {
+	for _, r := range m {
+		r.Send(span)
+	}
+}
+
+func (m multiReporter) Close() error {
+	for _, r := range m {
+		r.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func testSpan(trace uint64, id uint64, service string, start time.Time) model.SpanModel {
+	return model.SpanModel{
+		SpanContext: model.SpanContext{
+			TraceID: model.TraceID{Low: trace},
+			ID:      model.ID(id),
+		},
+		Name:          "handle_request",
+		Timestamp:     start,
+		Duration:      time.Millisecond,
+		LocalEndpoint: &model.Endpoint{ServiceName: service},
+	}
+}
+
+func TestSpanStoreGroupsSpansByTrace(t *testing.T) {
+	s := NewSpanStore(10)
+	now := time.Now()
+
+	s.Send(testSpan(1, 2, "api", now.Add(time.Millisecond)))
+	s.Send(testSpan(1, 1, "web", now))
+
+	spans, ok := s.Trace(model.TraceID{Low: 1})
+	require.True(t, ok)
+	require.Len(t, spans, 2)
+
+	// ordered by start time
+	assert.Equal(t, model.ID(1), spans[0].ID)
+	assert.Equal(t, model.ID(2), spans[1].ID)
+}
+
+func TestSpanStoreRemovesOldestTrace(t *testing.T) {
+	s := NewSpanStore(2)
+	now := time.Now()
+
+	s.Add([]model.SpanModel{
+		testSpan(1, 1, "web", now),
+		testSpan(2, 2, "web", now),
+		testSpan(3, 3, "web", now),
+	})
+
+	_, ok := s.Trace(model.TraceID{Low: 1})
+	assert.False(t, ok)
+
+	traces := s.Traces(TraceQuery{})
+	require.Len(t, traces, 2)
+
+	// most recent first
+	assert.Equal(t, model.TraceID{Low: 3}, traces[0][0].TraceID)
+}
+
+func TestSpanStoreFiltersTracesByService(t *testing.T) {
+	s := NewSpanStore(10)
+	now := time.Now()
+
+	s.Add([]model.SpanModel{
+		testSpan(1, 1, "web", now),
+		testSpan(1, 2, "api", now),
+		testSpan(2, 3, "web", now),
+	})
+
+	assert.Len(t, s.Traces(TraceQuery{ServiceName: "api"}), 1)
+	assert.Len(t, s.Traces(TraceQuery{Limit: 1}), 1)
+	assert.Equal(t, []string{"api", "web"}, s.Services())
+}
+
+func TestSpanStoreReceivesOpenTelemetrySpans(t *testing.T) {
+	s := NewSpanStore(10)
+
+	res, err := OTLPResource("web")
+	require.NoError(t, err)
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(s), sdktrace.WithResource(res))
+	_, span := tp.Tracer("test").Start(context.Background(), "handle_request")
+	span.End()
+
+	traces := s.Traces(TraceQuery{ServiceName: "web"})
+	require.Len(t, traces, 1)
+	require.Len(t, traces[0], 1)
+
+	assert.Equal(t, "handle_request", traces[0][0].Name)
+	assert.Equal(t, []string{"web"}, s.Services())
+}
@@ -26,16 +26,28 @@ type Client interface {
 type OpenTracingClient struct {
 }
 
-// NewOpenTracingClient creates a new open tracing client
-func NewOpenTracingClient(uri, name, serviceURI string) Client {
+// NewOpenTracingClient creates a new open tracing client, spans are sent to
+// the Zipkin collector at uri and to any additional reporters. When uri is
+// empty spans are only sent to the additional reporters.
+func NewOpenTracingClient(uri, name, serviceURI string, reporters ...reporter.Reporter) Client {
 	var reporter reporter.Reporter
 
 	if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
 		reporter = zipkinhttp.NewReporter(fmt.Sprintf("%s/api/v2/spans", uri))
-	} else {
+	} else if uri != "" || len(reporters) == 0 {
 		reporter = logreporter.NewReporter(log.New(os.Stderr, "", log.LstdFlags))
 	}
 
+	if reporter != nil {
+		reporters = append(reporters, reporter)
+	}
+
+	if len(reporters) == 1 {
+		reporter = reporters[0]
+	} else {
+		reporter = multiReporter(reporters)
+	}
+
 	// create our local service endpoint
 	endpoint, err := zipkin.NewEndpoint(name, serviceURI)
 	if err != nil {
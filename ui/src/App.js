@@ -11,6 +11,7 @@ import Row from 'react-bootstrap/Row';
 import Col from 'react-bootstrap/Col';
 
 import BottomPanel from './components/bottom_panel';
+import Traces from './components/traces';
 
 class App extends React.Component {
 
@@ -24,11 +25,17 @@ class App extends React.Component {
     this.state = {
       baseUrl: baseUrl,
       url: baseUrl,
-      refresh: new Date().getMilliseconds()
+      refresh: new Date().getMilliseconds(),
+      view: "topology"
     };
 
     this.pathChanged = this.pathChanged.bind(this);
     this.goClick = this.goClick.bind(this);
+    this.viewClick = this.viewClick.bind(this);
+  }
+
+  viewClick(e) {
+    this.setState({ view: (this.state.view === "topology") ? "traces" : "topology" });
   }
 
   pathChanged(e) {
@@ -76,12 +83,18 @@ class App extends React.Component {
                     <Col sm="1">
                       <Button column variant="outline-success" onClick={this.goClick}>Go</Button>
                     </Col>
+                    <Col sm="1">
+                      <Button column variant="outline-secondary" onClick={this.viewClick}>
+                        {(this.state.view === "topology") ? "Traces" : "Topology"}
+                      </Button>
+                    </Col>
                 </Form.Group>
               </Form>
             </Navbar.Collapse>
           </Container>
         </Navbar>
-        <Timeline url={this.state.url} refresh={this.state.refresh} />
+        {this.state.view === "topology" && <Timeline url={this.state.url} refresh={this.state.refresh} />}
+        {this.state.view === "traces" && <Traces baseUrl={this.state.baseUrl} />}
         <BottomPanel></BottomPanel>
       </div>
     );
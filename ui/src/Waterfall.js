@@ -0,0 +1,108 @@
+// formatDuration converts a duration in microseconds to a readable string
+export const formatDuration = (us) => {
+  if (us >= 1000000) {
+    return (us / 1000000).toFixed(2) + "s";
+  }
+
+  if (us >= 1000) {
+    return (us / 1000).toFixed(2) + "ms";
+  }
+
+  return us + "µs";
+}
+
+function serviceName(span) {
+  return (span.localEndpoint && span.localEndpoint.serviceName) ? span.localEndpoint.serviceName : "unknown";
+}
+
+function isError(span) {
+  return span.tags !== undefined && span.tags.error !== undefined;
+}
+
+// summarizeTrace returns the details shown in the list of traces
+export const summarizeTrace = (spans) => {
+  var start = Math.min(...spans.map(s => s.timestamp || 0));
+  var end = Math.max(...spans.map(s => (s.timestamp || 0) + (s.duration || 0)));
+
+  // the root span has no parent, when it has not been received use the
+  // first span
+  var root = spans.find(s => !s.parentId) || spans[0];
+  var services = new Set(spans.map(serviceName));
+
+  return {
+    traceId: spans[0].traceId,
+    name: root.name,
+    service: serviceName(root),
+    start: start,
+    duration: formatDuration(end - start),
+    spans: spans.length,
+    services: services.size,
+    error: spans.some(isError),
+  };
+}
+
+// spanKey returns a unique key for the span, with Zipkin B3 propagation the
+// server span of an RPC shares the id of the client span
+function spanKey(span) {
+  return span.shared ? span.id + ":shared" : span.id;
+}
+
+// processTrace converts the spans for a trace into the rows of a waterfall,
+// children follow their parent ordered by start time. offset and width are
+// percentages of the trace duration.
+export const processTrace = (spans) => {
+  var start = Math.min(...spans.map(s => s.timestamp || 0));
+  var end = Math.max(...spans.map(s => (s.timestamp || 0) + (s.duration || 0)));
+  var total = Math.max(end - start, 1);
+
+  var keys = new Set(spans.map(spanKey));
+  var children = {};
+  var roots = [];
+
+  for (const s of spans) {
+    // a shared span is the child of the client span with the same id, the
+    // children of a shared span were created by the server
+    var parent = s.parentId;
+    if (s.shared && keys.has(s.id)) {
+      parent = s.id;
+    } else if (parent && keys.has(parent + ":shared")) {
+      parent = parent + ":shared";
+    }
+
+    // spans where the parent has not been received are shown as roots
+    if (parent && keys.has(parent)) {
+      children[parent] = (children[parent] || []).concat([s]);
+    } else {
+      roots.push(s);
+    }
+  }
+
+  var byStart = (a, b) => (a.timestamp || 0) - (b.timestamp || 0);
+  var rows = [];
+
+  function addRow(span, depth) {
+    var duration = span.duration || 0;
+
+    rows.push({
+      id: spanKey(span),
+      name: span.name,
+      service: serviceName(span),
+      depth: depth,
+      offset: ((span.timestamp || 0) - start) / total * 100,
+      width: Math.max(duration / total * 100, 0.5),
+      duration: formatDuration(duration),
+      error: isError(span),
+      tags: span.tags || {},
+    });
+
+    for (const c of (children[spanKey(span)] || []).sort(byStart)) {
+      addRow(c, depth + 1);
+    }
+  }
+
+  for (const r of roots.sort(byStart)) {
+    addRow(r, 0);
+  }
+
+  return { duration: formatDuration(end - start), rows: rows };
+}
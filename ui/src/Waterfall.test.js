@@ -0,0 +1,82 @@
+import { processTrace, summarizeTrace, formatDuration } from './Waterfall'
+
+const trace = [
+  {
+    traceId: "0af7651916cd43dd8448eb211c80319c",
+    id: "2",
+    parentId: "1",
+    name: "call_upstream",
+    timestamp: 1000250,
+    duration: 500,
+    localEndpoint: { serviceName: "web" },
+  },
+  {
+    traceId: "0af7651916cd43dd8448eb211c80319c",
+    id: "1",
+    name: "handle_request",
+    timestamp: 1000000,
+    duration: 1000,
+    localEndpoint: { serviceName: "web" },
+  },
+  {
+    traceId: "0af7651916cd43dd8448eb211c80319c",
+    id: "3",
+    parentId: "2",
+    name: "handle_request",
+    timestamp: 1000500,
+    duration: 200,
+    localEndpoint: { serviceName: "api" },
+    tags: { error: "true" },
+  },
+]
+
+it('formats durations', () => {
+  expect(formatDuration(500)).toEqual("500µs");
+  expect(formatDuration(1500)).toEqual("1.50ms");
+  expect(formatDuration(2500000)).toEqual("2.50s");
+});
+
+it('summarizes the trace using the root span', () => {
+  var s = summarizeTrace(trace);
+
+  expect(s.name).toEqual("handle_request");
+  expect(s.service).toEqual("web");
+  expect(s.duration).toEqual("1.00ms");
+  expect(s.spans).toEqual(3);
+  expect(s.services).toEqual(2);
+  expect(s.error).toEqual(true);
+});
+
+it('orders the rows with children after their parent', () => {
+  var w = processTrace(trace);
+
+  expect(w.rows.map(r => r.id)).toStrictEqual(["1", "2", "3"]);
+  expect(w.rows.map(r => r.depth)).toStrictEqual([0, 1, 2]);
+});
+
+it('calculates the offset and width as a percentage of the trace', () => {
+  var w = processTrace(trace);
+
+  expect(w.rows[0].offset).toEqual(0);
+  expect(w.rows[0].width).toEqual(100);
+  expect(w.rows[1].offset).toEqual(25);
+  expect(w.rows[1].width).toEqual(50);
+});
+
+it('shows spans without a received parent as roots', () => {
+  var w = processTrace([trace[0], trace[2]]);
+
+  expect(w.rows.map(r => r.depth)).toStrictEqual([0, 1]);
+});
+
+it('shows the shared server span as a child of the client span', () => {
+  var w = processTrace([
+    { traceId: "a", id: "1", name: "handle_request", timestamp: 0, duration: 100, localEndpoint: { serviceName: "web" } },
+    { traceId: "a", id: "2", parentId: "1", name: "call_upstream", timestamp: 10, duration: 80, localEndpoint: { serviceName: "web" } },
+    { traceId: "a", id: "2", parentId: "1", shared: true, name: "handle_request", timestamp: 20, duration: 60, localEndpoint: { serviceName: "api" } },
+    { traceId: "a", id: "3", parentId: "2", name: "call_upstream", timestamp: 30, duration: 10, localEndpoint: { serviceName: "api" } },
+  ]);
+
+  expect(w.rows.map(r => r.id)).toStrictEqual(["1", "2", "2:shared", "3"]);
+  expect(w.rows.map(r => r.depth)).toStrictEqual([0, 1, 2, 3]);
+});
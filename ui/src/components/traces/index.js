@@ -0,0 +1,150 @@
+import React from 'react';
+import './index.css';
+
+import Container from 'react-bootstrap/Container';
+import Table from 'react-bootstrap/Table';
+import Button from 'react-bootstrap/Button';
+import { processTrace, summarizeTrace } from '../../Waterfall';
+
+class Traces extends React.Component {
+
+  constructor(props) {
+    super(props)
+
+    this.state = {
+      traces: [],
+      selected: null,
+      error: null,
+    }
+
+    this.refreshClick = this.refreshClick.bind(this)
+  }
+
+  componentDidMount() {
+    this.fetchTraces();
+  }
+
+  // apiURL returns the url for the path of the trace collector API
+  apiURL(path) {
+    var url = this.props.baseUrl;
+    if (!url.endsWith("/")) {
+      url = url + "/";
+    }
+
+    return url + path;
+  }
+
+  fetchTraces() {
+    fetch(this.apiURL("api/v2/traces?limit=50"))
+      .then(res => {
+        if (!res.ok) {
+          throw new Error("trace collector returned " + res.status + ", is TRACING_COLLECTOR enabled?");
+        }
+
+        return res.json();
+      })
+      .then(
+        (result) => {
+          this.setState({ traces: result, error: null });
+        },
+        (error) => {
+          console.error("error fetching traces", error);
+          this.setState({ error: error.message });
+        }
+      );
+  }
+
+  refreshClick(e) {
+    this.setState({ selected: null });
+    this.fetchTraces();
+  }
+
+  traceClicked(spans) {
+    this.setState({ selected: spans });
+  }
+
+  renderList() {
+    const rows = this.state.traces.map((spans) => {
+      const s = summarizeTrace(spans);
+
+      return (
+        <tr key={s.traceId} className={s.error ? "trace-error" : ""} onClick={() => this.traceClicked(spans)}>
+          <td>{new Date(s.start / 1000).toLocaleTimeString()}</td>
+          <td>{s.service}</td>
+          <td>{s.name}</td>
+          <td>{s.duration}</td>
+          <td>{s.spans}</td>
+          <td>{s.services}</td>
+        </tr>
+      );
+    });
+
+    return (
+      <Table hover size="sm" className="trace-list">
+        <thead>
+          <tr>
+            <th>Start</th>
+            <th>Service</th>
+            <th>Operation</th>
+            <th>Duration</th>
+            <th>Spans</th>
+            <th>Services</th>
+          </tr>
+        </thead>
+        <tbody>{rows}</tbody>
+      </Table>
+    );
+  }
+
+  renderWaterfall() {
+    const w = processTrace(this.state.selected);
+
+    const rows = w.rows.map((r) => {
+      const tags = Object.entries(r.tags).map(([k, v]) => k + "=" + v).join(", ");
+
+      return (
+        <div key={r.id} className="waterfall-row" title={tags}>
+          <div className="waterfall-label" style={{ paddingLeft: (r.depth * 16) + "px" }}>
+            <span className="waterfall-service">{r.service}</span> {r.name}
+          </div>
+          <div className="waterfall-timeline">
+            <div
+              className={r.error ? "waterfall-bar waterfall-bar-error" : "waterfall-bar"}
+              style={{ marginLeft: r.offset + "%", width: r.width + "%" }}>
+            </div>
+            <span className="waterfall-duration">{r.duration}</span>
+          </div>
+        </div>
+      );
+    });
+
+    return (
+      <div className="waterfall">
+        <div className="d-flex waterfall-header">
+          <div>Trace {this.state.selected[0].traceId} ({w.duration})</div>
+          <div className="ms-auto">
+            <Button size="sm" variant="outline-secondary" onClick={() => this.setState({ selected: null })}>Back</Button>
+          </div>
+        </div>
+        {rows}
+      </div>
+    );
+  }
+
+  render() {
+    return (
+      <Container className="traces">
+        <div className="d-flex traces-header">
+          <div>Traces</div>
+          <div className="ms-auto">
+            <Button size="sm" variant="outline-success" onClick={this.refreshClick}>Refresh</Button>
+          </div>
+        </div>
+        {this.state.error && <div className="traces-message">{this.state.error}</div>}
+        {this.state.selected ? this.renderWaterfall() : this.renderList()}
+      </Container>
+    )
+  }
+}
+
+export default Traces;
@@ -0,0 +1,92 @@
+package worker
+
+import (
+	"sync"
+	"time"
+)
+
+// Stage is a set of groups of upstreams, the groups are called in parallel
+// and the upstreams in each group are called in order
+type Stage struct {
+	Name   string
+	Groups [][]string
+}
+
+// Plan is an ordered list of stages, a stage is only started when all the
+// calls in the previous stage have completed without error
+type Plan []Stage
+
+// StageResult contains the responses from the upstreams called in a stage
+type StageResult struct {
+	Name      string
+	StartTime time.Time
+	EndTime   time.Time
+	Responses []Done
+	// Skipped is true when the stage was not run because a previous stage
+	// returned an error
+	Skipped bool
+	Error   error
+}
+
+// Do runs the stages of the plan in order calling f for each upstream,
+// if any call in a stage returns an error the remaining stages are skipped
+// and the first error is returned
+func (p Plan) Do(f WorkFunc) ([]StageResult, error) {
+	results := []StageResult{}
+
+	var err error
+	for _, s := range p {
+		if err != nil {
+			results = append(results, StageResult{Name: s.Name, Skipped: true})
+			continue
+		}
+
+		r := s.do(f)
+		err = r.Error
+
+		results = append(results, r)
+	}
+
+	return results, err
+}
+
+func (s Stage) do(f WorkFunc) StageResult {
+	r := StageResult{Name: s.Name, StartTime: time.Now()}
+
+	// collect the responses for each group separately so that the responses
+	// are returned in the same order as the plan
+	responses := make([][]Done, len(s.Groups))
+	errors := make([]error, len(s.Groups))
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(s.Groups))
+
+	for i, g := range s.Groups {
+		go func(i int, uris []string) {
+			defer wg.Done()
+
+			for _, uri := range uris {
+				resp, err := f(uri)
+				responses[i] = append(responses[i], Done{uri, resp})
+
+				if err != nil && errors[i] == nil {
+					errors[i] = err
+				}
+			}
+		}(i, g)
+	}
+
+	wg.Wait()
+
+	for i := range s.Groups {
+		r.Responses = append(r.Responses, responses[i]...)
+
+		if errors[i] != nil && r.Error == nil {
+			r.Error = errors[i]
+		}
+	}
+
+	r.EndTime = time.Now()
+
+	return r
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanRunsStagesInOrder(t *testing.T) {
+	mutex := sync.Mutex{}
+	calls := []string{}
+
+	p := Plan{
+		{Name: "first", Groups: [][]string{{"a"}}},
+		{Name: "second", Groups: [][]string{{"b"}, {"c"}}},
+		{Name: "third", Groups: [][]string{{"d"}}},
+	}
+
+	results, err := p.Do(func(uri string) (*response.Response, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		calls = append(calls, uri)
+		return &response.Response{}, nil
+	})
+
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, "a", calls[0])
+	assert.ElementsMatch(t, []string{"b", "c"}, calls[1:3])
+	assert.Equal(t, "d", calls[3])
+
+	assert.Equal(t, "b", results[1].Responses[0].URI)
+	assert.Equal(t, "c", results[1].Responses[1].URI)
+}
+
+func TestPlanRunsGroupsInParallel(t *testing.T) {
+	p := Plan{
+		{Groups: [][]string{{"a"}, {"b"}}},
+	}
+
+	st := time.Now()
+	_, err := p.Do(func(uri string) (*response.Response, error) {
+		time.Sleep(20 * time.Millisecond)
+		return &response.Response{}, nil
+	})
+
+	require.NoError(t, err)
+	assert.Less(t, time.Since(st), 40*time.Millisecond)
+}
+
+func TestPlanRunsCallsInGroupInOrder(t *testing.T) {
+	calls := []string{}
+
+	p := Plan{
+		{Groups: [][]string{{"a", "b", "c"}}},
+	}
+
+	_, err := p.Do(func(uri string) (*response.Response, error) {
+		calls = append(calls, uri)
+		return &response.Response{}, nil
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, calls)
+}
+
+func TestPlanSkipsStagesAfterError(t *testing.T) {
+	callCount := 0
+
+	p := Plan{
+		{Name: "first", Groups: [][]string{{"a"}}},
+		{Name: "second", Groups: [][]string{{"b"}}},
+	}
+
+	results, err := p.Do(func(uri string) (*response.Response, error) {
+		callCount++
+		return &response.Response{}, fmt.Errorf("boom")
+	})
+
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 1, callCount)
+	require.Len(t, results, 2)
+	assert.EqualError(t, results[0].Error, "boom")
+	assert.True(t, results[1].Skipped)
+}